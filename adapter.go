@@ -28,7 +28,9 @@ type Receiver interface {
 
 // Sender can be used to send messages
 type Sender interface {
-	Send(ctx context.Context, m *Message)
+	// Send sends m, and returns the adapter's identifier for the sent
+	// message, or "" if it has none.
+	Send(ctx context.Context, m *Message) string
 }
 
 // TextOnly is an interface to hint to handlers that the adapter
@@ -45,6 +47,71 @@ func IsTextOnly(s Sender) bool {
 	return ok
 }
 
+// Editor is implemented by adapters that can change messages after they
+// have been sent. The message to change is identified by its Channel, and
+// the ID returned when it was sent.
+type Editor interface {
+	Sender
+	Update(ctx context.Context, m *Message) error                // Replaces the content of a message
+	Delete(ctx context.Context, m *Message) error                // Deletes a message
+	React(ctx context.Context, m *Message, emoji string) error   // Adds an emoji reaction to a message
+	Unreact(ctx context.Context, m *Message, emoji string) error // Removes an emoji reaction from a message
+}
+
+// editor returns the Editor that will handle messages sent via s, looking
+// through any ResponseWriters.
+func editor(s Sender) (Editor, bool) {
+	for {
+		rw, ok := s.(*responseWriter)
+		if !ok {
+			break
+		}
+		s = rw.snd
+	}
+	e, ok := s.(Editor)
+	return e, ok
+}
+
+// Update replaces the content of the message m.ID, if s is an Editor, or
+// a ResponseWriter for one. Otherwise m is sent as a new message. The ID of the message now holding
+// the content is returned.
+func Update(ctx context.Context, s Sender, m *Message) (string, error) {
+	if e, ok := editor(s); ok && m.ID != "" {
+		return m.ID, e.Update(ctx, m)
+	}
+	nm := *m
+	nm.ID = ""
+	return s.Send(ctx, &nm), nil
+}
+
+// Delete deletes the message m.ID, if s is an Editor. Otherwise it does
+// nothing.
+func Delete(ctx context.Context, s Sender, m *Message) error {
+	if e, ok := editor(s); ok && m.ID != "" {
+		return e.Delete(ctx, m)
+	}
+	return nil
+}
+
+// React adds an emoji reaction to the message m.ID, if s is an Editor.
+// Otherwise the emoji is sent as a reply to m.
+func React(ctx context.Context, s Sender, m *Message, emoji string) error {
+	if e, ok := editor(s); ok && m.ID != "" {
+		return e.React(ctx, m, emoji)
+	}
+	s.Send(ctx, m.Reply(":"+emoji+":"))
+	return nil
+}
+
+// Unreact removes an emoji reaction from the message m.ID, if s is an
+// Editor. Otherwise it does nothing.
+func Unreact(ctx context.Context, s Sender, m *Message, emoji string) error {
+	if e, ok := editor(s); ok && m.ID != "" {
+		return e.Unreact(ctx, m, emoji)
+	}
+	return nil
+}
+
 // Adapter can be used to communicate with an external chat system such as
 // slack or IRC.
 type Adapter interface {
@@ -52,6 +119,15 @@ type Adapter interface {
 	Receiver
 }
 
+// Completer is implemented by adapters that need to know when a received
+// message has been dealt with, for instance to close a session that was
+// opened to run a single command. err is the error returned by the
+// handler, if any.
+type Completer interface {
+	Adapter
+	Complete(ctx context.Context, m *Message, err error)
+}
+
 // ChannelManager is implemented by adapters that allow us to manage channels
 type ChannelManager interface {
 	Adapter
@@ -59,5 +135,42 @@ type ChannelManager interface {
 	Invite(channel string, user string) error
 	CreateChannel(channel string) error
 	LeaveChannel(channel string) error
-	SetChannelTopic(channel, topic string)
+	SetChannelTopic(channel, topic string) error
+}
+
+// Directory is implemented by adapters that can look up the IDs of users
+// and channels from the names, or mentions, that people type.
+type Directory interface {
+	Adapter
+	UserID(user string) (string, error)       // Returns the ID matching UserID of user's messages
+	ChannelID(channel string) (string, error) // Returns the ID used as the Channel of messages
+}
+
+// ResolveUser returns the ID of user, if the adapter in ctx is a
+// Directory. Otherwise user is assumed to be an ID, and is returned
+// unchanged.
+func ResolveUser(ctx context.Context, user string) (string, error) {
+	if d, ok := directory(ctx); ok {
+		return d.UserID(user)
+	}
+	return user, nil
+}
+
+// ResolveChannel returns the ID of channel, if the adapter in ctx is a
+// Directory. Otherwise channel is assumed to be an ID, and is returned
+// unchanged.
+func ResolveChannel(ctx context.Context, channel string) (string, error) {
+	if d, ok := directory(ctx); ok {
+		return d.ChannelID(channel)
+	}
+	return channel, nil
+}
+
+func directory(ctx context.Context) (Directory, bool) {
+	a, ok := AdapterFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	d, ok := a.(Directory)
+	return d, ok
 }
@@ -0,0 +1,84 @@
+package hugot_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/hugottest"
+)
+
+type testEditor struct {
+	*hugottest.ResponseRecorder
+	updated []hugot.Message
+	reacts  []string
+}
+
+func (e *testEditor) Update(ctx context.Context, m *hugot.Message) error {
+	e.updated = append(e.updated, *m)
+	return nil
+}
+
+func (e *testEditor) Delete(ctx context.Context, m *hugot.Message) error {
+	return nil
+}
+
+func (e *testEditor) React(ctx context.Context, m *hugot.Message, emoji string) error {
+	e.reacts = append(e.reacts, emoji)
+	return nil
+}
+
+func (e *testEditor) Unreact(ctx context.Context, m *hugot.Message, emoji string) error {
+	return nil
+}
+
+func TestUpdate_Editor(t *testing.T) {
+	out := make(chan hugot.Message, 10)
+	e := &testEditor{ResponseRecorder: &hugottest.ResponseRecorder{MessagesOut: out}}
+	w := hugot.NewResponseWriter(e, hugot.Message{Channel: "ops"}, "test")
+
+	ctx := context.Background()
+	id := w.Send(ctx, &hugot.Message{Channel: "ops", Text: "deploying..."})
+	<-out
+
+	nid, err := hugot.Update(ctx, w, &hugot.Message{Channel: "ops", ID: id, Text: "deployed"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if nid != id {
+		t.Fatalf("expected id %q, got %q", id, nid)
+	}
+	if len(e.updated) != 1 || e.updated[0].Text != "deployed" {
+		t.Fatalf("expected message to be updated, got %#v", e.updated)
+	}
+
+	hugot.React(ctx, w, &hugot.Message{Channel: "ops", ID: id}, "tada")
+	if len(e.reacts) != 1 || e.reacts[0] != "tada" {
+		t.Fatalf("expected reaction, got %#v", e.reacts)
+	}
+}
+
+func TestUpdate_Fallback(t *testing.T) {
+	out := make(chan hugot.Message, 10)
+	rr := &hugottest.ResponseRecorder{MessagesOut: out}
+
+	ctx := context.Background()
+	id := rr.Send(ctx, &hugot.Message{Channel: "ops", Text: "deploying..."})
+	<-out
+
+	nid, err := hugot.Update(ctx, rr, &hugot.Message{Channel: "ops", ID: id, Text: "deployed"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if nid == id {
+		t.Fatalf("expected a new message to be sent")
+	}
+	if m := <-out; m.Text != "deployed" {
+		t.Fatalf("expected deployed, got %q", m.Text)
+	}
+
+	hugot.React(ctx, rr, &hugot.Message{Channel: "ops", ID: id, From: "bob"}, "tada")
+	if m := <-out; m.Text != ":tada:" {
+		t.Fatalf("expected :tada:, got %q", m.Text)
+	}
+}
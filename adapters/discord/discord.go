@@ -0,0 +1,307 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package discord implements an adapter for https://discord.com, receiving
+// messages over the gateway websocket, and sending them with the REST API.
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
+)
+
+// DefaultAPIURL is the base URL of the Discord REST API.
+const DefaultAPIURL = "https://discord.com/api/v10"
+
+// maxRetries is the number of times a rate limited request is retried.
+const maxRetries = 3
+
+type discord struct {
+	token  string
+	apiURL string
+	client *http.Client
+
+	id   string
+	nick string
+
+	dirPat *regexp.Regexp
+
+	chansLock sync.RWMutex
+	channels  map[string]string // channel name to ID
+
+	// The gateway session, kept so that we can resume it, and not miss
+	// events, after reconnecting.
+	sessLock  sync.Mutex
+	sessionID string
+	resumeURL string
+	seq       *int64
+
+	start sync.Once
+	msgs  chan *hugot.Message
+
+	log hugot.Logger
+}
+
+// Opt functions are used to set options on the discord adapter.
+type Opt func(*discord)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(d *discord) {
+		d.log = l
+	}
+}
+
+// WithAPIURL sets the base URL of the Discord REST API, this is mostly
+// useful for testing. The gateway URL is requested from the API.
+func WithAPIURL(u string) Opt {
+	return func(d *discord) {
+		d.apiURL = strings.TrimSuffix(u, "/")
+	}
+}
+
+// New creates a new adapter that communicates with Discord, using the
+// bot's token. The bot must have the Message Content intent enabled to see
+// the text of messages in guild channels.
+func New(token string, opts ...Opt) (hugot.Adapter, error) {
+	if token == "" {
+		return nil, errors.New("Discord bot token must be set")
+	}
+
+	d := &discord{
+		token:    token,
+		apiURL:   DefaultAPIURL,
+		client:   http.DefaultClient,
+		channels: map[string]string{},
+		msgs:     make(chan *hugot.Message),
+		log:      hugot.DefaultLogger,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.log = d.log.With(hugot.LogAdapter, "discord")
+
+	var me user
+	if err := d.do(context.Background(), "GET", "/users/@me", nil, &me); err != nil {
+		return nil, fmt.Errorf("could not identify bot user, %w", err)
+	}
+	d.id = me.ID
+	d.nick = me.Username
+
+	d.dirPat = dirPattern(d.id, d.nick)
+
+	return d, nil
+}
+
+// dirPattern matches messages addressed to the bot, by name or mention.
+func dirPattern(id, nick string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf("(?m)^(!|(@?%s|<@!?%s>)[:,]? )(.*)", regexp.QuoteMeta(nick), regexp.QuoteMeta(id)))
+}
+
+// Send implements hugot.Sender, returning the ID of the sent message.
+// Channels can be given by ID, or by name.
+func (d *discord) Send(ctx context.Context, m *hugot.Message) string {
+	if m.Channel == "" {
+		d.log.Error("cannot send message without a channel", hugot.LogUser, m.To)
+		return ""
+	}
+
+	cm := createMessage{
+		Content: m.Text,
+		Embeds:  attachmentEmbeds(m.Attachments),
+	}
+	bes, cs := blockEmbeds(m.Blocks)
+	cm.Embeds = append(cm.Embeds, bes...)
+	cm.Components = cs
+	if m.ThreadID != "" {
+		cm.MessageReference = &messageReference{MessageID: m.ThreadID}
+	}
+
+	if cm.Content == "" && len(cm.Embeds) == 0 && len(cm.Components) == 0 {
+		d.log.Info("attempt to send empty message")
+		return ""
+	}
+
+	var res message
+	if err := d.do(ctx, "POST", "/channels/"+d.channelID(m.Channel)+"/messages", cm, &res); err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", d)).Inc()
+		d.log.Error("error sending message", hugot.LogChannel, m.Channel, "error", err)
+		return ""
+	}
+
+	return res.ID
+}
+
+// channelID returns the ID of the channel c, which may be given by
+// name, or ID.
+func (d *discord) channelID(c string) string {
+	n := strings.TrimPrefix(c, "#")
+
+	d.chansLock.RLock()
+	defer d.chansLock.RUnlock()
+	if id, ok := d.channels[n]; ok {
+		return id
+	}
+	return c
+}
+
+// Receive implements hugot.Receiver.
+func (d *discord) Receive() <-chan *hugot.Message {
+	d.start.Do(func() {
+		go d.run()
+	})
+	return d.msgs
+}
+
+// toHugot converts a message received from the gateway to a
+// hugot.Message. Messages from bots, including ourself, are ignored.
+// Replies are given the ID of the message they reply to as their
+// ThreadID.
+func (d *discord) toHugot(dm *message) *hugot.Message {
+	if dm.Author.ID == d.id || dm.Author.Bot {
+		return nil
+	}
+	d.log.Debug("received message", hugot.LogChannel, dm.ChannelID, hugot.LogUser, dm.Author.Username, "text", dm.Content)
+
+	// Messages without a guild are direct messages.
+	private := dm.GuildID == ""
+	tobot := private
+	txt := dm.Content
+
+	// Check if the message was sent @bot, if so, set it as to us
+	// and strip the leading politeness
+	dirMatch := d.dirPat.FindStringSubmatch(txt)
+	if len(dirMatch) > 1 && len(dirMatch[1]) > 0 {
+		tobot = true
+		txt = strings.Trim(dirMatch[3], " ")
+	}
+
+	m := &hugot.Message{
+		Channel: dm.ChannelID,
+		From:    dm.Author.Username,
+		UserID:  dm.Author.ID,
+		ID:      dm.ID,
+		Text:    txt,
+		Private: private,
+		ToBot:   tobot,
+	}
+	if dm.MessageReference != nil {
+		m.ThreadID = dm.MessageReference.MessageID
+	}
+
+	return m
+}
+
+// do makes a request to the REST API. in, if not nil, is sent as the JSON
+// body of the request. out, if not nil, is decoded from the response.
+// Rate limited requests are retried after the delay discord asks for.
+func (d *discord) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; ; i++ {
+		req, err := http.NewRequestWithContext(ctx, method, d.apiURL+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bot "+d.token)
+		req.Header.Set("User-Agent", "DiscordBot (https://github.com/tcolgate/hugot, 1)")
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && i < maxRetries {
+			var rl struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			json.NewDecoder(resp.Body).Decode(&rl)
+			resp.Body.Close()
+
+			wait := time.Duration(rl.RetryAfter * float64(time.Second))
+			d.log.Info("rate limited", "path", path, "retry", wait)
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			var derr struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.NewDecoder(resp.Body).Decode(&derr)
+			return fmt.Errorf("%s %s failed, %s: %s", method, path, resp.Status, derr.Message)
+		}
+
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+type user struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Bot      bool   `json:"bot"`
+}
+
+type message struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+	Author    user   `json:"author"`
+	Content   string `json:"content"`
+
+	MessageReference *messageReference `json:"message_reference,omitempty"`
+}
+
+type messageReference struct {
+	MessageID string `json:"message_id"`
+}
+
+type createMessage struct {
+	Content          string            `json:"content,omitempty"`
+	Embeds           []embed           `json:"embeds,omitempty"`
+	Components       []component       `json:"components,omitempty"`
+	MessageReference *messageReference `json:"message_reference,omitempty"`
+}
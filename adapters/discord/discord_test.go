@@ -0,0 +1,283 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tcolgate/hugot"
+)
+
+// testDiscord returns an adapter for the bot hugot, with ID 100, using
+// the REST API at apiURL.
+func testDiscord(apiURL string) *discord {
+	return &discord{
+		token:    "token",
+		apiURL:   apiURL,
+		client:   http.DefaultClient,
+		id:       "100",
+		nick:     "hugot",
+		dirPat:   dirPattern("100", "hugot"),
+		channels: map[string]string{},
+		msgs:     make(chan *hugot.Message, 10),
+		log:      hugot.DefaultLogger,
+	}
+}
+
+func TestDispatch_Messages(t *testing.T) {
+	var tests = []struct {
+		event string
+		data  string
+		exp   *hugot.Message // nil if the event should be ignored
+	}{
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"101","username":"bob"},"content":"<@100> ping"}`,
+			&hugot.Message{Channel: "200", Text: "ping", ToBot: true}},
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"101","username":"bob"},"content":"<@!100> ping"}`,
+			&hugot.Message{Channel: "200", Text: "ping", ToBot: true}},
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"101","username":"bob"},"content":"hugot, ping"}`,
+			&hugot.Message{Channel: "200", Text: "ping", ToBot: true}},
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"101","username":"bob"},"content":"!ping"}`,
+			&hugot.Message{Channel: "200", Text: "ping", ToBot: true}},
+
+		// Mentions of other users whose IDs start with ours.
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"101","username":"bob"},"content":"<@1001> ping"}`,
+			&hugot.Message{Channel: "200", Text: "<@1001> ping"}},
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"101","username":"bob"},"content":"hello all"}`,
+			&hugot.Message{Channel: "200", Text: "hello all"}},
+
+		// Messages without a guild are direct messages.
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"400","author":{"id":"101","username":"bob"},"content":"status"}`,
+			&hugot.Message{Channel: "400", Text: "status", ToBot: true, Private: true}},
+
+		// Replies are threaded on the message they reply to.
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"101","username":"bob"},"content":"<@100> again","message_reference":{"message_id":"299"}}`,
+			&hugot.Message{Channel: "200", Text: "again", ToBot: true, ThreadID: "299"}},
+
+		// Our own messages, those of other bots, and edits, are ignored.
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"100","username":"hugot","bot":true},"content":"<@100> echo"}`, nil},
+		{"MESSAGE_CREATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"102","username":"other","bot":true},"content":"<@100> ping"}`, nil},
+		{"MESSAGE_UPDATE", `{"id":"301","channel_id":"200","guild_id":"1","author":{"id":"101","username":"bob"},"content":"<@100> ping"}`, nil},
+	}
+
+	d := testDiscord("")
+	for i, tt := range tests {
+		d.dispatch(tt.event, json.RawMessage(tt.data))
+
+		var m *hugot.Message
+		select {
+		case m = <-d.msgs:
+		default:
+		}
+
+		switch {
+		case tt.exp == nil && m != nil:
+			t.Errorf("%d: expected %s to be ignored, got %#v", i, tt.data, m)
+		case tt.exp == nil:
+		case m == nil:
+			t.Errorf("%d: expected %s to be received", i, tt.data)
+		case m.Channel != tt.exp.Channel || m.Text != tt.exp.Text || m.ToBot != tt.exp.ToBot ||
+			m.Private != tt.exp.Private || m.ThreadID != tt.exp.ThreadID || m.ID != "301" || m.From != "bob" || m.UserID != "101":
+			t.Errorf("%d: expected %#v, got %#v", i, tt.exp, m)
+		}
+	}
+}
+
+func TestDispatch_Channels(t *testing.T) {
+	d := testDiscord("")
+	d.dispatch("GUILD_CREATE", json.RawMessage(`{"id":"1","name":"guild","channels":[{"id":"200","name":"general"}]}`))
+	d.dispatch("CHANNEL_CREATE", json.RawMessage(`{"id":"201","name":"ops"}`))
+
+	for c, exp := range map[string]string{"#general": "200", "general": "200", "#ops": "201", "202": "202"} {
+		if id := d.channelID(c); id != exp {
+			t.Errorf("expected %s to be %s, got %s", c, exp, id)
+		}
+	}
+}
+
+// gateway is a fake gateway connection. It sends hello, and passes the
+// client's identify, or resume, to f.
+func gateway(t *testing.T, f func(c *websocket.Conn, p payload)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		up := websocket.Upgrader{}
+		c, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		c.WriteJSON(payload{Op: opHello, Data: json.RawMessage(`{"heartbeat_interval":45000}`)})
+
+		var p payload
+		if err := c.ReadJSON(&p); err != nil {
+			t.Errorf("expected identify or resume, %v", err)
+			return
+		}
+		f(c, p)
+
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func dispatch(c *websocket.Conn, seq int64, t, d string) {
+	c.WriteJSON(payload{Op: opDispatch, Type: t, Sequence: &seq, Data: json.RawMessage(d)})
+}
+
+func TestGateway_Resume(t *testing.T) {
+	ops := make(chan string, 10)
+
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/gateway/bot", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"url":"ws%s/gateway"}`, strings.TrimPrefix(srv.URL, "http"))
+	})
+	mux.HandleFunc("/gateway", gateway(t, func(c *websocket.Conn, p payload) {
+		ops <- fmt.Sprintf("%d %s", p.Op, p.Data)
+		dispatch(c, 1, "READY", fmt.Sprintf(`{"session_id":"s1","resume_gateway_url":"ws%s/resume"}`, strings.TrimPrefix(srv.URL, "http")))
+		dispatch(c, 2, "MESSAGE_CREATE", `{"id":"301","channel_id":"400","author":{"id":"101","username":"bob"},"content":"one"}`)
+		c.WriteJSON(payload{Op: opReconnect})
+	}))
+	mux.HandleFunc("/resume", gateway(t, func(c *websocket.Conn, p payload) {
+		ops <- fmt.Sprintf("%d %s", p.Op, p.Data)
+		dispatch(c, 3, "MESSAGE_CREATE", `{"id":"302","channel_id":"400","author":{"id":"101","username":"bob"},"content":"two"}`)
+		dispatch(c, 4, "RESUMED", `{}`)
+	}))
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	d := testDiscord(srv.URL + "/api")
+	for _, exp := range []string{"one", "two"} {
+		select {
+		case m := <-d.Receive():
+			if m.Text != exp {
+				t.Fatalf("expected %q, got %q", exp, m.Text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", exp)
+		}
+	}
+
+	if op := <-ops; !strings.HasPrefix(op, fmt.Sprintf("%d ", opIdentify)) {
+		t.Fatalf("expected identify on first connection, got %s", op)
+	}
+	var resume struct {
+		Token     string `json:"token"`
+		SessionID string `json:"session_id"`
+		Seq       int64  `json:"seq"`
+	}
+	op := <-ops
+	if !strings.HasPrefix(op, fmt.Sprintf("%d ", opResume)) {
+		t.Fatalf("expected resume after reconnect, got %s", op)
+	}
+	json.Unmarshal([]byte(op[strings.Index(op, " ")+1:]), &resume)
+	if resume.Token != "token" || resume.SessionID != "s1" || resume.Seq != 2 {
+		t.Fatalf("unexpected resume %s", op)
+	}
+}
+
+func TestGateway_InvalidSession(t *testing.T) {
+	var tests = []struct {
+		resumable string
+		session   string
+	}{
+		{"true", "s1"},
+		{"false", ""},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(gateway(t, func(c *websocket.Conn, p payload) {
+			c.WriteJSON(payload{Op: opInvalidSession, Data: json.RawMessage(tt.resumable)})
+		}))
+
+		d := testDiscord("")
+		seq := int64(2)
+		d.sessionID, d.resumeURL, d.seq = "s1", "ws"+strings.TrimPrefix(srv.URL, "http"), &seq
+
+		if err := d.runOnce(); err == nil {
+			t.Errorf("resumable %s: expected invalid session to be an error", tt.resumable)
+		}
+		if d.sessionID != tt.session {
+			t.Errorf("resumable %s: expected session %q, got %q", tt.resumable, tt.session, d.sessionID)
+		}
+		srv.Close()
+	}
+}
+
+func TestSend(t *testing.T) {
+	type posted struct {
+		channel string
+		msg     createMessage
+	}
+	posts := make(chan posted, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cm createMessage
+		if err := json.NewDecoder(r.Body).Decode(&cm); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ch := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/channels/"), "/messages")
+		posts <- posted{ch, cm}
+		io.WriteString(w, `{"id":"900"}`)
+	}))
+	defer srv.Close()
+
+	d := testDiscord(srv.URL + "/api")
+	d.channels["general"] = "200"
+
+	m := &hugot.Message{
+		Channel:  "#general",
+		Text:     "release ready",
+		ThreadID: "302",
+		Attachments: []hugot.Attachment{
+			{Title: "v1.2.3", Color: "good"},
+		},
+		Blocks: []hugot.Block{
+			hugot.Section{Text: "changes", Fields: []hugot.Field{{Title: "Env", Value: "prod"}}},
+			hugot.Actions{Buttons: []hugot.Button{
+				{ID: "approve", Value: "v1.2.3", Text: "Approve", Style: hugot.ButtonPrimary},
+				{Text: "Diff", URL: "http://example.com/diff"},
+			}},
+		},
+	}
+	if id := d.Send(context.Background(), m); id != "900" {
+		t.Fatalf("expected message ID 900, got %q", id)
+	}
+
+	p := <-posts
+	if p.channel != "200" || p.msg.Content != "release ready" {
+		t.Fatalf("unexpected post %#v", p)
+	}
+	if p.msg.MessageReference == nil || p.msg.MessageReference.MessageID != "302" {
+		t.Fatalf("expected reply to 302, got %#v", p.msg.MessageReference)
+	}
+	if len(p.msg.Embeds) != 2 || p.msg.Embeds[0].Title != "v1.2.3" || p.msg.Embeds[0].Color != 0x2eb886 {
+		t.Fatalf("unexpected embeds %#v", p.msg.Embeds)
+	}
+	if e := p.msg.Embeds[1]; e.Description != "changes" || len(e.Fields) != 1 || e.Fields[0].Value != "prod" {
+		t.Fatalf("unexpected block embed %#v", e)
+	}
+	if len(p.msg.Components) != 1 || len(p.msg.Components[0].Components) != 2 {
+		t.Fatalf("unexpected components %#v", p.msg.Components)
+	}
+	if b := p.msg.Components[0].Components[0]; b.CustomID != "approve:v1.2.3" || b.Style != buttonPrimary {
+		t.Fatalf("unexpected button %#v", b)
+	}
+	if b := p.msg.Components[0].Components[1]; b.URL != "http://example.com/diff" || b.Style != buttonLink {
+		t.Fatalf("unexpected button %#v", b)
+	}
+
+	if id := d.Send(context.Background(), &hugot.Message{Channel: "200"}); id != "" {
+		t.Fatalf("expected empty message not to be sent, got %q", id)
+	}
+}
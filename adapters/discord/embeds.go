@@ -0,0 +1,196 @@
+package discord
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tcolgate/hugot"
+)
+
+type embed struct {
+	Title       string       `json:"title,omitempty"`
+	URL         string       `json:"url,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Color       int          `json:"color,omitempty"`
+	Author      *embedAuthor `json:"author,omitempty"`
+	Fields      []embedField `json:"fields,omitempty"`
+	Image       *embedImage  `json:"image,omitempty"`
+	Thumbnail   *embedImage  `json:"thumbnail,omitempty"`
+	Footer      *embedFooter `json:"footer,omitempty"`
+}
+
+type embedAuthor struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type embedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type embedImage struct {
+	URL string `json:"url"`
+}
+
+type embedFooter struct {
+	Text    string `json:"text"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+// component is a message component, either an action row, or a button
+// within one.
+type component struct {
+	Type       int         `json:"type"`
+	Components []component `json:"components,omitempty"`
+	Style      int         `json:"style,omitempty"`
+	Label      string      `json:"label,omitempty"`
+	CustomID   string      `json:"custom_id,omitempty"`
+	URL        string      `json:"url,omitempty"`
+}
+
+// Component types and button styles.
+const (
+	componentActionRow = 1
+	componentButton    = 2
+
+	buttonPrimary   = 1
+	buttonSecondary = 2
+	buttonDanger    = 4
+	buttonLink      = 5
+
+	// maxRowButtons is the number of buttons that fit in an action row.
+	maxRowButtons = 5
+)
+
+// colors maps the named slack attachment colors to RGB values.
+var colors = map[string]int{
+	"good":    0x2eb886,
+	"warning": 0xdaa038,
+	"danger":  0xa30200,
+}
+
+func color(c string) int {
+	if v, ok := colors[c]; ok {
+		return v
+	}
+	v, _ := strconv.ParseInt(strings.TrimPrefix(c, "#"), 16, 32)
+	return int(v)
+}
+
+// attachmentEmbeds renders message attachments as embeds.
+func attachmentEmbeds(as []hugot.Attachment) []embed {
+	var out []embed
+	for _, a := range as {
+		e := embed{
+			Title:       a.Title,
+			URL:         a.TitleLink,
+			Description: strings.TrimSpace(a.Pretext + "\n" + a.Text),
+			Color:       color(a.Color),
+		}
+		if a.AuthorName != "" {
+			e.Author = &embedAuthor{Name: a.AuthorName, URL: a.AuthorLink, IconURL: a.AuthorIcon}
+		}
+		for _, f := range a.Fields {
+			e.Fields = append(e.Fields, embedField{Name: f.Title, Value: f.Value, Inline: f.Short})
+		}
+		if a.ImageURL != "" {
+			e.Image = &embedImage{URL: a.ImageURL}
+		}
+		if a.ThumbURL != "" {
+			e.Thumbnail = &embedImage{URL: a.ThumbURL}
+		}
+		if a.Footer != "" {
+			e.Footer = &embedFooter{Text: a.Footer, IconURL: a.FooterIcon}
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// blockEmbeds renders blocks as embeds, with buttons as components.
+// Headers and dividers start a new embed. Action buttons have a custom ID
+// of the button's ID and value, separated by a colon.
+func blockEmbeds(bs []hugot.Block) ([]embed, []component) {
+	var out []embed
+	var cs []component
+	cur := embed{}
+
+	flush := func() {
+		if cur.Title != "" || cur.Description != "" || cur.Image != nil || len(cur.Fields) > 0 {
+			out = append(out, cur)
+		}
+		cur = embed{}
+	}
+	addText := func(txt string) {
+		if cur.Description != "" {
+			cur.Description += "\n"
+		}
+		cur.Description += txt
+	}
+
+	for _, b := range bs {
+		switch b := b.(type) {
+		case hugot.Header:
+			flush()
+			cur.Title = b.Text
+		case hugot.Section:
+			if b.Text != "" {
+				addText(b.Text)
+			}
+			for _, f := range b.Fields {
+				cur.Fields = append(cur.Fields, embedField{Name: f.Title, Value: f.Value, Inline: true})
+			}
+		case hugot.Image:
+			if cur.Image != nil {
+				flush()
+			}
+			cur.Image = &embedImage{URL: b.URL}
+		case hugot.Code:
+			addText(fmt.Sprintf("```%s\n%s\n```", b.Language, strings.TrimSuffix(b.Text, "\n")))
+		case hugot.Actions:
+			for i := 0; i < len(b.Buttons); i += maxRowButtons {
+				end := i + maxRowButtons
+				if end > len(b.Buttons) {
+					end = len(b.Buttons)
+				}
+				row := component{Type: componentActionRow}
+				for _, bt := range b.Buttons[i:end] {
+					row.Components = append(row.Components, button(bt))
+				}
+				cs = append(cs, row)
+			}
+		case hugot.Divider:
+			flush()
+		}
+	}
+	flush()
+
+	return out, cs
+}
+
+func button(bt hugot.Button) component {
+	c := component{Type: componentButton, Label: bt.Text}
+	if bt.URL != "" {
+		c.Style = buttonLink
+		c.URL = bt.URL
+		return c
+	}
+
+	c.CustomID = bt.ID
+	if bt.Value != "" {
+		c.CustomID += ":" + bt.Value
+	}
+	switch bt.Style {
+	case hugot.ButtonPrimary:
+		c.Style = buttonPrimary
+	case hugot.ButtonDanger:
+		c.Style = buttonDanger
+	default:
+		c.Style = buttonSecondary
+	}
+	return c
+}
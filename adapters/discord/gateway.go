@@ -0,0 +1,264 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Gateway opcodes.
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatACK   = 11
+)
+
+// intents are the gateway events we subscribe to: guilds, guild messages,
+// direct messages, and message content.
+const intents = 1<<0 | 1<<9 | 1<<12 | 1<<15
+
+// payload is the envelope of all gateway messages.
+type payload struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d,omitempty"`
+	Sequence *int64          `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+// run connects to the gateway, reconnecting with a backoff if the
+// connection fails.
+func (d *discord) run() {
+	backoff := minBackoff
+	for {
+		err := d.runOnce()
+		if err == nil {
+			backoff = minBackoff
+			continue
+		}
+
+		d.log.Error("gateway connection failed", "error", err, "retry", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// runOnce processes events from a single gateway connection. A nil error
+// is returned if discord asked us to reconnect. If we have a session from
+// an earlier connection it is resumed, so that events sent while we were
+// disconnected are replayed.
+func (d *discord) runOnce() error {
+	d.sessLock.Lock()
+	sessionID, gwURL, seq := d.sessionID, d.resumeURL, d.seq
+	d.sessLock.Unlock()
+
+	if sessionID == "" || gwURL == "" {
+		var gw struct {
+			URL string `json:"url"`
+		}
+		if err := d.do(context.Background(), "GET", "/gateway/bot", nil, &gw); err != nil {
+			return err
+		}
+		gwURL = gw.URL
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(gwURL+"?v=10&encoding=json", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var hello payload
+	if err := conn.ReadJSON(&hello); err != nil {
+		return err
+	}
+	if hello.Op != opHello {
+		return fmt.Errorf("expected hello from gateway, got op %d", hello.Op)
+	}
+	var hd struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	if err := json.Unmarshal(hello.Data, &hd); err != nil {
+		return err
+	}
+
+	// Writes come from both the heartbeat, and the read loop.
+	var wlock sync.Mutex
+	send := func(op int, data interface{}) error {
+		bs, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		wlock.Lock()
+		defer wlock.Unlock()
+		return conn.WriteJSON(payload{Op: op, Data: bs})
+	}
+
+	if sessionID != "" {
+		resume := map[string]interface{}{
+			"token":      d.token,
+			"session_id": sessionID,
+			"seq":        seq,
+		}
+		if err := send(opResume, resume); err != nil {
+			return err
+		}
+	} else {
+		identify := map[string]interface{}{
+			"token":   d.token,
+			"intents": intents,
+			"properties": map[string]string{
+				"os":      "linux",
+				"browser": "hugot",
+				"device":  "hugot",
+			},
+		}
+		if err := send(opIdentify, identify); err != nil {
+			return err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(time.Duration(hd.HeartbeatInterval) * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := send(opHeartbeat, d.sequence()); err != nil {
+					d.log.Error("heartbeat failed", "error", err)
+					conn.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		var p payload
+		if err := conn.ReadJSON(&p); err != nil {
+			return err
+		}
+
+		if p.Sequence != nil {
+			d.sessLock.Lock()
+			d.seq = p.Sequence
+			d.sessLock.Unlock()
+		}
+
+		switch p.Op {
+		case opDispatch:
+			d.dispatch(p.Type, p.Data)
+		case opHeartbeat:
+			if err := send(opHeartbeat, d.sequence()); err != nil {
+				return err
+			}
+		case opHeartbeatACK:
+		case opReconnect:
+			d.log.Info("reconnect requested")
+			return nil
+		case opInvalidSession:
+			var resumable bool
+			json.Unmarshal(p.Data, &resumable)
+			if !resumable {
+				d.setSession("", "")
+			}
+			return fmt.Errorf("gateway session invalidated")
+		default:
+			d.log.Debug("unexpected gateway message", "op", p.Op)
+		}
+	}
+}
+
+// sequence returns the sequence number of the last event we received.
+func (d *discord) sequence() *int64 {
+	d.sessLock.Lock()
+	defer d.sessLock.Unlock()
+	return d.seq
+}
+
+// setSession records the session to resume after reconnecting. An empty
+// id forgets the session, and we will identify afresh.
+func (d *discord) setSession(id, resumeURL string) {
+	d.sessLock.Lock()
+	defer d.sessLock.Unlock()
+	d.sessionID = id
+	d.resumeURL = resumeURL
+	if id == "" {
+		d.seq = nil
+	}
+}
+
+// dispatch handles gateway events.
+func (d *discord) dispatch(t string, data json.RawMessage) {
+	switch t {
+	case "READY":
+		var r struct {
+			SessionID string `json:"session_id"`
+			ResumeURL string `json:"resume_gateway_url"`
+		}
+		if err := json.Unmarshal(data, &r); err != nil {
+			d.log.Error("could not parse event", "event", t, "error", err)
+			return
+		}
+		d.setSession(r.SessionID, r.ResumeURL)
+		d.log.Info("connected")
+	case "RESUMED":
+		d.log.Info("resumed")
+	case "GUILD_CREATE", "CHANNEL_CREATE", "CHANNEL_UPDATE":
+		var g struct {
+			ID       string `json:"id"`
+			Name     string `json:"name"`
+			Channels []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"channels"`
+		}
+		if err := json.Unmarshal(data, &g); err != nil {
+			d.log.Error("could not parse event", "event", t, "error", err)
+			return
+		}
+
+		d.chansLock.Lock()
+		defer d.chansLock.Unlock()
+		if t != "GUILD_CREATE" {
+			if g.Name != "" {
+				d.channels[g.Name] = g.ID
+			}
+			return
+		}
+		for _, c := range g.Channels {
+			d.channels[c.Name] = c.ID
+		}
+	case "MESSAGE_CREATE":
+		var dm message
+		if err := json.Unmarshal(data, &dm); err != nil {
+			d.log.Error("could not parse message", "error", err)
+			return
+		}
+		if m := d.toHugot(&dm); m != nil {
+			d.msgs <- m
+		}
+	default:
+		d.log.Debug("unhandled gateway event", "event", t)
+	}
+}
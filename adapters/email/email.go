@@ -0,0 +1,409 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package email implements an adapter that receives mail by polling an
+// IMAP mailbox, and sends mail over SMTP.
+//
+// Every mail is treated as a private message to the bot. The Text of the
+// message is the subject, followed by the body of the mail, with quoted
+// replies and signatures removed. The channel of a message is the address
+// replies should be sent to. Replies are sent In-Reply-To the received
+// mail, so that they are threaded by mail clients.
+//
+// Note that the sender of a mail is easily forged, handlers that
+// authorize users by their UserID should only be used with mail systems
+// that verify senders.
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+
+	dialTimeout    = 30 * time.Second
+	commandTimeout = time.Minute
+
+	// DefaultPollInterval is how often the mailbox is checked for new
+	// mail.
+	DefaultPollInterval = time.Minute
+
+	// maxThreads is the number of received mails we remember the subject
+	// and references of, for threading replies.
+	maxThreads = 1000
+)
+
+type email struct {
+	address  string
+	imapAddr string
+	smtpAddr string
+	username string
+	password string
+
+	mailbox   string
+	interval  time.Duration
+	tlsConfig *tls.Config
+	plaintext bool
+
+	threadsLock sync.Mutex
+	threads     map[string]thread
+	threadIDs   []string
+
+	msgs  chan *hugot.Message
+	start sync.Once
+
+	log hugot.Logger
+}
+
+// thread holds what we need to reply to a received mail.
+type thread struct {
+	subject    string
+	references string
+}
+
+// Opt functions are used to set options on the email adapter.
+type Opt func(*email)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(e *email) {
+		e.log = l
+	}
+}
+
+// WithMailbox sets the IMAP mailbox to poll, the default is INBOX.
+func WithMailbox(name string) Opt {
+	return func(e *email) {
+		e.mailbox = name
+	}
+}
+
+// WithPollInterval sets how often the mailbox is checked for new mail.
+func WithPollInterval(d time.Duration) Opt {
+	return func(e *email) {
+		e.interval = d
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for IMAP, and for SMTP
+// STARTTLS.
+func WithTLSConfig(cfg *tls.Config) Opt {
+	return func(e *email) {
+		e.tlsConfig = cfg
+	}
+}
+
+// WithPlaintext disables TLS for IMAP, and allows SMTP servers that do not
+// support STARTTLS. Credentials will be sent unencrypted.
+func WithPlaintext() Opt {
+	return func(e *email) {
+		e.plaintext = true
+	}
+}
+
+// New creates an email adapter. Mail sent to address is read from the
+// IMAP server at imapAddr, which must support implicit TLS, usually on
+// port 993. Mail is sent, from address, via the SMTP server at smtpAddr,
+// using STARTTLS. username and password are used to log in to both.
+func New(address, imapAddr, smtpAddr, username, password string, opts ...Opt) (hugot.Adapter, error) {
+	if _, err := mail.ParseAddress(address); err != nil {
+		return nil, fmt.Errorf("invalid address %q, %w", address, err)
+	}
+	if imapAddr == "" || smtpAddr == "" {
+		return nil, errors.New("IMAP and SMTP servers must be set")
+	}
+
+	e := &email{
+		address:  address,
+		imapAddr: imapAddr,
+		smtpAddr: smtpAddr,
+		username: username,
+		password: password,
+		mailbox:  "INBOX",
+		interval: DefaultPollInterval,
+		threads:  map[string]thread{},
+		msgs:     make(chan *hugot.Message),
+		log:      hugot.DefaultLogger,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.log = e.log.With(hugot.LogAdapter, "email")
+
+	return e, nil
+}
+
+// Receive implements hugot.Receiver
+func (e *email) Receive() <-chan *hugot.Message {
+	e.start.Do(func() {
+		go e.run()
+	})
+	return e.msgs
+}
+
+// IsTextOnly implements hugot.TextOnly
+func (e *email) IsTextOnly() {
+}
+
+// run polls the mailbox, reconnecting with a backoff when the connection
+// fails.
+func (e *email) run() {
+	backoff := minBackoff
+	for {
+		connected, err := e.poll()
+		if connected {
+			backoff = minBackoff
+		}
+		e.log.Error("polling mailbox failed", "error", err, "retry", backoff)
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// poll logs in to the IMAP server, and checks for new mail until the
+// connection fails.
+func (e *email) poll() (connected bool, err error) {
+	c, err := dialIMAP(e.imapAddr, e.tlsConfigFor(e.imapAddr), e.plaintext)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	if err := c.login(e.username, e.password); err != nil {
+		return false, err
+	}
+	if err := c.selectMailbox(e.mailbox); err != nil {
+		return false, err
+	}
+	e.log.Info("connected", "server", e.imapAddr, "mailbox", e.mailbox)
+
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for {
+		uids, err := c.unseen()
+		if err != nil {
+			return true, err
+		}
+
+		for _, uid := range uids {
+			raw, err := c.fetch(uid)
+			if err != nil {
+				return true, err
+			}
+
+			m, err := e.mailToHugot(raw)
+			if err != nil {
+				e.log.Error("could not read mail", "uid", uid, "error", err)
+			}
+			if m != nil {
+				e.msgs <- m
+			}
+
+			if err := c.markSeen(uid); err != nil {
+				return true, err
+			}
+		}
+
+		<-t.C
+	}
+}
+
+// Send implements hugot.Sender. Mail is sent to the message's Channel.
+// If the message has a ThreadID, the mail is sent In-Reply-To that mail.
+// The Message-ID of the sent mail is returned.
+func (e *email) Send(ctx context.Context, m *hugot.Message) string {
+	to := m.Channel
+	if to == "" {
+		to = m.To
+	}
+
+	id, err := e.send(to, m)
+	if err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", e)).Inc()
+		e.log.Error("error sending mail", hugot.LogChannel, to, "error", err)
+		return ""
+	}
+	return id
+}
+
+func (e *email) send(to string, m *hugot.Message) (string, error) {
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return "", fmt.Errorf("invalid recipient, %w", err)
+	}
+	from, _ := mail.ParseAddress(e.address)
+
+	body := m.PlainText()
+	subject := strings.SplitN(body, "\n", 2)[0]
+	if len(subject) > 70 {
+		subject = subject[:70] + "..."
+	}
+
+	id := e.newMessageID()
+	var hdr bytes.Buffer
+	fmt.Fprintf(&hdr, "From: %s\r\n", from)
+	fmt.Fprintf(&hdr, "To: %s\r\n", rcpt)
+	fmt.Fprintf(&hdr, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&hdr, "Message-ID: %s\r\n", id)
+
+	if m.ThreadID != "" {
+		e.threadsLock.Lock()
+		t, ok := e.threads[m.ThreadID]
+		e.threadsLock.Unlock()
+		if ok {
+			subject = t.subject
+			if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+				subject = "Re: " + subject
+			}
+		}
+		fmt.Fprintf(&hdr, "In-Reply-To: %s\r\n", m.ThreadID)
+		fmt.Fprintf(&hdr, "References: %s\r\n", strings.TrimSpace(t.references+" "+m.ThreadID))
+	}
+
+	fmt.Fprintf(&hdr, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	// Discourage auto-responders from replying to us.
+	hdr.WriteString("Auto-Submitted: auto-replied\r\n")
+	hdr.WriteString("MIME-Version: 1.0\r\n")
+	hdr.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	hdr.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&hdr)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+
+	return id, e.sendMail(from.Address, rcpt.Address, hdr.Bytes())
+}
+
+// sendMail sends msg with SMTP.
+func (e *email) sendMail(from, to string, msg []byte) error {
+	host, _, err := net.SplitHostPort(e.smtpAddr)
+	if err != nil {
+		return err
+	}
+
+	nc, err := net.DialTimeout("tcp", e.smtpAddr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	nc.SetDeadline(time.Now().Add(commandTimeout))
+
+	c, err := smtp.NewClient(nc, host)
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello(domain(from)); err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(e.tlsConfigFor(e.smtpAddr)); err != nil {
+			return err
+		}
+	} else if !e.plaintext {
+		return errors.New("SMTP server does not support STARTTLS")
+	}
+	if ok, _ := c.Extension("AUTH"); ok && e.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.username, e.password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (e *email) tlsConfigFor(addr string) *tls.Config {
+	cfg := &tls.Config{}
+	if e.tlsConfig != nil {
+		cfg = e.tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	return cfg
+}
+
+func (e *email) newMessageID() string {
+	bs := make([]byte, 12)
+	rand.Read(bs)
+	from, _ := mail.ParseAddress(e.address)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(bs), domain(from.Address))
+}
+
+// remember records the subject and references of a received mail, so
+// that we can reply to it.
+func (e *email) remember(id string, t thread) {
+	e.threadsLock.Lock()
+	defer e.threadsLock.Unlock()
+
+	if _, ok := e.threads[id]; ok {
+		return
+	}
+	e.threads[id] = t
+	e.threadIDs = append(e.threadIDs, id)
+	if len(e.threadIDs) > maxThreads {
+		delete(e.threads, e.threadIDs[0])
+		e.threadIDs = e.threadIDs[1:]
+	}
+}
+
+func domain(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return addr[i+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,339 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tcolgate/hugot"
+)
+
+// fakeIMAP is a stand-in IMAP server, supporting just the commands the
+// adapter uses.
+type fakeIMAP struct {
+	net.Listener
+
+	sync.Mutex
+	mails map[uint32]string
+	seen  map[uint32]bool
+	next  uint32
+}
+
+func newFakeIMAP(t *testing.T) *fakeIMAP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeIMAP{Listener: ln, mails: map[uint32]string{}, seen: map[uint32]bool{}}
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(nc)
+		}
+	}()
+	return f
+}
+
+func (f *fakeIMAP) add(raw string) {
+	f.Lock()
+	defer f.Unlock()
+	f.next++
+	f.mails[f.next] = strings.ReplaceAll(raw, "\n", "\r\n")
+}
+
+func (f *fakeIMAP) unseen() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.mails) - len(f.seen)
+}
+
+func (f *fakeIMAP) handle(nc net.Conn) {
+	defer nc.Close()
+	fmt.Fprintf(nc, "* OK fake IMAP ready\r\n")
+
+	s := bufio.NewScanner(nc)
+	for s.Scan() {
+		args := strings.Fields(s.Text())
+		if len(args) < 2 {
+			fmt.Fprintf(nc, "* BAD\r\n")
+			continue
+		}
+		tag, cmd := args[0], strings.ToUpper(args[1])
+		if cmd == "UID" && len(args) > 2 {
+			cmd += " " + strings.ToUpper(args[2])
+		}
+
+		f.Lock()
+		switch cmd {
+		case "LOGIN":
+			if strings.Join(args[2:], " ") != `"bot@example.com" "pass"` {
+				fmt.Fprintf(nc, "%s NO bad credentials\r\n", tag)
+				break
+			}
+			fmt.Fprintf(nc, "%s OK logged in\r\n", tag)
+		case "SELECT":
+			fmt.Fprintf(nc, "* %d EXISTS\r\n%s OK selected\r\n", len(f.mails), tag)
+		case "UID SEARCH":
+			var uids []int
+			for uid := range f.mails {
+				if !f.seen[uid] {
+					uids = append(uids, int(uid))
+				}
+			}
+			sort.Ints(uids)
+			res := "* SEARCH"
+			for _, uid := range uids {
+				res += " " + strconv.Itoa(uid)
+			}
+			fmt.Fprintf(nc, "%s\r\n%s OK search done\r\n", res, tag)
+		case "UID FETCH":
+			uid, _ := strconv.Atoi(args[3])
+			raw := f.mails[uint32(uid)]
+			fmt.Fprintf(nc, "* %d FETCH (UID %d BODY[] {%d}\r\n%s)\r\n%s OK fetched\r\n", uid, uid, len(raw), raw, tag)
+		case "UID STORE":
+			uid, _ := strconv.Atoi(args[3])
+			f.seen[uint32(uid)] = true
+			fmt.Fprintf(nc, "%s OK stored\r\n", tag)
+		case "LOGOUT":
+			fmt.Fprintf(nc, "* BYE\r\n%s OK bye\r\n", tag)
+			f.Unlock()
+			return
+		default:
+			fmt.Fprintf(nc, "%s BAD unknown command\r\n", tag)
+		}
+		f.Unlock()
+	}
+}
+
+type sentMail struct {
+	from, to string
+	msg      *mail.Message
+	body     string
+}
+
+// newFakeSMTP starts a stand-in SMTP server, returning its address, and
+// a channel of the mail it receives.
+func newFakeSMTP(t *testing.T) (string, chan sentMail) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	sent := make(chan sentMail, 10)
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer nc.Close()
+				r := bufio.NewReader(nc)
+				fmt.Fprintf(nc, "220 fake SMTP ready\r\n")
+
+				var sm sentMail
+				authed := false
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					l = strings.TrimRight(l, "\r\n")
+					cmd := strings.ToUpper(strings.SplitN(l, " ", 2)[0])
+					switch cmd {
+					case "EHLO":
+						fmt.Fprintf(nc, "250-fake\r\n250 AUTH PLAIN\r\n")
+					case "AUTH":
+						creds, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(l, "AUTH PLAIN "))
+						if string(creds) != "\x00bot@example.com\x00pass" {
+							fmt.Fprintf(nc, "535 bad credentials\r\n")
+							continue
+						}
+						authed = true
+						fmt.Fprintf(nc, "235 ok\r\n")
+					case "MAIL":
+						if !authed {
+							fmt.Fprintf(nc, "530 authentication required\r\n")
+							continue
+						}
+						sm.from = l
+						fmt.Fprintf(nc, "250 ok\r\n")
+					case "RCPT":
+						sm.to = l
+						fmt.Fprintf(nc, "250 ok\r\n")
+					case "DATA":
+						fmt.Fprintf(nc, "354 go ahead\r\n")
+						var data strings.Builder
+						for {
+							dl, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if dl == ".\r\n" {
+								break
+							}
+							data.WriteString(dl)
+						}
+						sm.msg, _ = mail.ReadMessage(strings.NewReader(data.String()))
+						bs, _ := io.ReadAll(sm.msg.Body)
+						sm.body = string(bs)
+						sent <- sm
+						fmt.Fprintf(nc, "250 queued\r\n")
+					case "QUIT":
+						fmt.Fprintf(nc, "221 bye\r\n")
+						return
+					default:
+						fmt.Fprintf(nc, "502 unknown command\r\n")
+					}
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), sent
+}
+
+func receive(t *testing.T, c <-chan *hugot.Message) *hugot.Message {
+	select {
+	case m := <-c:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+	return nil
+}
+
+func TestEmail(t *testing.T) {
+	imap := newFakeIMAP(t)
+	smtpAddr, sent := newFakeSMTP(t)
+
+	imap.add(`From: Bob <bob@example.com>
+To: bot@example.com
+Subject: Re: approve 1234
+Message-ID: <m1@example.com>
+In-Reply-To: <orig@example.com>
+
+yes please
+
+On Mon, 1 Jan 2024, bot@example.com wrote:
+> approve 1234?
+--
+Bob
+`)
+	imap.add(`From: bot@example.com
+To: bot@example.com
+Subject: loop
+Message-ID: <m2@example.com>
+
+ignored
+`)
+	imap.add(`From: vacation@example.com
+To: bot@example.com
+Subject: Out of office
+Auto-Submitted: auto-replied
+Message-ID: <m3@example.com>
+
+ignored
+`)
+	imap.add(`From: carol@example.com
+Reply-To: ops@example.com
+To: bot@example.com
+Subject: =?utf-8?q?deploy_prod?=
+Message-ID: <m4@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="b1"
+
+--b1
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+now=2C please
+--b1
+Content-Type: text/html; charset=utf-8
+
+<p>now, please</p>
+--b1--
+`)
+
+	a, err := New("Hugot <bot@example.com>", imap.Addr().String(), smtpAddr, "bot@example.com", "pass",
+		WithPlaintext(), WithPollInterval(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+	msgs := a.Receive()
+
+	m := receive(t, msgs)
+	if m.Text != "approve 1234\nyes please" || m.From != "bob@example.com" || m.UserID != "bob@example.com" ||
+		m.Channel != "bob@example.com" || m.ID != "<m1@example.com>" || !m.Private || !m.ToBot {
+		t.Fatalf("unexpected message %#v", m)
+	}
+	first := m
+
+	m = receive(t, msgs)
+	if m.Text != "deploy prod\nnow, please" || m.From != "carol@example.com" || m.Channel != "ops@example.com" {
+		t.Fatalf("unexpected message %#v", m)
+	}
+
+	for i := 0; imap.unseen() > 0; i++ {
+		if i > 100 {
+			t.Fatalf("expected all mail to be marked seen")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	id := a.Send(context.Background(), first.Reply("approved"))
+	if id == "" {
+		t.Fatalf("send failed")
+	}
+
+	sm := <-sent
+	h := sm.msg.Header
+	if sm.from != "MAIL FROM:<bot@example.com> BODY=8BITMIME" && sm.from != "MAIL FROM:<bot@example.com>" {
+		t.Fatalf("unexpected sender %q", sm.from)
+	}
+	if sm.to != "RCPT TO:<bob@example.com>" {
+		t.Fatalf("unexpected recipient %q", sm.to)
+	}
+	if h.Get("Message-Id") != id || h.Get("In-Reply-To") != "<m1@example.com>" ||
+		h.Get("References") != "<orig@example.com> <m1@example.com>" || h.Get("Subject") != "Re: approve 1234" {
+		t.Fatalf("unexpected headers %v", h)
+	}
+	if strings.TrimSpace(sm.body) != "approved" {
+		t.Fatalf("unexpected body %q", sm.body)
+	}
+
+	if id := a.Send(context.Background(), &hugot.Message{Channel: "not an address", Text: "hi"}); id != "" {
+		t.Fatalf("expected send to invalid address to fail")
+	}
+}
+
+func TestStripQuoted(t *testing.T) {
+	var tests = []struct {
+		in, exp string
+	}{
+		{"yes", "yes"},
+		{"yes\r\n\r\nOn Monday, Bob wrote:\r\n> approve?\r\n", "yes"},
+		{"> approve?\nyes\n\n-- \nBob\nCEO", "yes"},
+	}
+	for _, tt := range tests {
+		if got := stripQuoted(tt.in); got != tt.exp {
+			t.Errorf("stripQuoted(%q) = %q, expected %q", tt.in, got, tt.exp)
+		}
+	}
+}
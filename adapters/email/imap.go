@@ -0,0 +1,187 @@
+package email
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapConn is a minimal IMAP4rev1 client, supporting just what we need to
+// poll a mailbox for new mail.
+type imapConn struct {
+	net.Conn
+	r   *bufio.Reader
+	tag int
+}
+
+// response is a single response line from the server, with the contents
+// of any literals it included.
+type response struct {
+	line     string
+	literals [][]byte
+}
+
+func dialIMAP(addr string, cfg *tls.Config, plaintext bool) (*imapConn, error) {
+	d := &net.Dialer{Timeout: dialTimeout}
+
+	var nc net.Conn
+	var err error
+	if plaintext {
+		nc, err = d.Dial("tcp", addr)
+	} else {
+		nc, err = tls.DialWithDialer(d, "tcp", addr, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &imapConn{Conn: nc, r: bufio.NewReader(nc)}
+	c.SetDeadline(time.Now().Add(dialTimeout))
+	greeting, err := c.readResponse()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting.line, "* OK") && !strings.HasPrefix(greeting.line, "* PREAUTH") {
+		nc.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", greeting.line)
+	}
+
+	return c, nil
+}
+
+// readResponse reads a response line, along with any literals.
+func (c *imapConn) readResponse() (*response, error) {
+	res := &response{}
+	for {
+		l, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		l = strings.TrimRight(l, "\r\n")
+		res.line += l
+
+		// Lines ending {n} are followed by n bytes of literal data, then
+		// the rest of the line.
+		if !strings.HasSuffix(l, "}") {
+			return res, nil
+		}
+		i := strings.LastIndex(l, "{")
+		if i < 0 {
+			return res, nil
+		}
+		n, err := strconv.Atoi(l[i+1 : len(l)-1])
+		if err != nil {
+			return res, nil
+		}
+		lit := make([]byte, n)
+		if _, err := io.ReadFull(c.r, lit); err != nil {
+			return nil, err
+		}
+		res.literals = append(res.literals, lit)
+	}
+}
+
+// cmd runs a command, returning the untagged responses. An error is
+// returned if the command does not complete with OK.
+func (c *imapConn) cmd(format string, args ...interface{}) ([]*response, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	cmd := fmt.Sprintf(format, args...)
+
+	c.SetDeadline(time.Now().Add(commandTimeout))
+	if _, err := fmt.Fprintf(c.Conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, err
+	}
+
+	var untagged []*response
+	for {
+		res, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(res.line, tag+" ") {
+			untagged = append(untagged, res)
+			continue
+		}
+
+		status := strings.TrimPrefix(res.line, tag+" ")
+		if !strings.HasPrefix(status, "OK") {
+			// Avoid logging credentials.
+			name := strings.SplitN(cmd, " ", 2)[0]
+			return nil, fmt.Errorf("IMAP %s failed, %s", name, status)
+		}
+		return untagged, nil
+	}
+}
+
+func (c *imapConn) login(user, pass string) error {
+	_, err := c.cmd("LOGIN %s %s", quote(user), quote(pass))
+	return err
+}
+
+func (c *imapConn) selectMailbox(name string) error {
+	_, err := c.cmd("SELECT %s", quote(name))
+	return err
+}
+
+// unseen returns the UIDs of messages that have not been seen.
+func (c *imapConn) unseen() ([]uint32, error) {
+	rs, err := c.cmd("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []uint32
+	for _, r := range rs {
+		if !strings.HasPrefix(r.line, "* SEARCH") {
+			continue
+		}
+		for _, f := range strings.Fields(strings.TrimPrefix(r.line, "* SEARCH")) {
+			uid, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad search response %q", r.line)
+			}
+			uids = append(uids, uint32(uid))
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns the full content of a message, without marking it as
+// seen.
+func (c *imapConn) fetch(uid uint32) ([]byte, error) {
+	rs, err := c.cmd("UID FETCH %d (BODY.PEEK[])", uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		if strings.Contains(r.line, "FETCH") && len(r.literals) > 0 {
+			return r.literals[0], nil
+		}
+	}
+	return nil, errors.New("message not found")
+}
+
+func (c *imapConn) markSeen(uid uint32) error {
+	_, err := c.cmd(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid)
+	return err
+}
+
+func (c *imapConn) logout() error {
+	_, err := c.cmd("LOGOUT")
+	return err
+}
+
+// quote returns s as an IMAP quoted string.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,157 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/tcolgate/hugot"
+)
+
+// maxBodySize limits how much of a mail's body we will read.
+const maxBodySize = 1 << 20
+
+// replyPrefix matches the prefixes mail clients add to subjects.
+var replyPrefix = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw|sv)\s*:\s*)+`)
+
+// mailToHugot converts a mail to a hugot.Message. Mail from ourselves,
+// and automatically generated mail, is ignored.
+func (e *email) mailToHugot(raw []byte) (*hugot.Message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	h := msg.Header
+
+	from, err := mail.ParseAddress(h.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender, %w", err)
+	}
+	if me, _ := mail.ParseAddress(e.address); strings.EqualFold(from.Address, me.Address) {
+		return nil, nil
+	}
+	if as := h.Get("Auto-Submitted"); as != "" && !strings.EqualFold(as, "no") {
+		return nil, nil
+	}
+	switch strings.ToLower(h.Get("Precedence")) {
+	case "bulk", "junk", "list":
+		return nil, nil
+	}
+
+	replyTo := from
+	if rts, err := h.AddressList("Reply-To"); err == nil && len(rts) > 0 {
+		replyTo = rts[0]
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(h.Get("Subject"))
+	if err != nil {
+		subject = h.Get("Subject")
+	}
+
+	body, err := textBody(h.Get("Content-Type"), h.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	e.log.Debug("received mail", hugot.LogUser, from.Address, "subject", subject)
+
+	txt := strings.TrimSpace(replyPrefix.ReplaceAllString(subject, "") + "\n" + stripQuoted(body))
+
+	id := strings.TrimSpace(h.Get("Message-Id"))
+	if id == "" {
+		id = e.newMessageID()
+	}
+	refs := strings.TrimSpace(h.Get("References"))
+	if refs == "" {
+		refs = strings.TrimSpace(h.Get("In-Reply-To"))
+	}
+	e.remember(id, thread{subject: subject, references: refs})
+
+	return &hugot.Message{
+		Channel:  replyTo.Address,
+		From:     from.Address,
+		UserID:   from.Address,
+		To:       e.address,
+		ID:       id,
+		ThreadID: id,
+		Text:     txt,
+		Private:  true,
+		ToBot:    true,
+	}, nil
+}
+
+// textBody returns the first text/plain part of a mail body.
+func textBody(contentType, encoding string, r io.Reader) (string, error) {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(encoding) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+
+	switch {
+	case strings.HasPrefix(mt, "multipart/"):
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			// Quoted-printable parts are decoded by the multipart reader.
+			txt, err := textBody(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p)
+			if err != nil {
+				return "", err
+			}
+			if txt != "" {
+				return txt, nil
+			}
+		}
+	case mt == "text/plain":
+		bs, err := io.ReadAll(io.LimitReader(r, maxBodySize))
+		return string(bs), err
+	}
+
+	return "", nil
+}
+
+// stripQuoted removes quoted text, and signatures, from a mail body.
+func stripQuoted(body string) string {
+	var out []string
+	for _, l := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		if l == "-- " || l == "--" {
+			break
+		}
+		if strings.HasPrefix(l, ">") {
+			continue
+		}
+		out = append(out, l)
+	}
+
+	// Remove the attribution line of any quote, "On ..., bob wrote:".
+	for len(out) > 0 {
+		last := strings.TrimSpace(out[len(out)-1])
+		if last != "" && !strings.HasSuffix(last, "wrote:") {
+			break
+		}
+		out = out[:len(out)-1]
+	}
+
+	return strings.Join(out, "\n")
+}
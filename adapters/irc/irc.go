@@ -20,6 +20,7 @@
 package irc
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -29,9 +30,9 @@ import (
 	"context"
 
 	"github.com/fluffle/goirc/client"
-	iglog "github.com/fluffle/goirc/logging/glog"
-	"github.com/golang/glog"
+	"github.com/fluffle/goirc/logging"
 	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
 )
 
 type irc struct {
@@ -41,24 +42,54 @@ type irc struct {
 	c chan *hugot.Message
 
 	start sync.Once
-	*client.Conn
+
+	connLock sync.Mutex
+	current  *client.Conn // replaced on each reconnect, use conn()
+
+	log hugot.Logger
+}
+
+// Opt functions are used to set options on the IRC adapter.
+type Opt func(*irc)
+
+// WithChannels sets the channels the bot joins once connected.
+func WithChannels(chans ...string) Opt {
+	return func(i *irc) {
+		i.defChans = append(i.defChans, chans...)
+	}
+}
+
+// WithLogger sets the Logger used by the adapter, and by the underlying
+// IRC client.
+func WithLogger(l hugot.Logger) Opt {
+	return func(i *irc) {
+		i.log = l
+	}
 }
 
 // New creates a new adapter that communicates with an IRC server using
-// github.com/thoj/go-ircevent
+// github.com/fluffle/goirc. The bot will join chans once connected.
 func New(c *client.Config, chans ...string) hugot.Adapter {
+	return NewWithOpts(c, WithChannels(chans...))
+}
+
+// NewWithOpts creates a new adapter that communicates with an IRC server
+// using github.com/fluffle/goirc, with the provided options.
+func NewWithOpts(c *client.Config, opts ...Opt) hugot.Adapter {
 	a := &irc{
-		c,
-		chans,
-		make(chan *hugot.Message),
-		sync.Once{},
-		nil,
+		cfg: c,
+		c:   make(chan *hugot.Message),
+		log: hugot.DefaultLogger,
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	a.log = a.log.With(hugot.LogAdapter, "irc")
 
 	return a
 }
 
-func (i *irc) Send(ctx context.Context, m *hugot.Message) {
+func (i *irc) Send(ctx context.Context, m *hugot.Message) string {
 	i.Start()
 	if m.Private {
 		if m.Channel == "" {
@@ -69,13 +100,22 @@ func (i *irc) Send(ctx context.Context, m *hugot.Message) {
 			}
 		}
 	}
-	if glog.V(3) {
-		glog.Infof("Sending %#v", *m)
-	}
+	i.log.Debug("sending message", hugot.LogChannel, m.Channel, "text", m.Text)
 
-	for _, l := range strings.Split(m.Text, "\n") {
-		i.Privmsg(m.Channel, l)
+	c, err := i.conn()
+	if err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", i)).Inc()
+		i.log.Error("error sending message", hugot.LogChannel, m.Channel, "error", err)
+		return ""
 	}
+	for _, l := range strings.Split(m.PlainText(), "\n") {
+		c.Privmsg(m.Channel, l)
+	}
+	return ""
+}
+
+// IsTextOnly hints that this adapter only supports text.
+func (i *irc) IsTextOnly() {
 }
 
 func (i *irc) Receive() <-chan *hugot.Message {
@@ -90,47 +130,46 @@ func (i *irc) Start() {
 }
 
 func (i *irc) run() {
-	iglog.Init()
+	logging.SetLogger(ircLogger{i.log})
 	for {
-		i.Conn = client.Client(i.cfg)
+		c := client.Client(i.cfg)
+		i.connLock.Lock()
+		i.current = c
+		i.connLock.Unlock()
 
 		disconnected := make(chan struct{})
-		i.HandleFunc(client.DISCONNECTED, func(c *client.Conn, l *client.Line) {
-			if glog.V(1) {
-				glog.Info("IRC Disconnected")
-			}
+		c.HandleFunc(client.DISCONNECTED, func(conn *client.Conn, l *client.Line) {
+			i.log.Info("disconnected")
 			close(disconnected)
 		})
 
-		// Connect to an IRC server.
-		if err := i.ConnectTo(i.cfg.Server); err != nil {
-			glog.Errorf("could not connect to server, %v", err)
-			<-time.After(5 * time.Second)
-			continue
-		}
-
-		i.HandleFunc(client.PRIVMSG, func(conn *client.Conn, l *client.Line) {
-			i.c <- i.eventToHugot(l)
+		c.HandleFunc(client.PRIVMSG, func(conn *client.Conn, l *client.Line) {
+			i.c <- i.eventToHugot(conn, l)
 		})
 
-		i.HandleFunc(client.CONNECTED, func(conn *client.Conn, l *client.Line) {
-			if glog.V(1) {
-				glog.Info("IRC Connected")
-			}
-			for _, c := range i.defChans {
-				i.Join(c)
+		c.HandleFunc(client.CONNECTED, func(conn *client.Conn, l *client.Line) {
+			i.log.Info("connected", "server", i.cfg.Server)
+			for _, ch := range i.defChans {
+				conn.Join(ch)
 			}
 		})
 
+		// Connect to an IRC server.
+		if err := c.ConnectTo(i.cfg.Server); err != nil {
+			i.log.Error("could not connect to server", "server", i.cfg.Server, "error", err)
+			<-time.After(5 * time.Second)
+			continue
+		}
+
 		// Wait for disconnection.
 		<-disconnected
 	}
 
 }
 
-func (i *irc) eventToHugot(l *client.Line) *hugot.Message {
+func (i *irc) eventToHugot(conn *client.Conn, l *client.Line) *hugot.Message {
 	txt := l.Text()
-	nick := i.Me().Nick
+	nick := conn.Me().Nick
 	tobot := false
 	priv := false
 	channel := l.Target()
@@ -140,9 +179,6 @@ func (i *irc) eventToHugot(l *client.Line) *hugot.Message {
 		// and strip the leading politeness
 		dir := regexp.MustCompile(fmt.Sprintf("^%s[:, ]+(.*)", nick))
 		dirMatch := dir.FindStringSubmatch(txt)
-		if glog.V(3) {
-			glog.Infof("Match %#v", dirMatch)
-		}
 
 		if len(dirMatch) > 1 {
 			tobot = true
@@ -163,3 +199,75 @@ func (i *irc) eventToHugot(l *client.Line) *hugot.Message {
 		Private: priv,
 	}
 }
+
+// ErrNotConnected is returned when managing channels while the bot is
+// not connected to the server.
+var ErrNotConnected = errors.New("not connected to the IRC server")
+
+// conn returns the current connection, if the bot is connected.
+func (i *irc) conn() (*client.Conn, error) {
+	i.Start()
+
+	i.connLock.Lock()
+	defer i.connLock.Unlock()
+	if i.current == nil || !i.current.Connected() {
+		return nil, ErrNotConnected
+	}
+	return i.current, nil
+}
+
+// Join implements hugot.ChannelManager, joining the bot to a channel.
+func (i *irc) Join(channel string) error {
+	c, err := i.conn()
+	if err != nil {
+		return err
+	}
+	c.Join(channel)
+	return nil
+}
+
+// Invite implements hugot.ChannelManager, inviting a user to a channel.
+func (i *irc) Invite(channel, user string) error {
+	c, err := i.conn()
+	if err != nil {
+		return err
+	}
+	c.Invite(user, channel)
+	return nil
+}
+
+// CreateChannel implements hugot.ChannelManager. IRC channels are created
+// when first joined.
+func (i *irc) CreateChannel(channel string) error {
+	return i.Join(channel)
+}
+
+// LeaveChannel implements hugot.ChannelManager, parting the channel.
+func (i *irc) LeaveChannel(channel string) error {
+	c, err := i.conn()
+	if err != nil {
+		return err
+	}
+	c.Part(channel)
+	return nil
+}
+
+// SetChannelTopic implements hugot.ChannelManager.
+func (i *irc) SetChannelTopic(channel, topic string) error {
+	c, err := i.conn()
+	if err != nil {
+		return err
+	}
+	c.Topic(channel, topic)
+	return nil
+}
+
+// ircLogger passes goirc's log messages to a hugot.Logger.
+type ircLogger struct {
+	l hugot.Logger
+}
+
+func (il ircLogger) Debug(f string, a ...interface{}) { il.l.Debug(fmt.Sprintf(f, a...)) }
+func (il ircLogger) Info(f string, a ...interface{})  { il.l.Info(fmt.Sprintf(f, a...)) }
+func (il ircLogger) Warn(f string, a ...interface{})  { il.l.Info(fmt.Sprintf(f, a...)) }
+func (il ircLogger) Error(f string, a ...interface{}) { il.l.Error(fmt.Sprintf(f, a...)) }
@@ -0,0 +1,87 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/tcolgate/hugot"
+)
+
+// fakeServer accepts a single connection, welcomes the client, and
+// passes on any lines the client sends.
+func fakeServer(t *testing.T) (string, chan string, chan net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	lines := make(chan string, 100)
+	conns := make(chan net.Conn, 1)
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conns <- nc
+		s := bufio.NewScanner(nc)
+		for s.Scan() {
+			l := s.Text()
+			if strings.HasPrefix(l, "USER ") {
+				fmt.Fprintf(nc, ":fake 001 hugot :Welcome\r\n")
+			}
+			lines <- l
+		}
+	}()
+	return ln.Addr().String(), lines, conns
+}
+
+func expectLine(t *testing.T, lines chan string, prefix string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case l := <-lines:
+			if strings.HasPrefix(l, prefix) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", prefix)
+		}
+	}
+}
+
+func TestIRC(t *testing.T) {
+	addr, lines, conns := fakeServer(t)
+
+	cfg := client.NewConfig("hugot")
+	cfg.Server = addr
+	cfg.Flood = true
+	a := New(cfg, "#ops")
+
+	// Sending before we are connected fails, rather than racing with
+	// the connection being set up.
+	a.Send(context.Background(), &hugot.Message{Channel: "#ops", Text: "too soon"})
+
+	msgs := a.Receive()
+	expectLine(t, lines, "JOIN #ops")
+
+	nc := <-conns
+	fmt.Fprintf(nc, ":bob!bob@example.com PRIVMSG #ops :hugot: ping\r\n")
+
+	select {
+	case m := <-msgs:
+		if m.Text != "ping" || !m.ToBot || m.From != "bob" || m.Channel != "#ops" {
+			t.Fatalf("unexpected message %#v", m)
+		}
+		a.Send(context.Background(), m.Reply("pong"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	expectLine(t, lines, "PRIVMSG #ops :")
+}
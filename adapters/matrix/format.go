@@ -0,0 +1,71 @@
+package matrix
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/tcolgate/hugot"
+)
+
+// formatHTML renders the text and blocks of m as HTML, for the
+// formatted_body of a message.
+func formatHTML(m *hugot.Message) string {
+	var out []string
+	if m.Text != "" {
+		out = append(out, "<p>"+htmlText(m.Text)+"</p>")
+	}
+
+	for _, b := range m.Blocks {
+		switch b := b.(type) {
+		case hugot.Header:
+			out = append(out, "<h3>"+htmlText(b.Text)+"</h3>")
+		case hugot.Section:
+			if b.Text != "" {
+				out = append(out, "<p>"+htmlText(b.Text)+"</p>")
+			}
+			if len(b.Fields) > 0 {
+				var fs []string
+				for _, f := range b.Fields {
+					fs = append(fs, fmt.Sprintf("<li><strong>%s</strong>: %s</li>", htmlText(f.Title), htmlText(f.Value)))
+				}
+				out = append(out, "<ul>"+strings.Join(fs, "")+"</ul>")
+			}
+		case hugot.Image:
+			// Matrix clients only display images from the homeserver's
+			// media repository, so we link to the image instead.
+			txt := b.Title
+			if txt == "" {
+				txt = b.AltText
+			}
+			if txt == "" {
+				txt = b.URL
+			}
+			out = append(out, fmt.Sprintf(`<p><a href="%s">%s</a></p>`, html.EscapeString(b.URL), htmlText(txt)))
+		case hugot.Code:
+			class := ""
+			if b.Language != "" {
+				class = fmt.Sprintf(` class="language-%s"`, html.EscapeString(b.Language))
+			}
+			out = append(out, fmt.Sprintf("<pre><code%s>%s</code></pre>", class, html.EscapeString(b.Text)))
+		case hugot.Actions:
+			var bts []string
+			for _, bt := range b.Buttons {
+				if bt.URL != "" {
+					bts = append(bts, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(bt.URL), htmlText(bt.Text)))
+					continue
+				}
+				bts = append(bts, "["+htmlText(bt.Text)+"]")
+			}
+			out = append(out, "<p>"+strings.Join(bts, " ")+"</p>")
+		case hugot.Divider:
+			out = append(out, "<hr>")
+		}
+	}
+
+	return strings.Join(out, "")
+}
+
+func htmlText(txt string) string {
+	return strings.ReplaceAll(html.EscapeString(txt), "\n", "<br>")
+}
@@ -0,0 +1,490 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package matrix implements an adapter for https://matrix.org, using the
+// Matrix client-server API.
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+
+	// syncTimeout is how long the homeserver may hold a sync request
+	// open waiting for new events.
+	syncTimeout = 30 * time.Second
+)
+
+type matrix struct {
+	hs     string
+	token  string
+	client *http.Client
+
+	id   string
+	nick string
+
+	dirPat *regexp.Regexp
+
+	roomsLock sync.RWMutex
+	aliases   map[string]string // room ID to canonical alias
+	rooms     map[string]string // alias to room ID
+	members   map[string]int    // room ID to joined member count
+	direct    map[string]bool   // room IDs of direct chats
+
+	since string
+	txnID int64
+	start sync.Once
+	msgs  chan *hugot.Message
+
+	log hugot.Logger
+}
+
+// Opt functions are used to set options on the matrix adapter.
+type Opt func(*matrix)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(mx *matrix) {
+		mx.log = l
+	}
+}
+
+// WithHTTPClient sets the http.Client used to talk to the homeserver.
+func WithHTTPClient(c *http.Client) Opt {
+	return func(mx *matrix) {
+		mx.client = c
+	}
+}
+
+// New creates a new adapter that communicates with the Matrix homeserver
+// at homeserver, e.g. https://matrix.example.org, authenticating with the
+// access token of the bot's user. The bot joins any rooms it is invited to.
+func New(homeserver, token string, opts ...Opt) (hugot.Adapter, error) {
+	if token == "" {
+		return nil, errors.New("Matrix access token must be set")
+	}
+
+	mx := &matrix{
+		hs:      strings.TrimSuffix(homeserver, "/"),
+		token:   token,
+		client:  http.DefaultClient,
+		aliases: map[string]string{},
+		rooms:   map[string]string{},
+		members: map[string]int{},
+		direct:  map[string]bool{},
+		txnID:   time.Now().UnixNano(),
+		msgs:    make(chan *hugot.Message),
+		log:     hugot.DefaultLogger,
+	}
+	for _, opt := range opts {
+		opt(mx)
+	}
+	mx.log = mx.log.With(hugot.LogAdapter, "matrix")
+
+	ctx := context.Background()
+
+	var who struct {
+		UserID string `json:"user_id"`
+	}
+	if err := mx.do(ctx, "GET", "/account/whoami", nil, &who); err != nil {
+		return nil, fmt.Errorf("could not identify bot user, %w", err)
+	}
+	mx.id = who.UserID
+
+	// The localpart of the user ID, @hugot:example.org, is used unless
+	// the user has a display name.
+	mx.nick = strings.SplitN(strings.TrimPrefix(mx.id, "@"), ":", 2)[0]
+	var prof struct {
+		DisplayName string `json:"displayname"`
+	}
+	if err := mx.do(ctx, "GET", "/profile/"+url.PathEscape(mx.id)+"/displayname", nil, &prof); err == nil && prof.DisplayName != "" {
+		mx.nick = prof.DisplayName
+	}
+
+	mx.dirPat = dirPattern(mx.id, mx.nick)
+
+	// The initial sync gives us the state of the rooms we are in. Any
+	// messages in it were sent before we started, and are ignored.
+	if err := mx.sync(ctx, 0, false); err != nil {
+		return nil, fmt.Errorf("initial sync failed, %w", err)
+	}
+
+	return mx, nil
+}
+
+// dirPattern matches messages addressed to the bot, by nick or user ID.
+func dirPattern(id, nick string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf("(?m)^(!|(@?%s|%s)[:,]? )(.*)", regexp.QuoteMeta(nick), regexp.QuoteMeta(id)))
+}
+
+// Send implements hugot.Sender, returning the event ID of the sent message.
+func (mx *matrix) Send(ctx context.Context, m *hugot.Message) string {
+	if m.Channel == "" {
+		mx.log.Error("cannot send message without a room", hugot.LogUser, m.To)
+		return ""
+	}
+
+	room, err := mx.roomID(ctx, m.Channel)
+	if err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", mx)).Inc()
+		mx.log.Error("could not resolve room", hugot.LogChannel, m.Channel, "error", err)
+		return ""
+	}
+
+	c := content{
+		MsgType: "m.text",
+		Body:    m.PlainText(),
+	}
+	if len(m.Blocks) > 0 {
+		c.Format = "org.matrix.custom.html"
+		c.FormattedBody = formatHTML(m)
+	}
+	if m.ThreadID != "" {
+		c.RelatesTo = &relatesTo{RelType: "m.thread", EventID: m.ThreadID}
+	}
+
+	txn := atomic.AddInt64(&mx.txnID, 1)
+	var res struct {
+		EventID string `json:"event_id"`
+	}
+	p := fmt.Sprintf("/rooms/%s/send/m.room.message/%d", url.PathEscape(room), txn)
+	if err := mx.do(ctx, "PUT", p, c, &res); err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", mx)).Inc()
+		mx.log.Error("error sending message", hugot.LogChannel, m.Channel, "error", err)
+		return ""
+	}
+
+	return res.EventID
+}
+
+// Receive implements hugot.Receiver.
+func (mx *matrix) Receive() <-chan *hugot.Message {
+	mx.start.Do(func() {
+		go mx.run()
+	})
+	return mx.msgs
+}
+
+// run syncs with the homeserver until the process exits, backing off if
+// requests fail.
+func (mx *matrix) run() {
+	ctx := context.Background()
+	backoff := minBackoff
+	for {
+		if err := mx.sync(ctx, syncTimeout, true); err != nil {
+			mx.log.Error("sync failed", "error", err, "retry", backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+	}
+}
+
+// sync requests events since the last sync, updating our view of the
+// rooms we are in. If deliver is true, new messages are passed to Receive.
+func (mx *matrix) sync(ctx context.Context, timeout time.Duration, deliver bool) error {
+	q := url.Values{}
+	q.Set("timeout", fmt.Sprint(timeout.Milliseconds()))
+	if mx.since != "" {
+		q.Set("since", mx.since)
+	}
+
+	var res syncResponse
+	if err := mx.do(ctx, "GET", "/sync?"+q.Encode(), nil, &res); err != nil {
+		return err
+	}
+	mx.since = res.NextBatch
+
+	for _, ev := range res.AccountData.Events {
+		if ev.Type == "m.direct" {
+			mx.setDirect(ev.Content)
+		}
+	}
+
+	for room := range res.Rooms.Invite {
+		mx.log.Info("joining room", hugot.LogChannel, room)
+		if err := mx.do(ctx, "POST", "/join/"+url.PathEscape(room), struct{}{}, nil); err != nil {
+			mx.log.Error("could not join room", hugot.LogChannel, room, "error", err)
+		}
+	}
+
+	for room, jr := range res.Rooms.Join {
+		if jr.Summary.JoinedMembers != nil {
+			mx.roomsLock.Lock()
+			mx.members[room] = *jr.Summary.JoinedMembers
+			mx.roomsLock.Unlock()
+		}
+
+		for _, ev := range jr.State.Events {
+			mx.stateEvent(room, ev)
+		}
+
+		for _, ev := range jr.Timeline.Events {
+			if ev.StateKey != nil {
+				mx.stateEvent(room, ev)
+				continue
+			}
+			if !deliver || ev.Type != "m.room.message" {
+				continue
+			}
+			if m := mx.toHugot(room, ev); m != nil {
+				select {
+				case mx.msgs <- m:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// stateEvent updates our view of a room's state.
+func (mx *matrix) stateEvent(room string, ev event) {
+	if ev.Type != "m.room.canonical_alias" {
+		return
+	}
+
+	var c struct {
+		Alias string `json:"alias"`
+	}
+	if err := json.Unmarshal(ev.Content, &c); err != nil {
+		return
+	}
+
+	mx.roomsLock.Lock()
+	defer mx.roomsLock.Unlock()
+	if old, ok := mx.aliases[room]; ok {
+		delete(mx.rooms, old)
+	}
+	if c.Alias == "" {
+		delete(mx.aliases, room)
+		return
+	}
+	mx.aliases[room] = c.Alias
+	mx.rooms[c.Alias] = room
+}
+
+// setDirect records the rooms listed in an m.direct account data event.
+func (mx *matrix) setDirect(raw json.RawMessage) {
+	var c map[string][]string
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return
+	}
+
+	direct := map[string]bool{}
+	for _, rs := range c {
+		for _, r := range rs {
+			direct[r] = true
+		}
+	}
+
+	mx.roomsLock.Lock()
+	defer mx.roomsLock.Unlock()
+	mx.direct = direct
+}
+
+// toHugot converts a message event to a hugot.Message. Messages from
+// the bot itself, edits, and those that are not text, are ignored.
+func (mx *matrix) toHugot(room string, ev event) *hugot.Message {
+	if ev.Sender == mx.id {
+		return nil
+	}
+
+	var c content
+	if err := json.Unmarshal(ev.Content, &c); err != nil {
+		mx.log.Error("could not parse message", hugot.LogChannel, room, "error", err)
+		return nil
+	}
+	if c.MsgType != "m.text" {
+		return nil
+	}
+	if c.RelatesTo != nil && c.RelatesTo.RelType == "m.replace" {
+		// Edits would otherwise run a command a second time.
+		return nil
+	}
+	mx.log.Debug("received message", hugot.LogChannel, room, hugot.LogUser, ev.Sender, "text", c.Body)
+
+	mx.roomsLock.RLock()
+	cname, ok := mx.aliases[room]
+	if !ok {
+		cname = room
+	}
+	private := mx.direct[room] || mx.members[room] == 2
+	mx.roomsLock.RUnlock()
+
+	tobot := private
+	txt := c.Body
+
+	// Check if the message was sent @bot, if so, set it as to us
+	// and strip the leading politeness
+	dirMatch := mx.dirPat.FindStringSubmatch(txt)
+	if len(dirMatch) > 1 && len(dirMatch[1]) > 0 {
+		tobot = true
+		txt = strings.Trim(dirMatch[3], " ")
+	}
+
+	m := &hugot.Message{
+		Channel: cname,
+		From:    ev.Sender,
+		UserID:  ev.Sender,
+		ID:      ev.EventID,
+		Text:    txt,
+		Private: private,
+		ToBot:   tobot,
+	}
+	if c.RelatesTo != nil && c.RelatesTo.RelType == "m.thread" {
+		m.ThreadID = c.RelatesTo.EventID
+	}
+
+	return m
+}
+
+// roomID returns the ID of the room c, which may be a room ID, or an
+// alias.
+func (mx *matrix) roomID(ctx context.Context, c string) (string, error) {
+	if !strings.HasPrefix(c, "#") {
+		return c, nil
+	}
+
+	mx.roomsLock.RLock()
+	id, ok := mx.rooms[c]
+	mx.roomsLock.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	var res struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := mx.do(ctx, "GET", "/directory/room/"+url.PathEscape(c), nil, &res); err != nil {
+		return "", err
+	}
+
+	mx.roomsLock.Lock()
+	mx.rooms[c] = res.RoomID
+	mx.roomsLock.Unlock()
+
+	return res.RoomID, nil
+}
+
+// do makes a request to the client-server API. in, if not nil, is sent
+// as the JSON body of the request. out, if not nil, is decoded from the
+// response.
+func (mx *matrix) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, mx.hs+"/_matrix/client/v3"+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+mx.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := mx.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var merr struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&merr)
+		return fmt.Errorf("%s %s failed, %s %s: %s", method, path, resp.Status, merr.ErrCode, merr.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type syncResponse struct {
+	NextBatch   string `json:"next_batch"`
+	AccountData struct {
+		Events []event `json:"events"`
+	} `json:"account_data"`
+	Rooms struct {
+		Join   map[string]joinedRoom      `json:"join"`
+		Invite map[string]json.RawMessage `json:"invite"`
+	} `json:"rooms"`
+}
+
+type joinedRoom struct {
+	Summary struct {
+		JoinedMembers *int `json:"m.joined_member_count"`
+	} `json:"summary"`
+	State struct {
+		Events []event `json:"events"`
+	} `json:"state"`
+	Timeline struct {
+		Events []event `json:"events"`
+	} `json:"timeline"`
+}
+
+type event struct {
+	Type     string          `json:"type"`
+	EventID  string          `json:"event_id"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type content struct {
+	MsgType       string     `json:"msgtype"`
+	Body          string     `json:"body"`
+	Format        string     `json:"format,omitempty"`
+	FormattedBody string     `json:"formatted_body,omitempty"`
+	RelatesTo     *relatesTo `json:"m.relates_to,omitempty"`
+}
+
+type relatesTo struct {
+	RelType string `json:"rel_type,omitempty"`
+	EventID string `json:"event_id,omitempty"`
+}
@@ -0,0 +1,245 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tcolgate/hugot"
+)
+
+// testMatrix returns an adapter for the bot @hugot:hs, talking to the
+// homeserver hs. It is in #ops:hs, a two person room, !pair:hs, and a
+// direct chat, !dm:hs.
+func testMatrix(hs string) *matrix {
+	return &matrix{
+		hs:      hs,
+		token:   "token",
+		client:  http.DefaultClient,
+		id:      "@hugot:hs",
+		nick:    "hugot",
+		dirPat:  dirPattern("@hugot:hs", "hugot"),
+		aliases: map[string]string{"!ops:hs": "#ops:hs"},
+		rooms:   map[string]string{"#ops:hs": "!ops:hs"},
+		members: map[string]int{"!ops:hs": 5, "!pair:hs": 2},
+		direct:  map[string]bool{"!dm:hs": true},
+		msgs:    make(chan *hugot.Message, 10),
+		log:     hugot.DefaultLogger,
+	}
+}
+
+func TestToHugot(t *testing.T) {
+	var tests = []struct {
+		room    string
+		sender  string
+		content string
+		exp     *hugot.Message // nil if the event should be ignored
+	}{
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.text","body":"hugot: ping"}`,
+			&hugot.Message{Channel: "#ops:hs", Text: "ping", ToBot: true}},
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.text","body":"@hugot, ping"}`,
+			&hugot.Message{Channel: "#ops:hs", Text: "ping", ToBot: true}},
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.text","body":"@hugot:hs: ping"}`,
+			&hugot.Message{Channel: "#ops:hs", Text: "ping", ToBot: true}},
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.text","body":"!ping"}`,
+			&hugot.Message{Channel: "#ops:hs", Text: "ping", ToBot: true}},
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.text","body":"hugotbot: ping"}`,
+			&hugot.Message{Channel: "#ops:hs", Text: "hugotbot: ping"}},
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.text","body":"ask hugot: ping"}`,
+			&hugot.Message{Channel: "#ops:hs", Text: "ask hugot: ping"}},
+
+		// Rooms listed in m.direct, and rooms with only one other
+		// member, are private.
+		{"!dm:hs", "@bob:hs", `{"msgtype":"m.text","body":"status"}`,
+			&hugot.Message{Channel: "!dm:hs", Text: "status", ToBot: true, Private: true}},
+		{"!pair:hs", "@bob:hs", `{"msgtype":"m.text","body":"status"}`,
+			&hugot.Message{Channel: "!pair:hs", Text: "status", ToBot: true, Private: true}},
+
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.text","body":"hugot: ping","m.relates_to":{"rel_type":"m.thread","event_id":"$root"}}`,
+			&hugot.Message{Channel: "#ops:hs", Text: "ping", ToBot: true, ThreadID: "$root"}},
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.text","body":"hugot: ping","m.relates_to":{"m.in_reply_to":{"event_id":"$root"}}}`,
+			&hugot.Message{Channel: "#ops:hs", Text: "ping", ToBot: true}},
+
+		// The bot's own messages, edits, and notices, which other bots
+		// send, are ignored.
+		{"!ops:hs", "@hugot:hs", `{"msgtype":"m.text","body":"hugot: ping"}`, nil},
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.text","body":"* hugot: ping","m.new_content":{"msgtype":"m.text","body":"hugot: ping"},"m.relates_to":{"rel_type":"m.replace","event_id":"$e0"}}`, nil},
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.notice","body":"hugot: ping"}`, nil},
+		{"!ops:hs", "@bob:hs", `{"msgtype":"m.image","body":"hugot.png"}`, nil},
+	}
+
+	mx := testMatrix("")
+	for i, tt := range tests {
+		m := mx.toHugot(tt.room, event{Type: "m.room.message", EventID: "$e1", Sender: tt.sender, Content: json.RawMessage(tt.content)})
+		switch {
+		case tt.exp == nil && m != nil:
+			t.Errorf("%d: expected %s to be ignored, got %#v", i, tt.content, m)
+		case tt.exp == nil:
+		case m == nil:
+			t.Errorf("%d: expected %s to be received", i, tt.content)
+		case m.Channel != tt.exp.Channel || m.Text != tt.exp.Text || m.ToBot != tt.exp.ToBot ||
+			m.Private != tt.exp.Private || m.ThreadID != tt.exp.ThreadID || m.ID != "$e1" || m.UserID != tt.sender:
+			t.Errorf("%d: expected %#v, got %#v", i, tt.exp, m)
+		}
+	}
+}
+
+func TestSync_Resume(t *testing.T) {
+	var since []string
+	var joins []string
+	resps := []string{
+		// The initial sync, whose messages predate the bot.
+		`{"next_batch":"s1",
+			"account_data":{"events":[{"type":"m.direct","content":{"@bob:hs":["!dm2:hs"]}}]},
+			"rooms":{"join":{"!ops:hs":{"timeline":{"events":[
+				{"type":"m.room.message","event_id":"$old","sender":"@bob:hs","content":{"msgtype":"m.text","body":"hugot: old"}}
+			]}}}}}`,
+		"",
+		// The room is renamed before the next message arrives.
+		`{"next_batch":"s2","rooms":{
+			"invite":{"!new:hs":{}},
+			"join":{
+				"!ops:hs":{"timeline":{"events":[
+					{"type":"m.room.canonical_alias","state_key":"","content":{"alias":"#ops2:hs"}},
+					{"type":"m.room.message","event_id":"$e1","sender":"@bob:hs","content":{"msgtype":"m.text","body":"hugot: ping"}}
+				]}},
+				"!dm2:hs":{"timeline":{"events":[
+					{"type":"m.room.message","event_id":"$e2","sender":"@bob:hs","content":{"msgtype":"m.text","body":"status"}}
+				]}}
+			}}}`,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/v3/sync", func(w http.ResponseWriter, r *http.Request) {
+		since = append(since, r.URL.Query().Get("since"))
+		resp := resps[0]
+		resps = resps[1:]
+		if resp == "" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		io.WriteString(w, resp)
+	})
+	mux.HandleFunc("/_matrix/client/v3/join/", func(w http.ResponseWriter, r *http.Request) {
+		joins = append(joins, strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/join/"))
+		io.WriteString(w, `{}`)
+	})
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+
+	mx := testMatrix(hs.URL)
+	ctx := context.Background()
+
+	if err := mx.sync(ctx, 0, false); err != nil {
+		t.Fatalf("initial sync failed, %v", err)
+	}
+	if len(mx.msgs) != 0 {
+		t.Fatalf("expected messages in the initial sync to be ignored")
+	}
+
+	if err := mx.sync(ctx, 0, true); err == nil {
+		t.Fatalf("expected sync to fail")
+	}
+	if err := mx.sync(ctx, 0, true); err != nil {
+		t.Fatalf("sync failed, %v", err)
+	}
+
+	// A failed sync must not lose our place in the event stream.
+	if exp := []string{"", "s1", "s1"}; strings.Join(since, ",") != strings.Join(exp, ",") {
+		t.Fatalf("expected syncs since %q, got %q", exp, since)
+	}
+	if len(joins) != 1 || joins[0] != "!new:hs" {
+		t.Fatalf("expected to join !new:hs, joined %q", joins)
+	}
+
+	// Rooms are delivered in map order.
+	got := map[string]*hugot.Message{}
+	for len(mx.msgs) > 0 {
+		m := <-mx.msgs
+		got[m.ID] = m
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 messages, got %v", got)
+	}
+	for _, exp := range []hugot.Message{
+		{ID: "$e1", Channel: "#ops2:hs", Text: "ping", ToBot: true},
+		{ID: "$e2", Channel: "!dm2:hs", Text: "status", ToBot: true, Private: true},
+	} {
+		m, ok := got[exp.ID]
+		if !ok || m.Channel != exp.Channel || m.Text != exp.Text || m.ToBot != exp.ToBot || m.Private != exp.Private {
+			t.Errorf("expected %#v, got %#v", exp, m)
+		}
+	}
+}
+
+func TestSend_Threads(t *testing.T) {
+	type sent struct {
+		path string
+		c    content
+	}
+	var sends []sent
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/v3/directory/room/", func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/directory/room/") {
+		case "#other:hs":
+			io.WriteString(w, `{"room_id":"!other:hs"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"errcode":"M_NOT_FOUND","error":"no such room"}`)
+		}
+	})
+	mux.HandleFunc("/_matrix/client/v3/rooms/", func(w http.ResponseWriter, r *http.Request) {
+		var c content
+		json.NewDecoder(r.Body).Decode(&c)
+		sends = append(sends, sent{r.URL.Path, c})
+		io.WriteString(w, `{"event_id":"$sent"}`)
+	})
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+
+	mx := testMatrix(hs.URL)
+
+	var tests = []struct {
+		m      hugot.Message
+		room   string
+		thread string
+	}{
+		{hugot.Message{Channel: "#ops:hs", Text: "pong", ThreadID: "$root"}, "!ops:hs", "$root"},
+		{hugot.Message{Channel: "#other:hs", Text: "hi"}, "!other:hs", ""},
+		{hugot.Message{Channel: "!dm:hs", Text: "ok"}, "!dm:hs", ""},
+	}
+	for i, tt := range tests {
+		if id := mx.Send(context.Background(), &tt.m); id != "$sent" {
+			t.Fatalf("%d: expected event ID $sent, got %q", i, id)
+		}
+		s := sends[len(sends)-1]
+		if !strings.HasPrefix(s.path, "/_matrix/client/v3/rooms/"+tt.room+"/send/m.room.message/") {
+			t.Errorf("%d: expected send to %s, got %s", i, tt.room, s.path)
+		}
+		switch {
+		case tt.thread == "" && s.c.RelatesTo != nil:
+			t.Errorf("%d: expected no thread, got %#v", i, s.c.RelatesTo)
+		case tt.thread != "" && (s.c.RelatesTo == nil || s.c.RelatesTo.RelType != "m.thread" || s.c.RelatesTo.EventID != tt.thread):
+			t.Errorf("%d: expected reply in thread %s, got %#v", i, tt.thread, s.c.RelatesTo)
+		}
+	}
+
+	// Each message needs its own transaction ID, or the homeserver
+	// will treat it as a retry, and drop it.
+	txns := map[string]bool{}
+	for _, s := range sends {
+		txns[s.path[strings.LastIndex(s.path, "/")+1:]] = true
+	}
+	if len(txns) != len(sends) {
+		t.Errorf("expected distinct transaction IDs, got %v", sends)
+	}
+
+	if id := mx.Send(context.Background(), &hugot.Message{Channel: "#missing:hs", Text: "hi"}); id != "" {
+		t.Errorf("expected send to an unknown room to fail")
+	}
+}
@@ -0,0 +1,84 @@
+package mattermost
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tcolgate/hugot"
+
+	mm "github.com/mattermost/mattermost-server/model"
+)
+
+// attachments renders hugot blocks as message attachments. Headers and
+// dividers start a new attachment.
+func (s *mma) attachments(bs []hugot.Block) []*mm.SlackAttachment {
+	var out []*mm.SlackAttachment
+	cur := &mm.SlackAttachment{}
+
+	flush := func() {
+		if cur.Title != "" || cur.Text != "" || cur.ImageURL != "" || len(cur.Fields) > 0 || len(cur.Actions) > 0 {
+			cur.Fallback = strings.TrimSpace(cur.Title + "\n" + cur.Text)
+			out = append(out, cur)
+		}
+		cur = &mm.SlackAttachment{}
+	}
+	addText := func(txt string) {
+		if cur.Text != "" {
+			cur.Text += "\n"
+		}
+		cur.Text += txt
+	}
+
+	for _, b := range bs {
+		switch b := b.(type) {
+		case hugot.Header:
+			flush()
+			cur.Title = b.Text
+		case hugot.Section:
+			if b.Text != "" {
+				addText(b.Text)
+			}
+			for _, f := range b.Fields {
+				cur.Fields = append(cur.Fields, &mm.SlackAttachmentField{
+					Title: f.Title,
+					Value: f.Value,
+					Short: true,
+				})
+			}
+		case hugot.Image:
+			if cur.ImageURL != "" {
+				flush()
+			}
+			cur.ImageURL = b.URL
+		case hugot.Code:
+			addText(fmt.Sprintf("```%s\n%s\n```", b.Language, strings.TrimSuffix(b.Text, "\n")))
+		case hugot.Actions:
+			for _, bt := range b.Buttons {
+				switch {
+				case bt.URL != "":
+					addText(fmt.Sprintf("[%s](%s)", bt.Text, bt.URL))
+				case s.actionURL != "":
+					cur.Actions = append(cur.Actions, &mm.PostAction{
+						Id:   bt.ID,
+						Name: bt.Text,
+						Type: "button",
+						Integration: &mm.PostActionIntegration{
+							URL: s.actionURL,
+							Context: mm.StringInterface{
+								"action_id": bt.ID,
+								"value":     bt.Value,
+							},
+						},
+					})
+				default:
+					addText(fmt.Sprintf("[%s]", bt.Text))
+				}
+			}
+		case hugot.Divider:
+			flush()
+		}
+	}
+	flush()
+
+	return out
+}
@@ -0,0 +1,101 @@
+package mattermost
+
+import (
+	"strings"
+
+	mm "github.com/mattermost/mattermost-server/model"
+)
+
+// channel looks up channel c, which may be given by name or ID.
+func (s *mma) channel(c string) (*mm.Channel, error) {
+	ch, err := s.cache.GetChannelByName(strings.TrimPrefix(c, "~"))
+	if err == nil {
+		return ch, nil
+	}
+	if ch, ierr := s.cache.GetChannel(c); ierr == nil {
+		return ch, nil
+	}
+	return nil, err
+}
+
+// UserID implements hugot.Directory, returning the ID of a user given by
+// name, @name, or ID.
+func (s *mma) UserID(user string) (string, error) {
+	u, err := s.cache.GetUserByName(strings.TrimPrefix(user, "@"))
+	if err == nil {
+		return u.Id, nil
+	}
+	if u, ierr := s.cache.GetUser(user); ierr == nil {
+		return u.Id, nil
+	}
+	return "", err
+}
+
+// ChannelID implements hugot.Directory, returning the ID of a channel
+// given by name, ~name, or ID.
+func (s *mma) ChannelID(channel string) (string, error) {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return "", err
+	}
+	return ch.Id, nil
+}
+
+// Join implements hugot.ChannelManager, joining the bot to a channel.
+func (s *mma) Join(channel string) error {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return err
+	}
+	_, resp := s.client.AddChannelMember(ch.Id, s.user.Id)
+	return resp.Error
+}
+
+// Invite implements hugot.ChannelManager, adding a user to a channel.
+func (s *mma) Invite(channel, user string) error {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return err
+	}
+	u, err := s.cache.GetUserByName(strings.TrimPrefix(user, "@"))
+	if err != nil {
+		return err
+	}
+	_, resp := s.client.AddChannelMember(ch.Id, u.Id)
+	return resp.Error
+}
+
+// CreateChannel implements hugot.ChannelManager, creating a new public
+// channel in the bot's team. The bot is a member of any channel it creates.
+func (s *mma) CreateChannel(channel string) error {
+	n := strings.TrimPrefix(channel, "~")
+	_, resp := s.client.CreateChannel(&mm.Channel{
+		TeamId:      s.team.Id,
+		Name:        n,
+		DisplayName: n,
+		Type:        mm.CHANNEL_OPEN,
+	})
+	return resp.Error
+}
+
+// LeaveChannel implements hugot.ChannelManager, removing the bot from a
+// channel.
+func (s *mma) LeaveChannel(channel string) error {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return err
+	}
+	_, resp := s.client.RemoveUserFromChannel(ch.Id, s.user.Id)
+	return resp.Error
+}
+
+// SetChannelTopic implements hugot.ChannelManager, setting the channel's
+// header.
+func (s *mma) SetChannelTopic(channel, topic string) error {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return err
+	}
+	_, resp := s.client.PatchChannel(ch.Id, &mm.ChannelPatch{Header: &topic})
+	return resp.Error
+}
@@ -26,8 +26,8 @@ import (
 
 	"context"
 
-	"github.com/golang/glog"
 	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
 
 	mm "github.com/mattermost/mattermost-server/model"
 )
@@ -50,11 +50,38 @@ type mma struct {
 	ws *mm.WebSocketClient
 
 	sender chan *hugot.Message
+
+	actionURL string
+
+	log hugot.Logger
+}
+
+// Opt functions are used to set options on the mattermost adapter.
+type Opt func(*mma)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(s *mma) {
+		s.log = l
+	}
+}
+
+// WithActionURL sets the URL that mattermost will call when a user presses
+// an action button. If it is not set, action buttons are displayed as
+// text.
+func WithActionURL(u string) Opt {
+	return func(s *mma) {
+		s.actionURL = u
+	}
 }
 
 // New creates a new adapter that communicates with Mattermost
-func New(apiurl, team, email, password string) (hugot.Adapter, error) {
-	c := mma{client: mm.NewAPIv4Client(apiurl)}
+func New(apiurl, team, email, password string, opts ...Opt) (hugot.Adapter, error) {
+	c := mma{client: mm.NewAPIv4Client(apiurl), log: hugot.DefaultLogger}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.log = c.log.With(hugot.LogAdapter, "mattermost")
 
 	user, resp := c.client.Login(email, password)
 	if resp.Error != nil {
@@ -64,7 +91,6 @@ func New(apiurl, team, email, password string) (hugot.Adapter, error) {
 	c.user = user
 
 	teams, resp := c.client.GetAllTeams("", 0, 10000)
-	glog.Infof("Teams: %#v", resp)
 	if resp.Error != nil {
 		return nil, resp.Error
 	}
@@ -98,15 +124,65 @@ func New(apiurl, team, email, password string) (hugot.Adapter, error) {
 	return &c, nil
 }
 
-func (s *mma) Send(ctx context.Context, m *hugot.Message) {
-	post := &mm.Post{}
+func (s *mma) Send(ctx context.Context, m *hugot.Message) string {
+	post := s.post(m)
 	ch, err := s.cache.GetChannelByName(m.Channel)
 	if err != nil {
-		glog.Errorf("could not look up channel, %v", err)
+		s.log.Error("could not look up channel", hugot.LogChannel, m.Channel, "error", err)
 		ch = &mm.Channel{Id: m.Channel}
 	}
 
 	post.ChannelId = ch.Id
+	post.RootId = m.ThreadID
+
+	p, resp := s.client.CreatePost(post)
+	if resp.Error != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", s)).Inc()
+		s.log.Error("error creating post", hugot.LogChannel, m.Channel, "error", resp.Error)
+		return ""
+	}
+	return p.Id
+}
+
+// Update implements hugot.Editor, replacing the content of a post.
+func (s *mma) Update(ctx context.Context, m *hugot.Message) error {
+	post := s.post(m)
+	_, resp := s.client.PatchPost(m.ID, &mm.PostPatch{
+		Message: &post.Message,
+		Props:   &post.Props,
+	})
+	return resp.Error
+}
+
+// Delete implements hugot.Editor, deleting a post.
+func (s *mma) Delete(ctx context.Context, m *hugot.Message) error {
+	_, resp := s.client.DeletePost(m.ID)
+	return resp.Error
+}
+
+// React implements hugot.Editor, adding an emoji reaction to a post.
+func (s *mma) React(ctx context.Context, m *hugot.Message, emoji string) error {
+	_, resp := s.client.SaveReaction(s.reaction(m, emoji))
+	return resp.Error
+}
+
+// Unreact implements hugot.Editor, removing an emoji reaction from a post.
+func (s *mma) Unreact(ctx context.Context, m *hugot.Message, emoji string) error {
+	_, resp := s.client.DeleteReaction(s.reaction(m, emoji))
+	return resp.Error
+}
+
+func (s *mma) reaction(m *hugot.Message, emoji string) *mm.Reaction {
+	return &mm.Reaction{
+		UserId:    s.user.Id,
+		PostId:    m.ID,
+		EmojiName: strings.Trim(emoji, ":"),
+	}
+}
+
+// post builds a post with the content of m.
+func (s *mma) post(m *hugot.Message) *mm.Post {
+	post := &mm.Post{}
 	post.Message = m.Text
 	var attchs []*mm.SlackAttachment
 	for _, a := range m.Attachments {
@@ -147,13 +223,13 @@ func (s *mma) Send(ctx context.Context, m *hugot.Message) {
 			})
 	}
 
+	attchs = append(attchs, s.attachments(m.Blocks)...)
+
 	if len(attchs) > 0 {
 		post.AddProp("attachments", attchs)
 	}
 
-	if _, resp := s.client.CreatePost(post); resp.Error != nil {
-		glog.Errorf("error creating post, %v", resp.Error)
-	}
+	return post
 }
 
 func (s *mma) Receive() <-chan *hugot.Message {
@@ -161,9 +237,7 @@ func (s *mma) Receive() <-chan *hugot.Message {
 	for {
 		select {
 		case m := <-s.ws.EventChannel:
-			if glog.V(3) {
-				glog.Infof("mattermost event: %#v\n", m)
-			}
+			s.log.Debug("mattermost event", "event", m.Event)
 			switch m.Event {
 			case mm.WEBSOCKET_EVENT_POSTED:
 				p := mm.PostFromJson(strings.NewReader(m.Data["post"].(string)))
@@ -173,7 +247,7 @@ func (s *mma) Receive() <-chan *hugot.Message {
 				out <- s.mmMsgToHugot(m)
 				return out
 			default:
-				glog.Infof("unknown event: %#v\n", m)
+				s.log.Debug("unknown event", "event", m.Event)
 			}
 		}
 	}
@@ -181,15 +255,11 @@ func (s *mma) Receive() <-chan *hugot.Message {
 
 func (s *mma) mmMsgToHugot(me *mm.WebSocketEvent) *hugot.Message {
 	var private, tobot bool
-	if glog.V(3) {
-		glog.Infof("mattermost message: %#v\n", *me)
-	}
-
 	p := mm.PostFromJson(strings.NewReader(me.Data["post"].(string)))
 
 	ct, ok := me.Data["channel_type"]
 	if !ok {
-		glog.Infoln("channel_type not set")
+		s.log.Info("channel_type not set", hugot.LogChannel, p.ChannelId)
 		return nil
 	}
 
@@ -205,7 +275,7 @@ func (s *mma) mmMsgToHugot(me *mm.WebSocketEvent) *hugot.Message {
 		}
 	case "O":
 	default:
-		glog.Errorf("cannot determine channel type for %s", p.ChannelId)
+		s.log.Error("cannot determine channel type", hugot.LogChannel, p.ChannelId)
 		return nil
 	}
 
@@ -219,30 +289,30 @@ func (s *mma) mmMsgToHugot(me *mm.WebSocketEvent) *hugot.Message {
 
 	ch, err := s.cache.GetChannel(p.ChannelId)
 	if err != nil {
-		glog.Errorf("could not resolve incoming channel name")
+		s.log.Error("could not resolve incoming channel name", hugot.LogChannel, p.ChannelId, "error", err)
 		ch = &mm.Channel{Id: p.ChannelId}
 	}
 
 	user, err := s.cache.GetUser(p.UserId)
 	if err != nil {
-		glog.Errorf("could not resolve incoming user name")
+		s.log.Error("could not resolve incoming user name", hugot.LogUser, p.UserId, "error", err)
 		user = &mm.User{Id: p.UserId, Username: p.UserId}
 		return nil
 	}
 
 	m := hugot.Message{
-		Channel: ch.Id,
-		UserID:  user.Id,
-		From:    user.Username,
-		To:      "",
-		Private: private,
-		ToBot:   tobot,
-		Text:    p.Message,
+		Channel:  ch.Id,
+		UserID:   user.Id,
+		ID:       p.Id,
+		ThreadID: p.RootId,
+		From:     user.Username,
+		To:       "",
+		Private:  private,
+		ToBot:    tobot,
+		Text:     p.Message,
 	}
 
-	if glog.V(3) {
-		glog.Infof("hugot message: %#v\n", m)
-	}
+	s.log.Debug("received message", hugot.LogChannel, m.Channel, hugot.LogUser, m.From, "private", m.Private, "text", m.Text)
 
 	return &m
 }
@@ -26,7 +26,6 @@ import (
 
 	"context"
 
-	"github.com/golang/glog"
 	"github.com/tcolgate/hugot"
 
 	"github.com/chzyer/readline"
@@ -39,14 +38,30 @@ type Shell struct {
 	user string
 	rch  chan *hugot.Message
 	sch  chan *hugot.Message
+	log  hugot.Logger
+}
+
+// Opt functions are used to set options on the shell adapter.
+type Opt func(*Shell)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(s *Shell) {
+		s.log = l
+	}
 }
 
 // New constructs anew shell adapter. The bot will respond with user
 // nick.
-func New(nick string) (*Shell, error) {
+func New(nick string, opts ...Opt) (*Shell, error) {
 	rch := make(chan *hugot.Message)
 	sch := make(chan *hugot.Message)
-	return &Shell{nick, os.Getenv("USER"), rch, sch}, nil
+	s := &Shell{nick, os.Getenv("USER"), rch, sch, hugot.DefaultLogger}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.log = s.log.With(hugot.LogAdapter, "shell")
+	return s, nil
 }
 
 // IsTextOnly help hint that this is a test-only adapter.
@@ -54,8 +69,9 @@ func (s *Shell) IsTextOnly() {
 }
 
 // Send is used to send this adapter a message.
-func (s *Shell) Send(ctx context.Context, m *hugot.Message) {
+func (s *Shell) Send(ctx context.Context, m *hugot.Message) string {
 	s.sch <- m
+	return ""
 }
 
 // Receive is used to retrieve a mesage from the bot.
@@ -82,7 +98,7 @@ func (s *Shell) Main() {
 		for {
 			select {
 			case m := <-s.sch:
-				fmt.Fprintf(rl, "%s> %s\n", s.nick, m.Text)
+				fmt.Fprintf(rl, "%s> %s\n", s.nick, m.PlainText())
 			case <-done:
 				break
 			}
@@ -98,7 +114,7 @@ func (s *Shell) Main() {
 
 		u, err := user.Current()
 		if err != nil {
-			glog.Errorf("Could not get current user")
+			s.log.Error("could not get current user", "error", err)
 			continue
 		}
 
@@ -0,0 +1,58 @@
+package slack
+
+import (
+	"fmt"
+
+	"github.com/tcolgate/hugot"
+
+	client "github.com/slack-go/slack"
+)
+
+// toBlocks renders hugot blocks as Block Kit blocks.
+func toBlocks(bs []hugot.Block) []client.Block {
+	var out []client.Block
+	for _, b := range bs {
+		switch b := b.(type) {
+		case hugot.Header:
+			out = append(out, client.NewSectionBlock(mrkdwn("*"+b.Text+"*"), nil, nil))
+		case hugot.Section:
+			var fs []*client.TextBlockObject
+			for _, f := range b.Fields {
+				fs = append(fs, mrkdwn(fmt.Sprintf("*%s*\n%s", f.Title, f.Value)))
+			}
+			var txt *client.TextBlockObject
+			if b.Text != "" {
+				txt = mrkdwn(b.Text)
+			}
+			out = append(out, client.NewSectionBlock(txt, fs, nil))
+		case hugot.Image:
+			var title *client.TextBlockObject
+			if b.Title != "" {
+				title = client.NewTextBlockObject(client.PlainTextType, b.Title, false, false)
+			}
+			alt := b.AltText
+			if alt == "" {
+				alt = b.URL
+			}
+			out = append(out, client.NewImageBlock(b.URL, alt, "", title))
+		case hugot.Code:
+			out = append(out, client.NewSectionBlock(mrkdwn("```"+b.Text+"```"), nil, nil))
+		case hugot.Actions:
+			var es []client.BlockElement
+			for _, bt := range b.Buttons {
+				e := client.NewButtonBlockElement(bt.ID, bt.Value, client.NewTextBlockObject(client.PlainTextType, bt.Text, false, false))
+				e.URL = bt.URL
+				e.Style = client.Style(bt.Style)
+				es = append(es, e)
+			}
+			out = append(out, client.NewActionBlock("", es...))
+		case hugot.Divider:
+			out = append(out, client.NewDividerBlock())
+		}
+	}
+	return out
+}
+
+func mrkdwn(txt string) *client.TextBlockObject {
+	return client.NewTextBlockObject(client.MarkdownType, txt, false, false)
+}
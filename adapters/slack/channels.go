@@ -0,0 +1,88 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// userID returns the ID of user u, which may be given by name, @name,
+// or as a slack mention.
+func (s *slack) userID(u string) string {
+	if strings.HasPrefix(u, "<@") && strings.HasSuffix(u, ">") {
+		u = strings.TrimSuffix(strings.TrimPrefix(u, "<@"), ">")
+		return strings.SplitN(u, "|", 2)[0]
+	}
+
+	n := strings.TrimPrefix(u, "@")
+	for _, su := range s.users {
+		if su.Name == n {
+			return su.ID
+		}
+	}
+	return u
+}
+
+// UserID implements hugot.Directory, returning the ID of a user given by
+// ID, name, @name, or as a slack mention.
+func (s *slack) UserID(user string) (string, error) {
+	id := s.userID(user)
+	if _, err := s.GetUser(id); err != nil {
+		return "", fmt.Errorf("unknown user %q", user)
+	}
+	return id, nil
+}
+
+// ChannelID implements hugot.Directory, returning the ID of a channel
+// given by ID, name, #name, or as a slack channel link.
+func (s *slack) ChannelID(channel string) (string, error) {
+	c := channel
+	if strings.HasPrefix(c, "<#") && strings.HasSuffix(c, ">") {
+		c = strings.TrimSuffix(strings.TrimPrefix(c, "<#"), ">")
+		c = strings.SplitN(c, "|", 2)[0]
+	}
+	id := s.channelID(c)
+	if _, err := s.GetChannel(id); err != nil {
+		return "", fmt.Errorf("unknown channel %q", channel)
+	}
+	return id, nil
+}
+
+// Join implements hugot.ChannelManager, joining the bot to a channel.
+func (s *slack) Join(channel string) error {
+	_, _, _, err := s.api.JoinConversation(s.channelID(channel))
+	return err
+}
+
+// Invite implements hugot.ChannelManager, inviting a user to a channel.
+func (s *slack) Invite(channel, user string) error {
+	_, err := s.api.InviteUsersToConversation(s.channelID(channel), s.userID(user))
+	return err
+}
+
+// CreateChannel implements hugot.ChannelManager, creating a new public
+// channel. The bot is a member of any channel it creates.
+func (s *slack) CreateChannel(channel string) error {
+	c, err := s.api.CreateConversation(strings.TrimPrefix(channel, "#"), false)
+	if err != nil {
+		return err
+	}
+
+	s.chansLock.Lock()
+	defer s.chansLock.Unlock()
+	s.channels = append(s.channels, *c)
+
+	return nil
+}
+
+// LeaveChannel implements hugot.ChannelManager, removing the bot from a
+// channel.
+func (s *slack) LeaveChannel(channel string) error {
+	_, err := s.api.LeaveConversation(s.channelID(channel))
+	return err
+}
+
+// SetChannelTopic implements hugot.ChannelManager.
+func (s *slack) SetChannelTopic(channel, topic string) error {
+	_, err := s.api.SetTopicOfConversation(s.channelID(channel), topic)
+	return err
+}
@@ -0,0 +1,191 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/tcolgate/hugot"
+
+	client "github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	// maxEventSize limits the size of event requests we will read.
+	maxEventSize = 1 << 20
+
+	// eventQueueSize is the number of received messages that can be
+	// waiting for the bot. Requests are acknowledged once their message is
+	// queued, rather than handled, so that slack does not retry them.
+	eventQueueSize = 64
+
+	// maxSeenEvents is the number of recent event IDs remembered, to
+	// drop events that slack delivers more than once.
+	maxSeenEvents = 1024
+)
+
+// eventToHugot converts a message event received via the Events API, or
+// Socket Mode, to a hugot.Message. Events that do not represent a new
+// message from a user are ignored.
+func (s *slack) eventToHugot(ev slackevents.EventsAPIEvent) *hugot.Message {
+	if ev.Type != slackevents.CallbackEvent {
+		return nil
+	}
+
+	me, ok := ev.InnerEvent.Data.(*slackevents.MessageEvent)
+	if !ok {
+		return nil
+	}
+
+	switch me.SubType {
+	case "", "thread_broadcast":
+	default:
+		// edits, deletions, joins, and bot messages
+		return nil
+	}
+
+	return s.toHugot(incoming{
+		channel:     me.Channel,
+		channelType: me.ChannelType,
+		user:        me.User,
+		username:    me.Username,
+		text:        me.Text,
+		ts:          me.TimeStamp,
+		threadTS:    me.ThreadTimeStamp,
+	})
+}
+
+// Events is an adapter that receives messages from the Slack Events API,
+// and sends them with the Web API. It is a hugot.WebHookHandler, and must
+// be added to a Mux with HandleHTTP. The handler's URL should then be set
+// as the Request URL of the app's event subscriptions.
+type Events struct {
+	*slack
+	hugot.WebHookHandler
+
+	secret string
+	msgs   chan *hugot.Message
+
+	seenLock sync.Mutex
+	seen     map[string]struct{}
+	order    []string // seen IDs, oldest first
+}
+
+// NewEvents creates a new adapter that receives messages from the Slack
+// Events API. token is the bot's API token, and secret is the app's
+// signing secret, used to verify requests.
+func NewEvents(token, secret, nick string, opts ...Opt) (*Events, error) {
+	if secret == "" {
+		return nil, errors.New("Slack signing secret must be set")
+	}
+
+	s, err := newSlack(token, nick, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Events{
+		slack:  s,
+		secret: secret,
+		msgs:   make(chan *hugot.Message, eventQueueSize),
+		seen:   make(map[string]struct{}),
+	}
+	e.WebHookHandler = hugot.NewWebHookHandler("slack-events", "receives events from slack", e.serveHTTP)
+
+	return e, nil
+}
+
+// Receive implements hugot.Receiver
+func (e *Events) Receive() <-chan *hugot.Message {
+	return e.msgs
+}
+
+// readVerified reads the body of a request from slack, and checks its
+// signature against the signing secret. If the body cannot be read, or
+// the signature is invalid, an error response is written and ok is false.
+func readVerified(w http.ResponseWriter, r *http.Request, secret string) (body []byte, ok bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxEventSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	sv, err := client.NewSecretsVerifier(r.Header, secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	sv.Write(body)
+	if err := sv.Ensure(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return body, true
+}
+
+func (e *Events) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, ok := readVerified(w, r, e.secret)
+	if !ok {
+		return
+	}
+
+	ev, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if ev.Type == slackevents.URLVerification {
+		var uv slackevents.EventsAPIURLVerificationEvent
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&uv); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, uv.Challenge)
+		return
+	}
+
+	m := e.eventToHugot(ev)
+	if m == nil {
+		return
+	}
+
+	var id string
+	if cb, ok := ev.Data.(*slackevents.EventsAPICallbackEvent); ok {
+		id = cb.EventID
+	}
+
+	e.seenLock.Lock()
+	defer e.seenLock.Unlock()
+	if _, ok := e.seen[id]; ok && id != "" {
+		// a retry of an event we have already queued
+		return
+	}
+
+	select {
+	case e.msgs <- m:
+		e.markSeen(id)
+	default:
+		http.Error(w, "too many pending events", http.StatusServiceUnavailable)
+	}
+}
+
+// markSeen records that the event id has been queued, forgetting the
+// oldest ID if too many are held. The lock must be held.
+func (e *Events) markSeen(id string) {
+	if id == "" {
+		return
+	}
+	if len(e.order) >= maxSeenEvents {
+		delete(e.seen, e.order[0])
+		e.order = e.order[1:]
+	}
+	e.seen[id] = struct{}{}
+	e.order = append(e.order, id)
+}
@@ -0,0 +1,301 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/metrics"
+
+	client "github.com/slack-go/slack"
+)
+
+// ActionFunc is called when a user triggers a block action, such as
+// pressing a button. m is the message the action was attached to, with
+// the Text set to the action's value, and the user that triggered the
+// action as the sender. m.ID can be used with hugot.Update to replace
+// the message.
+type ActionFunc func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, a *client.BlockAction) error
+
+// adapter is implemented by each of the slack adapters.
+type adapter interface {
+	hugot.Editor
+	hugot.ChannelManager
+	hugot.Directory
+	base() *slack
+}
+
+// action is a registered ActionFunc, and the roles needed to trigger it.
+type action struct {
+	f        ActionFunc
+	anyRoles []string
+}
+
+// interaction is a received slash command or block action that is waiting
+// to be handled.
+type interaction struct {
+	responseURL string
+	block       *client.BlockAction // nil for slash commands
+	action
+}
+
+// Interactions is an adapter that receives slash commands, and interactive
+// component callbacks, from slack. It is also a hugot.WebHookHandler, and
+// must be added to a Mux with HandleHTTP, and passed to ListenAndServe
+// alongside the adapter it was created with. The handler's URL should be
+// set as the Request URL of the app's slash commands, and of its
+// interactivity settings.
+//
+// Slash commands are received as messages to the bot, a /deploy prod
+// command is processed as "deploy prod". Block actions are passed to the
+// ActionFunc registered for the action's ID, by the Actions middleware.
+// Button presses are received as messages to the bot, so rate limits,
+// roles and Ask apply to them as to any other message.
+// Responses sent while a command or action is being handled are sent
+// using the request's response_url, other messages are sent with the
+// underlying adapter.
+type Interactions struct {
+	adapter
+	hugot.WebHookHandler
+
+	secret string
+	msgs   chan *hugot.Message
+
+	actionsLock sync.RWMutex
+	actions     map[string]action
+
+	pendingLock sync.Mutex
+	pending     map[*hugot.Message]interaction
+}
+
+// NewInteractions creates an adapter for slash commands and interactive
+// components. secret is the app's signing secret, used to verify requests,
+// and a is the slack adapter used to receive other messages.
+func NewInteractions(secret string, a hugot.Adapter) (*Interactions, error) {
+	if secret == "" {
+		return nil, errors.New("Slack signing secret must be set")
+	}
+
+	sa, ok := a.(adapter)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a slack adapter", a)
+	}
+
+	i := &Interactions{
+		adapter: sa,
+		secret:  secret,
+		msgs:    make(chan *hugot.Message, eventQueueSize),
+		actions: map[string]action{},
+		pending: map[*hugot.Message]interaction{},
+	}
+	i.WebHookHandler = hugot.NewWebHookHandler("slack-interactions", "receives slash commands and interactions from slack", i.serveHTTP)
+
+	return i, nil
+}
+
+// HandleAction registers f to be called for block actions with the given
+// action ID. Any existing function for the ID is replaced. If anyRoles
+// are given, f is only called for users that hold at least one of them.
+func (i *Interactions) HandleAction(id string, f ActionFunc, anyRoles ...string) {
+	i.actionsLock.Lock()
+	defer i.actionsLock.Unlock()
+
+	i.actions[id] = action{f: f, anyRoles: anyRoles}
+}
+
+func (i *Interactions) action(id string) (action, bool) {
+	i.actionsLock.RLock()
+	defer i.actionsLock.RUnlock()
+
+	a, ok := i.actions[id]
+	return a, ok
+}
+
+// Actions is a hugot.Middleware that calls the ActionFunc for block
+// actions received by i, and passes all other messages to next. It should
+// wrap the ToBot handler of the Mux, before that is wrapped by a
+// roles.Handler, so that actions are checked against the user's roles.
+func (i *Interactions) Actions(next hugot.Handler) hugot.Handler {
+	return hugot.WrapHandler(next, func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		in, ok := i.pendingAction(m)
+		if !ok {
+			return next.ProcessMessage(ctx, w, m)
+		}
+
+		if len(in.anyRoles) > 0 {
+			roles := hugot.RolesFromContext(ctx)
+			permitted := false
+			for _, r := range in.anyRoles {
+				if _, ok := roles[r]; ok {
+					permitted = true
+					break
+				}
+			}
+			if !permitted {
+				return &command.PermissionError{Command: in.block.ActionID, AnyRoles: in.anyRoles}
+			}
+		}
+
+		return in.f(ctx, w, m, in.block)
+	})
+}
+
+// pendingAction returns the block action m was received for, if any. The
+// Mux passes copies of messages to its handlers, so m is matched on its
+// content.
+func (i *Interactions) pendingAction(m *hugot.Message) (interaction, bool) {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
+
+	for pm, in := range i.pending {
+		if in.f != nil && pm.Channel == m.Channel && pm.UserID == m.UserID && pm.ID == m.ID && pm.Text == m.Text {
+			return in, true
+		}
+	}
+	return interaction{}, false
+}
+
+// Receive implements hugot.Receiver
+func (i *Interactions) Receive() <-chan *hugot.Message {
+	return i.msgs
+}
+
+// Send implements hugot.Sender. Messages to the channel and user of an
+// interaction that is being handled are sent to its response_url.
+func (i *Interactions) Send(ctx context.Context, m *hugot.Message) string {
+	u := i.responseURL(m)
+	if u == "" || (m.Text == "" && len(m.Attachments) == 0 && len(m.Blocks) == 0) {
+		return i.adapter.Send(ctx, m)
+	}
+
+	s := i.base()
+	opts := append(s.msgOptions(m), client.MsgOptionResponseURL(u, client.ResponseTypeInChannel))
+	if _, _, _, err := s.api.SendMessageContext(ctx, m.Channel, opts...); err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", i)).Inc()
+		s.log.Error("error sending response", hugot.LogChannel, m.Channel, "error", err)
+	}
+
+	// responses do not report the ID of the message they create
+	return ""
+}
+
+// Complete implements hugot.Completer, forgetting the interaction m came
+// from.
+func (i *Interactions) Complete(ctx context.Context, m *hugot.Message, err error) {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
+
+	delete(i.pending, m)
+}
+
+// responseURL returns the response_url of an interaction being handled
+// for m's channel and user, or "" if there is none.
+func (i *Interactions) responseURL(m *hugot.Message) string {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
+
+	for pm, in := range i.pending {
+		if pm.Channel == m.Channel && pm.UserID == m.UserID {
+			return in.responseURL
+		}
+	}
+	return ""
+}
+
+// queue passes m to the bot, recording the interaction it came from.
+// Requests are acknowledged once queued, as slack expects a response
+// within 3 seconds. If the queue is full, false is returned.
+func (i *Interactions) queue(m *hugot.Message, in interaction) bool {
+	i.pendingLock.Lock()
+	defer i.pendingLock.Unlock()
+
+	select {
+	case i.msgs <- m:
+		i.pending[m] = in
+		return true
+	default:
+		return false
+	}
+}
+
+func (i *Interactions) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, ok := readVerified(w, r, i.secret)
+	if !ok {
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case form.Get("payload") != "":
+		var cb client.InteractionCallback
+		if err := json.Unmarshal([]byte(form.Get("payload")), &cb); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		i.interaction(r.Context(), w, &cb)
+	case form.Get("command") != "":
+		i.slashCommand(w, form)
+	default:
+		http.Error(w, "unrecognised request", http.StatusBadRequest)
+	}
+}
+
+func (i *Interactions) slashCommand(w http.ResponseWriter, form url.Values) {
+	cmd := strings.TrimPrefix(form.Get("command"), "/")
+	m := &hugot.Message{
+		Channel: form.Get("channel_id"),
+		UserID:  form.Get("user_id"),
+		From:    form.Get("user_name"),
+		Text:    strings.TrimSpace(cmd + " " + form.Get("text")),
+		ToBot:   true,
+		Private: strings.HasPrefix(form.Get("channel_id"), "D"),
+	}
+
+	if !i.queue(m, interaction{responseURL: form.Get("response_url")}) {
+		http.Error(w, "too many pending interactions", http.StatusServiceUnavailable)
+	}
+}
+
+func (i *Interactions) interaction(ctx context.Context, w http.ResponseWriter, cb *client.InteractionCallback) {
+	if cb.Type != client.InteractionTypeBlockActions {
+		hugot.LoggerFromContext(ctx).Debug("ignoring slack interaction", "type", cb.Type)
+		return
+	}
+
+	for _, a := range cb.ActionCallback.BlockActions {
+		act, ok := i.action(a.ActionID)
+		if !ok {
+			hugot.LoggerFromContext(ctx).Debug("no handler for slack action", "action", a.ActionID)
+			continue
+		}
+
+		m := &hugot.Message{
+			ID:       cb.Message.Timestamp,
+			ThreadID: cb.Message.ThreadTimestamp,
+			Channel:  cb.Channel.ID,
+			UserID:   cb.User.ID,
+			From:     cb.User.Name,
+			Text:     a.Value,
+			ToBot:    true,
+			Private:  strings.HasPrefix(cb.Channel.ID, "D"),
+		}
+
+		if !i.queue(m, interaction{responseURL: cb.ResponseURL, block: a, action: act}) {
+			// only one response can be written
+			http.Error(w, "too many pending interactions", http.StatusServiceUnavailable)
+			return
+		}
+	}
+}
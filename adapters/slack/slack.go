@@ -15,8 +15,10 @@
 // You should have received a copy of the GNU General Public License
 // along with hugot.  If not, see <http://www.gnu.org/licenses/>.
 
-// Package slack implements an adapter for http://slack.com using
-// github.com/slack-go/slack
+// Package slack implements adapters for http://slack.com using
+// github.com/slack-go/slack. Messages can be received using Socket Mode,
+// the Events API, or the legacy RTM API. Slash commands and button presses
+// can be received with Interactions.
 package slack
 
 import (
@@ -24,11 +26,12 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 
 	"context"
 
-	"github.com/golang/glog"
 	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
 
 	client "github.com/slack-go/slack"
 )
@@ -41,29 +44,77 @@ type slack struct {
 	id   string
 	icon string
 
-	dirPat   *regexp.Regexp
-	api      *client.Client
-	info     client.Info
-	users    []client.User
-	channels []client.Channel
+	apiURL string
+
+	dirPat *regexp.Regexp
+	api    *client.Client
+	info   client.Info
+	users  []client.User
 	*cache
 
-	sender   chan *hugot.Message
+	chansLock sync.RWMutex
+	channels  []client.Channel
+
 	receiver chan client.RTMEvent
+
+	log hugot.Logger
+}
+
+// Opt functions are used to set options on the slack adapter.
+type Opt func(*slack)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(s *slack) {
+		s.log = l
+	}
+}
+
+// WithAPIURL sets the base URL of the slack API, this is mostly useful
+// for testing.
+func WithAPIURL(u string) Opt {
+	return func(s *slack) {
+		s.apiURL = u
+	}
 }
 
 // New creates a new adapter that communicates with the Slack messaging
-// API. A slack API token, and coresponding bot username must be provided
-func New(token, nick string) (hugot.Adapter, error) {
-	s := slack{nick: nick}
+// API, receiving messages with the legacy RTM API. A slack API token, and
+// coresponding bot username must be provided. New apps cannot use RTM,
+// and should use NewSocketMode or NewEvents.
+func New(token, nick string, opts ...Opt) (hugot.Adapter, error) {
+	s, err := newSlack(token, nick, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// We use RTM to recieve, but the regular slack API to send
+	// RTM does not support formatted message parsing
+	wsAPI := s.api.NewRTM()
+	s.receiver = wsAPI.IncomingEvents
+
+	go wsAPI.ManageConnection()
+
+	return s, nil
+}
+
+// newSlack sets up the parts of the adapter common to all the ways
+// of receiving messages.
+func newSlack(token, nick string, opts []Opt) (*slack, error) {
+	s := &slack{botToken: token, nick: nick, apiURL: client.APIURL, log: hugot.DefaultLogger}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.log = s.log.With(hugot.LogAdapter, "slack")
+
 	if token == "" {
 		return nil, errors.New("Slack Token must be set")
 	}
 	if s.nick == "" {
-		return nil, errors.New("Slack Token must be set")
+		return nil, errors.New("Slack nick must be set")
 	}
 
-	s.api = client.New(token)
+	s.api = client.New(token, client.OptionAPIURL(s.apiURL))
 	s.cache = newCache(s.api)
 
 	s.info = client.Info{}
@@ -84,49 +135,108 @@ func New(token, nick string) (hugot.Adapter, error) {
 
 	s.dirPat = regexp.MustCompile(fmt.Sprintf("(?m)^(!|(@?%s|<@%s>)[:,]? )(.*)", s.nick, s.id))
 
-	// We use RTM to recieve, but the regular slack API to send
-	// RTM does not support formatted message parsing
-	wsAPI := s.api.NewRTM()
-	s.receiver = wsAPI.IncomingEvents
-	s.sender = make(chan *hugot.Message)
-
-	go wsAPI.ManageConnection()
-
-	return &s, nil
+	return s, nil
 }
 
-func (s *slack) Send(ctx context.Context, m *hugot.Message) {
-	if (m.Text != "" || len(m.Attachments) > 0) && m.Channel != "" {
+func (s *slack) Send(ctx context.Context, m *hugot.Message) string {
+	if (m.Text != "" || len(m.Attachments) > 0 || len(m.Blocks) > 0) && m.Channel != "" {
 		var err error
 		chanout := ""
 		c, err := s.GetChannel(m.Channel)
 		if err != nil {
-			glog.Errorf("unresolvable channel, %#v", m.Channel)
+			s.log.Error("unresolvable channel", hugot.LogChannel, m.Channel)
 			chanout = m.Channel
 		} else {
 			chanout = c.Name
 		}
-		if glog.V(3) {
-			glog.Infof("sending, %#v to %#v", *m, chanout)
-		}
+		s.log.Debug("sending message", hugot.LogChannel, chanout, "text", m.Text)
 
-		attchs := []client.Attachment{}
-		for _, a := range m.Attachments {
-			attchs = append(attchs, client.Attachment(a))
-		}
-		_, _, err = s.api.PostMessage(
-			m.Channel,
-			client.MsgOptionText(m.Text, false),
+		opts := append(s.msgOptions(m),
 			client.MsgOptionAsUser(false),
 			client.MsgOptionIconURL(s.icon),
-			client.MsgOptionUsername(s.nick),
-			client.MsgOptionAttachments(attchs...))
+			client.MsgOptionUsername(s.nick))
+		if m.ThreadID != "" {
+			opts = append(opts, client.MsgOptionTS(m.ThreadID))
+		}
+		_, ts, err := s.api.PostMessageContext(ctx, m.Channel, opts...)
 		if err != nil {
-			glog.Errorf("error sending, %#v", err.Error())
+			metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", s)).Inc()
+			s.log.Error("error sending message", hugot.LogChannel, chanout, "error", err)
+			return ""
 		}
-	} else {
-		glog.Infoln("Attempt to send empty message")
+		return ts
 	}
+
+	s.log.Info("attempt to send empty message")
+	return ""
+}
+
+// base returns the parts of the adapter common to all the ways of
+// receiving messages.
+func (s *slack) base() *slack {
+	return s
+}
+
+// msgOptions returns the options for the content of m.
+func (s *slack) msgOptions(m *hugot.Message) []client.MsgOption {
+	attchs := []client.Attachment{}
+	for _, a := range m.Attachments {
+		attchs = append(attchs, client.Attachment(a))
+	}
+	opts := []client.MsgOption{
+		client.MsgOptionText(m.Text, false),
+		client.MsgOptionAttachments(attchs...),
+	}
+
+	if len(m.Blocks) > 0 {
+		// The text is only used for notifications when blocks are
+		// present, so we include it in the blocks too.
+		bs := m.Blocks
+		if m.Text != "" {
+			bs = append([]hugot.Block{hugot.Section{Text: m.Text}}, bs...)
+		}
+		opts = append(opts, client.MsgOptionBlocks(toBlocks(bs)...))
+	}
+
+	return opts
+}
+
+// channelID returns the ID of channel c, which may be given by name or ID.
+// Unlike posting messages, slack's other APIs only accept channel IDs.
+func (s *slack) channelID(c string) string {
+	n := strings.TrimPrefix(c, "#")
+
+	s.chansLock.RLock()
+	defer s.chansLock.RUnlock()
+	for _, ch := range s.channels {
+		if ch.Name == n {
+			return ch.ID
+		}
+	}
+	return c
+}
+
+// Update implements hugot.Editor, replacing the content of a message.
+func (s *slack) Update(ctx context.Context, m *hugot.Message) error {
+	_, _, _, err := s.api.UpdateMessageContext(ctx, s.channelID(m.Channel), m.ID, s.msgOptions(m)...)
+	return err
+}
+
+// Delete implements hugot.Editor, deleting a message.
+func (s *slack) Delete(ctx context.Context, m *hugot.Message) error {
+	_, _, err := s.api.DeleteMessageContext(ctx, s.channelID(m.Channel), m.ID)
+	return err
+}
+
+// React implements hugot.Editor, adding an emoji reaction to a message.
+func (s *slack) React(ctx context.Context, m *hugot.Message, emoji string) error {
+	return s.api.AddReactionContext(ctx, strings.Trim(emoji, ":"), client.NewRefToMessage(s.channelID(m.Channel), m.ID))
+}
+
+// Unreact implements hugot.Editor, removing an emoji reaction from a
+// message.
+func (s *slack) Unreact(ctx context.Context, m *hugot.Message, emoji string) error {
+	return s.api.RemoveReactionContext(ctx, strings.Trim(emoji, ":"), client.NewRefToMessage(s.channelID(m.Channel), m.ID))
 }
 
 func (s *slack) Receive() <-chan *hugot.Message {
@@ -139,12 +249,12 @@ func (s *slack) Receive() <-chan *hugot.Message {
 			case *client.UserTypingEvent:
 			case *client.ReconnectUrlEvent:
 			case *client.ConnectingEvent:
-				glog.Infof("Connecting")
+				s.log.Info("connecting")
 			case *client.ConnectedEvent:
-				glog.Infof("Connected")
+				s.log.Info("connected")
 			case *client.PresenceChangeEvent:
 			case *client.LatencyReport:
-				glog.Infof("Latency: %v", ev.Value)
+				s.log.Debug("latency report", "latency", ev.Value)
 			case *client.MessageEvent:
 				m := s.slackMsgToHugot(ev)
 				if m == nil {
@@ -153,63 +263,95 @@ func (s *slack) Receive() <-chan *hugot.Message {
 				out <- m
 				return out
 			default:
-				glog.Infof("Unexpected: %T %v\n", m.Data, m.Data)
+				s.log.Debug("unexpected event", "type", fmt.Sprintf("%T", m.Data))
 			}
 		}
 	}
 }
 
-func (s *slack) slackMsgToHugot(me *client.MessageEvent) *hugot.Message {
-	var private, tobot bool
-	if glog.V(3) {
-		glog.Infof("message: %#v\n", *me)
+// incoming describes a message received from slack, via any of the APIs.
+type incoming struct {
+	channel     string
+	channelType string // im, mpim, group or channel
+	user        string
+	username    string
+	text        string
+	ts          string
+	threadTS    string
+}
+
+// rtmChannelType determines the type of a channel from its ID, as RTM
+// messages do not include it.
+func rtmChannelType(id string) string {
+	switch {
+	case strings.HasPrefix(id, "D"):
+		return "im"
+	case strings.HasPrefix(id, "G"):
+		return "group"
+	case strings.HasPrefix(id, "C"):
+		return "channel"
 	}
+	return ""
+}
+
+func (s *slack) slackMsgToHugot(me *client.MessageEvent) *hugot.Message {
+	return s.toHugot(incoming{
+		channel:     me.Channel,
+		channelType: rtmChannelType(me.Channel),
+		user:        me.User,
+		username:    me.Username,
+		text:        me.Msg.Text,
+		ts:          me.Timestamp,
+		threadTS:    me.ThreadTimestamp,
+	})
+}
 
-	var uname string
+func (s *slack) toHugot(in incoming) *hugot.Message {
+	var private, tobot bool
+	s.log.Debug("received message", hugot.LogChannel, in.channel, hugot.LogUser, in.user, "text", in.text)
 
-	if me.Username == "" {
-		u, err := s.GetUser(me.User)
+	uname := in.username
+	if uname == "" {
+		u, err := s.GetUser(in.user)
 		if err == nil {
 			uname = u.Name
 		}
-	} else {
-		uname = me.Username
 	}
 
 	if uname == "" {
-		glog.Infoln("could not resolve username")
+		s.log.Info("could not resolve username", hugot.LogUser, in.user)
 		return nil
 	}
 
-	cname := me.Channel
-	c := s.info.GetChannelByID(me.Channel)
+	cname := in.channel
+	c := s.info.GetChannelByID(in.channel)
 	if c != nil {
 		cname = c.Name
 	}
 
 	// ignore from self
-	if me.User == s.id || uname == s.nick {
+	if in.user == s.id || uname == s.nick {
 		return nil
 	}
 
-	txt := me.Msg.Text
+	txt := in.text
 
-	switch {
-	case strings.HasPrefix(me.Channel, "D"):
+	switch in.channelType {
+	case "im":
 		{ // One on one,
 			private = true
 			tobot = true
 		}
-	case strings.HasPrefix(me.Channel, "G"):
+	case "group", "mpim":
 		{ // private group chat
 			private = true
 		}
-	case strings.HasPrefix(me.Channel, "C"):
+	case "channel":
 		{
 			private = false
 		}
 	default:
-		glog.Errorf("cannot determine channel type for %s", me.Channel)
+		s.log.Error("cannot determine channel type", hugot.LogChannel, in.channel)
 		return nil
 	}
 
@@ -222,20 +364,18 @@ func (s *slack) slackMsgToHugot(me *client.MessageEvent) *hugot.Message {
 	}
 
 	m := hugot.Message{
-		Channel: cname,
-		From:    uname,
-		To:      "",
-		UserID:  me.User,
-		Private: private,
-		ToBot:   tobot,
-		Text:    txt,
+		Channel:  cname,
+		From:     uname,
+		To:       "",
+		UserID:   in.user,
+		ID:       in.ts,
+		ThreadID: in.threadTS,
+		Private:  private,
+		ToBot:    tobot,
+		Text:     txt,
 	}
 
-	if m.Private {
-		glog.Infof("Handling private message from %v: %v", m.From, m.Text)
-	} else {
-		glog.Infof("Handling message in %v from %v: %v", m.Channel, m.From, m.Text)
-	}
+	s.log.Info("handling message", hugot.LogChannel, m.Channel, hugot.LogUser, m.From, "private", m.Private)
 
 	return &m
 }
@@ -0,0 +1,412 @@
+package slack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/handlers/command"
+
+	client "github.com/slack-go/slack"
+)
+
+// newTestAPI returns a fake slack API, with a bot user, hugot, and one
+// other user, bob.
+func newTestAPI(t *testing.T, extra func(mux *http.ServeMux)) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users.list", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"ok":true,"members":[
+			{"id":"UBOT","name":"hugot"},
+			{"id":"UBOB","name":"bob"}
+		]}`)
+	})
+	mux.HandleFunc("/users.info", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("user") {
+		case "UBOB":
+			io.WriteString(w, `{"ok":true,"user":{"id":"UBOB","name":"bob"}}`)
+		default:
+			io.WriteString(w, `{"ok":false,"error":"user_not_found"}`)
+		}
+	})
+	mux.HandleFunc("/channels.list", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"ok":true,"channels":[{"id":"C1","name":"general"}]}`)
+	})
+	if extra != nil {
+		extra(mux)
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// signedPost sends body to h, signed with secret as slack would.
+func signedPost(h http.Handler, body string, secret string) *httptest.ResponseRecorder {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestEvents(t *testing.T) {
+	api := newTestAPI(t, nil)
+
+	e, err := NewEvents("xoxb-test", "secret", "hugot", WithAPIURL(api.URL+"/"))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	post := func(body string, secret string) *httptest.ResponseRecorder {
+		return signedPost(e, body, secret)
+	}
+
+	w := post(`{"type":"url_verification","challenge":"abc"}`, "secret")
+	if w.Code != http.StatusOK || w.Body.String() != "abc" {
+		t.Fatalf("expected challenge response, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = post(`{"type":"url_verification","challenge":"abc"}`, "wrong")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected request with bad signature to be rejected, got %d", w.Code)
+	}
+
+	done := make(chan *hugot.Message, 1)
+	go func() { done <- <-e.Receive() }()
+
+	w = post(`{"type":"event_callback","event":{
+		"type":"message","channel":"C1","channel_type":"channel","user":"UBOB",
+		"text":"<@UBOT> ping","ts":"1.2","thread_ts":"1.1"}}`, "secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected event to be accepted, got %d %q", w.Code, w.Body.String())
+	}
+
+	var m *hugot.Message
+	select {
+	case m = <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+	if !m.ToBot || m.Private || m.Text != "ping" || m.From != "bob" || m.UserID != "UBOB" {
+		t.Fatalf("unexpected message %#v", m)
+	}
+	if m.Channel != "C1" || m.ID != "1.2" || m.ThreadID != "1.1" {
+		t.Fatalf("unexpected message %#v", m)
+	}
+}
+
+func TestEvents_Retries(t *testing.T) {
+	api := newTestAPI(t, nil)
+
+	e, err := NewEvents("xoxb-test", "secret", "hugot", WithAPIURL(api.URL+"/"))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	ev := `{"type":"event_callback","event_id":"Ev1","event":{
+		"type":"message","channel":"C1","channel_type":"channel","user":"UBOB",
+		"text":"<@UBOT> deploy","ts":"1.2"}}`
+
+	// nothing is reading messages, the event should still be acknowledged
+	for i := 0; i < 2; i++ {
+		if w := signedPost(e, ev, "secret"); w.Code != http.StatusOK {
+			t.Fatalf("expected event to be accepted, got %d %q", w.Code, w.Body.String())
+		}
+	}
+
+	select {
+	case m := <-e.Receive():
+		if m.Text != "deploy" {
+			t.Fatalf("unexpected message %#v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+
+	select {
+	case m := <-e.Receive():
+		t.Fatalf("expected retried event to be dropped, got %#v", m)
+	default:
+	}
+}
+
+func TestSocketMode(t *testing.T) {
+	acks := make(chan string, 10)
+	var api *httptest.Server
+	api = newTestAPI(t, func(mux *http.ServeMux) {
+		mux.HandleFunc("/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer xapp-test" {
+				io.WriteString(w, `{"ok":false,"error":"invalid_auth"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"ok":true,"url":"ws%s/ws"}`, strings.TrimPrefix(api.URL, "http"))
+		})
+		mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+			up := websocket.Upgrader{}
+			c, err := up.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer c.Close()
+
+			c.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello"}`))
+			c.WriteMessage(websocket.TextMessage, []byte(`{"type":"events_api","envelope_id":"env1","payload":{
+				"type":"event_callback","event":{
+				"type":"message","channel":"D1","channel_type":"im","user":"UBOB",
+				"text":"ping","ts":"1.2"}}}`))
+
+			var ack struct {
+				EnvelopeID string `json:"envelope_id"`
+			}
+			if err := c.ReadJSON(&ack); err == nil {
+				acks <- ack.EnvelopeID
+			}
+			c.ReadMessage()
+		})
+	})
+
+	a, err := NewSocketMode("xapp-test", "xoxb-test", "hugot", WithAPIURL(api.URL+"/"))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	var m *hugot.Message
+	select {
+	case m = <-a.Receive():
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+
+	if !m.ToBot || !m.Private || m.Text != "ping" || m.From != "bob" || m.Channel != "D1" {
+		t.Fatalf("unexpected message %#v", m)
+	}
+
+	select {
+	case id := <-acks:
+		if id != "env1" {
+			t.Fatalf("expected ack for env1, got %q", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for ack")
+	}
+}
+
+func TestInteractions(t *testing.T) {
+	responses := make(chan map[string]interface{}, 10)
+	posted := make(chan url.Values, 10)
+	api := newTestAPI(t, func(mux *http.ServeMux) {
+		mux.HandleFunc("/response", func(w http.ResponseWriter, r *http.Request) {
+			var resp map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&resp)
+			responses <- resp
+			io.WriteString(w, "ok")
+		})
+		mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			posted <- r.PostForm
+			io.WriteString(w, `{"ok":true,"channel":"C1","ts":"1.3"}`)
+		})
+	})
+
+	e, err := NewEvents("xoxb-test", "secret", "hugot", WithAPIURL(api.URL+"/"))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	cs := command.Set{}
+	cs.MustAdd(command.NewFunc(func(root *command.Command) error {
+		root.Use = "deploy"
+		root.Short = "deploy a release"
+		root.Run = func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+			fmt.Fprintf(w, "deploying to %s for %s", strings.Join(args, ","), m.From)
+			return nil
+		}
+		return nil
+	}))
+
+	i, err := NewInteractions("secret", e)
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+	i.HandleAction("approve", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, ba *client.BlockAction) error {
+		fmt.Fprintf(w, "%s approved %s, %s", m.From, m.Text, m.ID)
+		return nil
+	}, "releaser")
+	h := i.Actions(cs)
+
+	// handle processes the next message received as the bot would, with
+	// the sender holding roles. Like a Mux, it passes the handler a copy.
+	handle := func(roles ...string) (*hugot.Message, error) {
+		t.Helper()
+		select {
+		case m := <-i.Receive():
+			rs := map[string]struct{}{}
+			for _, r := range roles {
+				rs[r] = struct{}{}
+			}
+			ctx := hugot.NewRolesContext(context.Background(), rs)
+			err := h.ProcessMessage(ctx, hugot.NewResponseWriter(i, *m, "slack"), m.Copy())
+			i.Complete(ctx, m, err)
+			return m, err
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message")
+		}
+		return nil, nil
+	}
+	expect := func(txt string) {
+		t.Helper()
+		select {
+		case resp := <-responses:
+			if resp["text"] != txt || resp["response_type"] != "in_channel" {
+				t.Fatalf("expected %q, got %#v", txt, resp)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", txt)
+		}
+	}
+
+	form := url.Values{
+		"command":      {"/deploy"},
+		"text":         {"prod"},
+		"channel_id":   {"C1"},
+		"user_id":      {"UBOB"},
+		"user_name":    {"bob"},
+		"response_url": {api.URL + "/response"},
+	}
+	if w := signedPost(i, form.Encode(), "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected request with bad signature to be rejected, got %d", w.Code)
+	}
+	if w := signedPost(i, form.Encode(), "secret"); w.Code != http.StatusOK {
+		t.Fatalf("expected slash command to be accepted, got %d %q", w.Code, w.Body.String())
+	}
+	m, err := handle()
+	if err != nil {
+		t.Fatalf("handler failed, %v", err)
+	}
+	expect("deploying to prod for bob")
+
+	// once the command is complete, messages are sent with the web API
+	i.Send(context.Background(), m.Reply("done"))
+	select {
+	case f := <-posted:
+		if f.Get("text") != "done" || f.Get("channel") != "C1" {
+			t.Fatalf("unexpected message %v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+
+	payload := fmt.Sprintf(`{"type":"block_actions","user":{"id":"UBOB","name":"bob"},
+		"channel":{"id":"C1","name":"general"},"message":{"ts":"1.2"},
+		"response_url":%q,
+		"actions":[{"action_id":"approve","block_id":"b1","value":"v1.2.3"}]}`, api.URL+"/response")
+	form = url.Values{"payload": {payload}}
+	if w := signedPost(i, form.Encode(), "secret"); w.Code != http.StatusOK {
+		t.Fatalf("expected action to be accepted, got %d %q", w.Code, w.Body.String())
+	}
+	if _, err := handle(); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("expected action to be denied without the releaser role, got %v", err)
+	}
+
+	if w := signedPost(i, form.Encode(), "secret"); w.Code != http.StatusOK {
+		t.Fatalf("expected action to be accepted, got %d %q", w.Code, w.Body.String())
+	}
+	if _, err := handle("releaser"); err != nil {
+		t.Fatalf("handler failed, %v", err)
+	}
+	expect("bob approved v1.2.3, 1.2")
+}
+
+func TestSend_Blocks(t *testing.T) {
+	posted := make(chan url.Values, 1)
+	api := newTestAPI(t, func(mux *http.ServeMux) {
+		mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			posted <- r.PostForm
+			io.WriteString(w, `{"ok":true,"channel":"C1","ts":"1.3"}`)
+		})
+	})
+
+	s, err := newSlack("xoxb-test", "hugot", []Opt{WithAPIURL(api.URL + "/")})
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	m := &hugot.Message{
+		Channel: "C1",
+		Text:    "release ready",
+		Blocks: []hugot.Block{
+			hugot.Actions{Buttons: []hugot.Button{{ID: "approve", Text: "Approve", Value: "v1", Style: hugot.ButtonPrimary}}},
+		},
+	}
+	if id := s.Send(context.Background(), m); id != "1.3" {
+		t.Fatalf("expected message ID 1.3, got %q", id)
+	}
+
+	f := <-posted
+	var bs []map[string]interface{}
+	if err := json.Unmarshal([]byte(f.Get("blocks")), &bs); err != nil {
+		t.Fatalf("could not parse blocks %q, %v", f.Get("blocks"), err)
+	}
+	if len(bs) != 2 || bs[0]["type"] != "section" || bs[1]["type"] != "actions" {
+		t.Fatalf("unexpected blocks %s", f.Get("blocks"))
+	}
+	if !strings.Contains(f.Get("blocks"), `"action_id":"approve"`) {
+		t.Fatalf("expected approve button, got %s", f.Get("blocks"))
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	api := newTestAPI(t, func(mux *http.ServeMux) {
+		mux.HandleFunc("/channels.info", func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			switch r.Form.Get("channel") {
+			case "C1":
+				io.WriteString(w, `{"ok":true,"channel":{"id":"C1","name":"general"}}`)
+			default:
+				io.WriteString(w, `{"ok":false,"error":"channel_not_found"}`)
+			}
+		})
+	})
+
+	s, err := newSlack("xoxb-test", "hugot", []Opt{WithAPIURL(api.URL + "/")})
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	for _, u := range []string{"UBOB", "bob", "@bob", "<@UBOB>", "<@UBOB|bob>"} {
+		if id, err := s.UserID(u); err != nil || id != "UBOB" {
+			t.Errorf("expected %q to resolve to UBOB, got %q, %v", u, id, err)
+		}
+	}
+	if id, err := s.UserID("@carol"); err == nil {
+		t.Errorf("expected unknown user to fail, got %q", id)
+	}
+
+	for _, c := range []string{"C1", "general", "#general", "<#C1|general>"} {
+		if id, err := s.ChannelID(c); err != nil || id != "C1" {
+			t.Errorf("expected %q to resolve to C1, got %q, %v", c, id, err)
+		}
+	}
+	if id, err := s.ChannelID("#nosuch"); err == nil {
+		t.Errorf("expected unknown channel to fail, got %q", id)
+	}
+}
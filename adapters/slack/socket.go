@@ -0,0 +1,164 @@
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tcolgate/hugot"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// envelope wraps all messages sent over a Socket Mode connection.
+type envelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason"`
+}
+
+type socketMode struct {
+	*slack
+
+	start sync.Once
+	msgs  chan *hugot.Message
+}
+
+// NewSocketMode creates a new adapter that receives messages using Slack's
+// Socket Mode, and sends them with the Web API. appToken is an app level
+// token with the connections:write scope, token is the bot's API token.
+// Socket Mode does not require the bot to be reachable from slack.
+func NewSocketMode(appToken, token, nick string, opts ...Opt) (hugot.Adapter, error) {
+	if appToken == "" {
+		return nil, errors.New("Slack app token must be set")
+	}
+
+	s, err := newSlack(token, nick, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.appToken = appToken
+
+	return &socketMode{
+		slack: s,
+		msgs:  make(chan *hugot.Message),
+	}, nil
+}
+
+// Receive implements hugot.Receiver
+func (sm *socketMode) Receive() <-chan *hugot.Message {
+	sm.start.Do(func() {
+		go sm.run()
+	})
+	return sm.msgs
+}
+
+// run connects to slack, reconnecting with a backoff if the connection
+// fails.
+func (sm *socketMode) run() {
+	backoff := minBackoff
+	for {
+		err := sm.runOnce()
+		if err == nil {
+			backoff = minBackoff
+			continue
+		}
+
+		sm.log.Error("socket mode connection failed", "error", err, "retry", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// runOnce processes events from a single connection. A nil error is
+// returned if slack asked us to reconnect.
+func (sm *socketMode) runOnce() error {
+	u, err := sm.connectionURL()
+	if err != nil {
+		return err
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sm.log.Info("connected")
+	for {
+		var env envelope
+		if err := conn.ReadJSON(&env); err != nil {
+			return err
+		}
+
+		if env.EnvelopeID != "" {
+			ack := struct {
+				EnvelopeID string `json:"envelope_id"`
+			}{env.EnvelopeID}
+			if err := conn.WriteJSON(ack); err != nil {
+				return err
+			}
+		}
+
+		switch env.Type {
+		case "hello":
+		case "disconnect":
+			sm.log.Info("disconnect requested", "reason", env.Reason)
+			return nil
+		case "events_api":
+			ev, err := slackevents.ParseEvent(env.Payload, slackevents.OptionNoVerifyToken())
+			if err != nil {
+				sm.log.Error("could not parse event", "error", err)
+				continue
+			}
+			if m := sm.eventToHugot(ev); m != nil {
+				sm.msgs <- m
+			}
+		default:
+			sm.log.Debug("unexpected envelope", "type", env.Type)
+		}
+	}
+}
+
+// connectionURL requests a URL for a new Socket Mode connection.
+func (sm *socketMode) connectionURL() (string, error) {
+	req, err := http.NewRequest("POST", sm.apiURL+"apps.connections.open", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+sm.appToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		OK    bool   `json:"ok"`
+		URL   string `json:"url"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if !res.OK {
+		return "", fmt.Errorf("apps.connections.open failed, %s", res.Error)
+	}
+
+	return res.URL, nil
+}
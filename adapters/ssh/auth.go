@@ -0,0 +1,121 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tcolgate/hugot/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// Permission extensions used to pass the identity of an authenticated key
+// to the session.
+const (
+	extFingerprint = "hugot-fingerprint"
+	extUser        = "hugot-user"
+)
+
+var errUnknownKey = errors.New("unknown public key")
+
+// KeyLookup returns the username that key belongs to. If the key is not
+// known, ok is false.
+type KeyLookup func(key ssh.PublicKey) (user string, ok bool, err error)
+
+// WithKeyLookup adds a function used to authorize public keys.
+func WithKeyLookup(f KeyLookup) Opt {
+	return func(a *SSH) {
+		a.lookups = append(a.lookups, f)
+	}
+}
+
+// WithAuthorizedKeysFile authorizes the keys listed in an OpenSSH
+// authorized_keys file. The comment of each key is used as the username
+// of its owner. The file is read each time a key is checked, so keys can
+// be added and removed without restarting the bot. Key options, such as
+// from= or command=, are not supported, and keys that have any are
+// rejected rather than accepted without their restrictions.
+func WithAuthorizedKeysFile(path string) Opt {
+	return WithKeyLookup(func(key ssh.PublicKey) (string, bool, error) {
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, err
+		}
+		return findAuthorizedKey(bs, key)
+	})
+}
+
+// WithKeyStore authorizes keys that have been added to s with
+// AuthorizeKey.
+func WithKeyStore(s storage.Storer) Opt {
+	return WithKeyLookup(func(key ssh.PublicKey) (string, bool, error) {
+		return s.Get([]string{ssh.FingerprintSHA256(key)})
+	})
+}
+
+// AuthorizeKey adds key to a store used with WithKeyStore, as belonging
+// to user.
+func AuthorizeKey(s storage.Storer, key ssh.PublicKey, user string) error {
+	return s.Set([]string{ssh.FingerprintSHA256(key)}, user)
+}
+
+// RevokeKey removes key from a store used with WithKeyStore.
+func RevokeKey(s storage.Storer, key ssh.PublicKey) error {
+	return s.Unset([]string{ssh.FingerprintSHA256(key)})
+}
+
+// findAuthorizedKey looks for key in the content of an authorized_keys
+// file. An error is returned if the key's entry has options.
+func findAuthorizedKey(bs []byte, key ssh.PublicKey) (string, bool, error) {
+	want := key.Marshal()
+	for len(bytes.TrimSpace(bs)) > 0 {
+		k, comment, options, rest, err := ssh.ParseAuthorizedKey(bs)
+		if err != nil {
+			// ParseAuthorizedKey skips lines it cannot parse, so this
+			// only happens when no keys remain.
+			return "", false, nil
+		}
+		if bytes.Equal(k.Marshal(), want) {
+			if len(options) > 0 {
+				return "", false, fmt.Errorf("authorized key has unsupported options %s", strings.Join(options, ","))
+			}
+			return strings.TrimSpace(comment), true, nil
+		}
+		bs = rest
+	}
+	return "", false, nil
+}
+
+// checkKey is used as the PublicKeyCallback of the server. Keys that are
+// not known to any of the lookups are rejected. The username of a key
+// defaults to its fingerprint, if the lookup does not provide one.
+func (a *SSH) checkKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	fp := ssh.FingerprintSHA256(key)
+
+	for _, l := range a.lookups {
+		user, ok, err := l(key)
+		if err != nil {
+			a.log.Error("key lookup failed", "fingerprint", fp, "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if user == "" {
+			user = fp
+		}
+		a.log.Debug("accepted public key", "fingerprint", fp, "user", user, "remote", conn.RemoteAddr())
+		return &ssh.Permissions{
+			Extensions: map[string]string{
+				extFingerprint: fp,
+				extUser:        user,
+			},
+		}, nil
+	}
+
+	a.log.Info("rejected public key", "fingerprint", fp, "user", conn.User(), "remote", conn.RemoteAddr())
+	return nil, fmt.Errorf("%w %s", errUnknownKey, fp)
+}
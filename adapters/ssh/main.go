@@ -1,22 +1,31 @@
 // Package ssh implements an adapter that serves SSH connections. Users
-// can ssh into the bot o interact with it. Thsi is purely a toy adapter
-// at this time.
+// can ssh into the bot to interact with it. Users authenticate with
+// public keys, which must be authorized with WithAuthorizedKeysFile,
+// WithKeyStore, or WithKeyLookup. The fingerprint of the user's key is
+// used as the UserID of their messages, and the username the key belongs
+// to as From.
+//
+// Users may open an interactive shell, or run a single command, as in
+// "ssh bot@host deploy staging". The replies to a command are written
+// to the client, and the session exits once the command has been
+// handled, with a status of 1 if it failed. This requires a bot that
+// reports completed messages, see hugot.Completer. Output is wrapped to
+// the width of the user's terminal, if they have one.
 package ssh
 
 import (
 	"context"
 	"encoding/base64"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
 	"strings"
 	"sync"
-	"syscall"
-	"unsafe"
+	"sync/atomic"
+	"unicode/utf8"
 
-	"github.com/golang/glog"
 	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -28,88 +37,174 @@ type SSH struct {
 	listener net.Listener
 	config   *ssh.ServerConfig
 
+	lookups []KeyLookup
+
 	running sync.Once
 
 	rch chan *hugot.Message
 
 	sync.RWMutex
-	schs map[string]chan *hugot.Message
+	sessions map[string]*session
+	nextID   uint64
+
+	log hugot.Logger
+}
+
+// session is a single session channel of a connection.
+type session struct {
+	id     string
+	user   string
+	userID string
+	ch     ssh.Channel
+
+	sync.Mutex
+	term  *terminal.Terminal // only set for interactive shells
+	pty   bool
+	width int
+	done  chan error // only set for exec sessions
+}
+
+// Payloads of the session requests we handle, as defined in RFC 4254.
+type ptyRequest struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	Width    uint32
+	Height   uint32
+	Modelist string
+}
+
+type windowChange struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type execRequest struct {
+	Command string
+}
+
+type exitStatus struct {
+	Status uint32
+}
+
+// Opt functions are used to set options on the SSH adapter.
+type Opt func(*SSH)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(a *SSH) {
+		a.log = l
+	}
 }
 
 func (a *SSH) runOnce() {
 	a.running.Do(func() {
-		a.run()
+		go a.run()
 	})
 }
 
-// New creates a new SSH Adapter
-func New(nick string, l net.Listener, cfg *ssh.ServerConfig) *SSH {
-	return &SSH{
-		nick,
-		l,
-		cfg,
-		sync.Once{},
-
-		make(chan *hugot.Message),
+// New creates a new SSH Adapter. The adapter uses a copy of cfg, with
+// the PublicKeyCallback replaced by one that only accepts keys authorized
+// by the adapter's options; cfg itself is not changed. Any other
+// authentication methods configured in cfg are left in place, but their
+// sessions have the client supplied username as From, and no UserID.
+func New(nick string, l net.Listener, cfg *ssh.ServerConfig, opts ...Opt) *SSH {
+	a := &SSH{
+		nick:     nick,
+		listener: l,
+		rch:      make(chan *hugot.Message),
+		sessions: make(map[string]*session),
+		log:      hugot.DefaultLogger,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.log = a.log.With(hugot.LogAdapter, "ssh")
 
-		sync.RWMutex{},
-		make(map[string]chan *hugot.Message),
+	if len(a.lookups) == 0 {
+		a.log.Info("no authorized keys configured, all public keys will be rejected")
 	}
+	c := *cfg
+	c.PublicKeyCallback = a.checkKey
+	a.config = &c
+
+	return a
+}
+
+// IsTextOnly hints that this adapter only supports text.
+func (a *SSH) IsTextOnly() {
 }
 
 // Receive can be used to receieve message from users.
 func (a *SSH) Receive() <-chan *hugot.Message {
-	go a.run()
+	a.runOnce()
 
 	return a.rch
 }
 
 // Send can be used to Send responses back to users.
-func (a *SSH) Send(ctx context.Context, m *hugot.Message) {
-	go a.run()
+func (a *SSH) Send(ctx context.Context, m *hugot.Message) string {
+	a.runOnce()
 
-	a.RLock()
-	sch, ok := a.schs[m.Channel]
-	a.RUnlock()
+	s, ok := a.session(m.Channel)
+	if !ok {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", a)).Inc()
+		a.log.Error("message to unknown session", hugot.LogChannel, m.Channel)
+		return ""
+	}
+
+	if err := s.write(a.nick, m.PlainText()); err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", a)).Inc()
+		a.log.Error("error writing to session", hugot.LogChannel, m.Channel, "error", err)
+	}
+	return ""
+}
 
+// Complete implements hugot.Completer. Sessions that were started to run
+// a single command are closed once it has been handled.
+func (a *SSH) Complete(ctx context.Context, m *hugot.Message, err error) {
+	s, ok := a.session(m.Channel)
 	if !ok {
-		glog.Errorf("ssh command to unknown session")
 		return
 	}
 
-	sch <- m
+	s.Lock()
+	done := s.done
+	s.Unlock()
+	if done == nil {
+		return
+	}
+
+	select {
+	case done <- err:
+	default:
+	}
+}
+
+func (a *SSH) session(id string) (*session, bool) {
+	a.RLock()
+	defer a.RUnlock()
+	s, ok := a.sessions[id]
+	return s, ok
 }
 
 func (a *SSH) run() {
 	for {
 		tcpConn, err := a.listener.Accept()
 		if err != nil {
-			glog.Errorf("Failed to accept incoming connection (%s)", err)
+			a.log.Error("failed to accept incoming connection", "error", err)
 			continue
 		}
 		// Before use, a handshake must be performed on the incoming net.Conn.
-		a.config.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-			keyStr := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
-			if glog.V(2) {
-				glog.Infof("ssh public key: %s", keyStr)
-			}
-
-			//			pkey, err := models.SearchPublicKeyByContent(keyStr)
-			//			if err != nil {
-			//				// handle error
-			//				return nil, err
-			//			}
-			//			return &ssh.Permissions{Extensions: map[string]string{"key-id": com.ToStr(pkey.ID)}}, nil
-			return nil, nil
-		}
-
 		sshConn, chans, reqs, err := ssh.NewServerConn(tcpConn, a.config)
 		if err != nil {
-			glog.Errorf("Failed to handshake (%s)", err)
+			a.log.Error("failed to handshake", "error", err)
 			continue
 		}
 
-		glog.Infof("New SSH connection from %s (%s)", sshConn.RemoteAddr(), sshConn.ClientVersion())
+		a.log.Info("new connection", "remote", sshConn.RemoteAddr(), "client", string(sshConn.ClientVersion()))
 		// Discard all global out-of-band Requests
 		go ssh.DiscardRequests(reqs)
 		// Accept all channels
@@ -117,14 +212,14 @@ func (a *SSH) run() {
 	}
 }
 
-func (a *SSH) handleChannels(chans <-chan ssh.NewChannel, sshConn ssh.Conn) {
+func (a *SSH) handleChannels(chans <-chan ssh.NewChannel, sshConn *ssh.ServerConn) {
 	// Service the incoming Channel channel in go routine
 	for newChannel := range chans {
 		go a.handleChannel(newChannel, sshConn)
 	}
 }
 
-func (a *SSH) handleChannel(newChannel ssh.NewChannel, sshConn ssh.Conn) {
+func (a *SSH) handleChannel(newChannel ssh.NewChannel, sshConn *ssh.ServerConn) {
 	if t := newChannel.ChannelType(); t != "session" {
 		newChannel.Reject(ssh.UnknownChannelType, fmt.Sprintf("unknown channel type: %s", t))
 		return
@@ -132,59 +227,96 @@ func (a *SSH) handleChannel(newChannel ssh.NewChannel, sshConn ssh.Conn) {
 
 	connection, requests, err := newChannel.Accept()
 	if err != nil {
-		glog.Errorf("Could not accept channel (%s)", err)
+		a.log.Error("could not accept channel", "error", err)
 		return
 	}
 
+	// Users authenticated by key are identified by the key, rather than
+	// the username they supplied.
 	user := sshConn.User()
-	session := base64.StdEncoding.EncodeToString(sshConn.SessionID())
+	userID := ""
+	if p := sshConn.Permissions; p != nil && p.Extensions[extFingerprint] != "" {
+		user = p.Extensions[extUser]
+		userID = p.Extensions[extFingerprint]
+	}
+
+	// A connection may carry several sessions.
+	n := atomic.AddUint64(&a.nextID, 1)
+	s := &session{
+		id:     fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(sshConn.SessionID()), n),
+		user:   user,
+		userID: userID,
+		ch:     connection,
+	}
 	a.Lock()
-	sch := make(chan *hugot.Message)
-	a.schs[session] = sch
+	a.sessions[s.id] = s
 	a.Unlock()
 
-	// Prepare teardown function
-	closeconn := func() {
+	// closed tells a running command that the client has gone away.
+	closed := make(chan struct{})
+	defer func() {
+		a.Lock()
+		delete(a.sessions, s.id)
+		a.Unlock()
+		close(closed)
 		connection.Close()
-	}
-
-	t := terminal.NewTerminal(connection, user+"> ")
-
-	done := make(chan struct{})
-	go func() {
-		for {
-			select {
-			case m := <-sch:
-				for _, l := range strings.Split(m.Text, "\n") {
-					fmt.Fprintf(t, "%s: %s\r\n", a.nick, l)
-				}
-			case <-done:
-				break
-			}
-		}
 	}()
 
 	// Sessions have out-of-band requests such as "shell", "pty-req" and "env"
-	go func() {
-		for req := range requests {
-			switch req.Type {
-			case "shell":
-				// We only accept the default shell
-				// (i.e. no command in the Payload)
-				if len(req.Payload) == 0 {
-					req.Reply(true, nil)
-				}
-			case "pty-req":
-				//termLen := req.Payload[3]
-				//w, h := parseDims(req.Payload[termLen+4:])
-				//should do something with the terminal info
-				req.Reply(true, nil)
-			case "window-change":
-				//w, h := parseDims(req.Payload)
-				//setWinsize(bashf.Fd(), w, h)
+	started := false
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			var pty ptyRequest
+			if err := ssh.Unmarshal(req.Payload, &pty); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			s.resize(int(pty.Columns), int(pty.Rows))
+			s.Lock()
+			s.pty = true
+			s.Unlock()
+			req.Reply(true, nil)
+		case "window-change":
+			var wc windowChange
+			if err := ssh.Unmarshal(req.Payload, &wc); err == nil {
+				s.resize(int(wc.Columns), int(wc.Rows))
+			}
+		case "shell":
+			// We only accept the default shell
+			// (i.e. no command in the Payload)
+			if started || len(req.Payload) != 0 {
+				req.Reply(false, nil)
+				continue
 			}
+			started = true
+			req.Reply(true, nil)
+			go a.shell(s)
+		case "exec":
+			var cmd execRequest
+			if started || ssh.Unmarshal(req.Payload, &cmd) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			started = true
+			req.Reply(true, nil)
+			go a.exec(s, cmd.Command, closed)
+		default:
+			req.Reply(false, nil)
 		}
-	}()
+	}
+}
+
+// shell reads lines from an interactive session, and passes them to the
+// bot, until the user disconnects.
+func (a *SSH) shell(s *session) {
+	t := terminal.NewTerminal(s.ch, s.user+"> ")
+	s.Lock()
+	s.term = t
+	if s.width > 0 {
+		t.SetSize(s.width, 0)
+	}
+	s.Unlock()
 
 	for {
 		ln, err := t.ReadLine()
@@ -195,34 +327,120 @@ func (a *SSH) handleChannel(newChannel ssh.NewChannel, sshConn ssh.Conn) {
 			break
 		}
 
-		a.rch <- &hugot.Message{Text: string(ln), ToBot: true, From: user, UserID: user, Channel: session}
+		a.rch <- &hugot.Message{Text: string(ln), ToBot: true, From: s.user, UserID: s.userID, Channel: s.id}
 	}
 
-	a.Lock()
-	defer a.Unlock()
-	delete(a.schs, session)
+	s.exit(0)
+}
+
+// exec passes a single command to the bot, and closes the session once
+// it has been handled.
+func (a *SSH) exec(s *session, cmd string, closed <-chan struct{}) {
+	done := make(chan error, 1)
+	s.Lock()
+	s.done = done
+	s.Unlock()
+
+	a.log.Debug("running command", hugot.LogUser, s.user, "command", cmd)
+	select {
+	case a.rch <- &hugot.Message{Text: cmd, ToBot: true, From: s.user, UserID: s.userID, Channel: s.id}:
+	case <-closed:
+		return
+	}
 
-	done <- struct{}{}
-	closeconn()
+	select {
+	case err := <-done:
+		status := uint32(0)
+		if err != nil {
+			status = 1
+		}
+		s.exit(status)
+	case <-closed:
+	}
 }
 
-// parseDims extracts terminal dimensions (width x height) from the provided buffer.
-func parseDims(b []byte) (uint32, uint32) {
-	w := binary.BigEndian.Uint32(b)
-	h := binary.BigEndian.Uint32(b[4:])
-	return w, h
+// exit sends the exit status of the session to the client, and closes it.
+func (s *session) exit(status uint32) {
+	s.ch.SendRequest("exit-status", false, ssh.Marshal(exitStatus{status}))
+	s.ch.Close()
 }
 
-// Winsize stores the Height and Width of a terminal.
-type winsize struct {
-	Height uint16
-	Width  uint16
-	x      uint16 // unused
-	y      uint16 // unused
+func (s *session) resize(w, h int) {
+	s.Lock()
+	defer s.Unlock()
+	s.width = w
+	if s.term != nil {
+		s.term.SetSize(w, h)
+	}
 }
 
-// setWinsize sets the size of the given pty.
-func setWinsize(fd uintptr, w, h uint32) {
-	ws := &winsize{Width: uint16(w), Height: uint16(h)}
-	syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(syscall.TIOCSWINSZ), uintptr(unsafe.Pointer(ws)))
+// write writes txt to the session, wrapped to the width of the user's
+// terminal. Interactive sessions prefix each line with the bot's nick.
+func (s *session) write(nick, txt string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	prefix, eol := "", "\n"
+	var w io.Writer = s.ch
+	if s.term != nil {
+		prefix, w = nick+": ", s.term
+	}
+	if s.pty {
+		eol = "\r\n"
+	}
+
+	width := 0
+	if s.width > 0 {
+		width = s.width - utf8.RuneCountInString(prefix)
+	}
+
+	var out strings.Builder
+	for _, l := range strings.Split(txt, "\n") {
+		for _, wl := range wrap(l, width) {
+			out.WriteString(prefix + wl + eol)
+		}
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// wrap splits l into lines no wider than width, breaking at spaces where
+// possible. Continuation lines are indented to line up with the last
+// column of text in the first half of l, so that tables, such as help
+// output, stay readable. A width of 0 disables wrapping.
+func wrap(l string, width int) []string {
+	l = strings.TrimRight(l, " ")
+	rs := []rune(strings.ReplaceAll(l, "\t", "        "))
+	if width <= 0 || len(rs) <= width {
+		return []string{l}
+	}
+
+	indent := 0
+	for indent < len(rs) && rs[indent] == ' ' {
+		indent++
+	}
+	for i := indent + 1; i < width/2 && i < len(rs); i++ {
+		if rs[i-1] == ' ' && rs[i] != ' ' && i >= 2 && rs[i-2] == ' ' {
+			indent = i
+		}
+	}
+	if indent >= width/2 {
+		indent = 0
+	}
+
+	var out []string
+	for len(rs) > width {
+		brk := width
+		for i := width; i > indent; i-- {
+			if rs[i] == ' ' {
+				brk = i
+				break
+			}
+		}
+		out = append(out, strings.TrimRight(string(rs[:brk]), " "))
+
+		rest := strings.TrimLeft(string(rs[brk:]), " ")
+		rs = []rune(strings.Repeat(" ", indent) + rest)
+	}
+	return append(out, string(rs))
 }
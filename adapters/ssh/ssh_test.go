@@ -0,0 +1,372 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/handlers/hears"
+	"github.com/tcolgate/hugot/storage/memory"
+	"golang.org/x/crypto/ssh"
+)
+
+func newKey(t *testing.T) ssh.Signer {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key, %v", err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("could not create signer, %v", err)
+	}
+	return s
+}
+
+// newTestServer starts an adapter with the given options, returning the
+// adapter and its address.
+func newTestServer(t *testing.T, opts ...Opt) (*SSH, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	cfg := &ssh.ServerConfig{
+		// Should be replaced by the adapter.
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(newKey(t))
+
+	a := New("hugot", ln, cfg, opts...)
+	return a, ln.Addr().String()
+}
+
+func dial(addr, user string, key ssh.Signer) (*ssh.Client, error) {
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+// say opens a shell session, and sends a line of text.
+func say(t *testing.T, c *ssh.Client, txt string) {
+	s, err := c.NewSession()
+	if err != nil {
+		t.Fatalf("could not open session, %v", err)
+	}
+	in, err := s.StdinPipe()
+	if err != nil {
+		t.Fatalf("could not get stdin, %v", err)
+	}
+	if err := s.Shell(); err != nil {
+		t.Fatalf("could not start shell, %v", err)
+	}
+	if _, err := in.Write([]byte(txt + "\r")); err != nil {
+		t.Fatalf("write failed, %v", err)
+	}
+}
+
+func receive(t *testing.T, c <-chan *hugot.Message) *hugot.Message {
+	select {
+	case m := <-c:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+	return nil
+}
+
+func TestAuthorizedKeysFile(t *testing.T) {
+	alice, bob, carol, mallory := newKey(t), newKey(t), newKey(t), newKey(t)
+
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	keys := "# team keys\n" +
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(alice.PublicKey()))) + " alice\n" +
+		`from="10.0.0.1",no-pty ` + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(carol.PublicKey()))) + " carol\n" +
+		string(ssh.MarshalAuthorizedKey(bob.PublicKey()))
+	if err := os.WriteFile(path, []byte(keys), 0600); err != nil {
+		t.Fatalf("could not write keys, %v", err)
+	}
+
+	a, addr := newTestServer(t, WithAuthorizedKeysFile(path))
+	msgs := a.Receive()
+
+	if _, err := dial(addr, "alice", mallory); err == nil {
+		t.Fatalf("expected unknown key to be rejected")
+	}
+	if _, err := dial(addr, "carol", carol); err == nil {
+		t.Fatalf("expected key with options to be rejected")
+	}
+
+	c, err := dial(addr, "root", alice)
+	if err != nil {
+		t.Fatalf("could not connect, %v", err)
+	}
+	defer c.Close()
+	say(t, c, "ping")
+
+	m := receive(t, msgs)
+	if m.Text != "ping" || m.From != "alice" || m.UserID != ssh.FingerprintSHA256(alice.PublicKey()) {
+		t.Fatalf("unexpected message %#v", m)
+	}
+
+	// Keys without a comment are known by their fingerprint.
+	c, err = dial(addr, "bob", bob)
+	if err != nil {
+		t.Fatalf("could not connect, %v", err)
+	}
+	defer c.Close()
+	say(t, c, "hello")
+
+	m = receive(t, msgs)
+	if fp := ssh.FingerprintSHA256(bob.PublicKey()); m.From != fp || m.UserID != fp {
+		t.Fatalf("unexpected message %#v", m)
+	}
+}
+
+func TestKeyStore(t *testing.T) {
+	alice := newKey(t)
+	s := memory.New()
+
+	a, addr := newTestServer(t, WithKeyStore(s))
+	msgs := a.Receive()
+
+	if _, err := dial(addr, "alice", alice); err == nil {
+		t.Fatalf("expected unknown key to be rejected")
+	}
+
+	if err := AuthorizeKey(s, alice.PublicKey(), "alice"); err != nil {
+		t.Fatalf("could not authorize key, %v", err)
+	}
+	c, err := dial(addr, "someone", alice)
+	if err != nil {
+		t.Fatalf("could not connect, %v", err)
+	}
+	defer c.Close()
+	say(t, c, "ping")
+
+	if m := receive(t, msgs); m.From != "alice" || m.UserID != ssh.FingerprintSHA256(alice.PublicKey()) {
+		t.Fatalf("unexpected message %#v", m)
+	}
+
+	if err := RevokeKey(s, alice.PublicKey()); err != nil {
+		t.Fatalf("could not revoke key, %v", err)
+	}
+	if _, err := dial(addr, "alice", alice); err == nil {
+		t.Fatalf("expected revoked key to be rejected")
+	}
+}
+
+func TestNoKeys(t *testing.T) {
+	a, addr := newTestServer(t)
+	a.Receive()
+	if _, err := dial(addr, "alice", newKey(t)); err == nil {
+		t.Fatalf("expected all keys to be rejected")
+	}
+}
+
+func TestNew_CopiesConfig(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, %v", err)
+	}
+	defer ln.Close()
+
+	cfg := &ssh.ServerConfig{}
+	New("hugot", ln, cfg)
+	if cfg.PublicKeyCallback != nil {
+		t.Fatalf("expected the caller's config to be left unchanged")
+	}
+}
+
+func TestExec(t *testing.T) {
+	alice := newKey(t)
+	s := memory.New()
+	AuthorizeKey(s, alice.PublicKey(), "alice")
+
+	a, addr := newTestServer(t, WithKeyStore(s))
+	msgs := a.Receive()
+
+	var tests = []struct {
+		cmd    string
+		err    error
+		status int
+	}{
+		{"deploy staging", nil, 0},
+		{"deploy prod", errors.New("permission denied"), 1},
+	}
+	for _, tt := range tests {
+		c, err := dial(addr, "alice", alice)
+		if err != nil {
+			t.Fatalf("could not connect, %v", err)
+		}
+		defer c.Close()
+
+		sess, err := c.NewSession()
+		if err != nil {
+			t.Fatalf("could not open session, %v", err)
+		}
+		var out bytes.Buffer
+		sess.Stdout = &out
+
+		res := make(chan error, 1)
+		go func() { res <- sess.Run(tt.cmd) }()
+
+		m := receive(t, msgs)
+		if m.Text != tt.cmd || m.From != "alice" || !m.ToBot {
+			t.Fatalf("unexpected message %#v", m)
+		}
+		a.Send(context.Background(), m.Reply("deploying\ndone"))
+		if tt.err != nil {
+			a.Send(context.Background(), m.Replyf("%v", tt.err))
+		}
+		a.Complete(context.Background(), m, tt.err)
+
+		select {
+		case err = <-res:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q to exit", tt.cmd)
+		}
+		status := 0
+		if ee, ok := err.(*ssh.ExitError); ok {
+			status = ee.ExitStatus()
+		} else if err != nil {
+			t.Fatalf("command failed, %v", err)
+		}
+		if status != tt.status {
+			t.Errorf("%q exited with %d, expected %d", tt.cmd, status, tt.status)
+		}
+
+		exp := "deploying\ndone\n"
+		if tt.err != nil {
+			exp += tt.err.Error() + "\n"
+		}
+		if out.String() != exp {
+			t.Errorf("%q output %q, expected %q", tt.cmd, out.String(), exp)
+		}
+	}
+}
+
+func TestExec_FailingCommandWithHears(t *testing.T) {
+	alice := newKey(t)
+	s := memory.New()
+	AuthorizeKey(s, alice.PublicKey(), "alice")
+
+	a, addr := newTestServer(t, WithKeyStore(s))
+
+	b := bot.New()
+	b.Commands.MustAdd(command.NewFunc(func(root *command.Command) error {
+		root.Use = "deploy"
+		root.Run = func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+			return errors.New("deploy failed")
+		}
+		return nil
+	}))
+	b.Mux.Hears(hears.New("watcher", "notices deploys", regexp.MustCompile(`deploy`),
+		func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, ms [][]string) error {
+			return nil
+		}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.ListenAndServe(ctx, nil, a)
+
+	c, err := dial(addr, "alice", alice)
+	if err != nil {
+		t.Fatalf("could not connect, %v", err)
+	}
+	defer c.Close()
+
+	sess, err := c.NewSession()
+	if err != nil {
+		t.Fatalf("could not open session, %v", err)
+	}
+
+	res := make(chan error, 1)
+	go func() { res <- sess.Run("deploy staging") }()
+
+	select {
+	case err = <-res:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for command to exit")
+	}
+	if ee, ok := err.(*ssh.ExitError); !ok || ee.ExitStatus() != 1 {
+		t.Fatalf("expected failed command to exit with 1, got %v", err)
+	}
+}
+
+func TestExecPty(t *testing.T) {
+	alice := newKey(t)
+	s := memory.New()
+	AuthorizeKey(s, alice.PublicKey(), "alice")
+
+	a, addr := newTestServer(t, WithKeyStore(s))
+	msgs := a.Receive()
+
+	c, err := dial(addr, "alice", alice)
+	if err != nil {
+		t.Fatalf("could not connect, %v", err)
+	}
+	defer c.Close()
+
+	sess, err := c.NewSession()
+	if err != nil {
+		t.Fatalf("could not open session, %v", err)
+	}
+	if err := sess.RequestPty("xterm", 24, 20, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("could not request pty, %v", err)
+	}
+	var out bytes.Buffer
+	sess.Stdout = &out
+
+	res := make(chan error, 1)
+	go func() { res <- sess.Run("help") }()
+
+	m := receive(t, msgs)
+	a.Send(context.Background(), m.Reply("the quick brown fox jumps over"))
+	a.Complete(context.Background(), m, nil)
+
+	if err := <-res; err != nil {
+		t.Fatalf("command failed, %v", err)
+	}
+	if exp := "the quick brown fox\r\njumps over\r\n"; out.String() != exp {
+		t.Fatalf("output %q, expected %q", out.String(), exp)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	var tests = []struct {
+		in    string
+		width int
+		exp   []string
+	}{
+		{"short", 0, []string{"short"}},
+		{"short", 10, []string{"short"}},
+		{"the quick brown fox", 10, []string{"the quick", "brown fox"}},
+		{"abcdefghijkl", 5, []string{"abcde", "fghij", "kl"}},
+		{"  ping   - replies with pong", 20, []string{"  ping   - replies", "         with pong"}},
+	}
+	for _, tt := range tests {
+		got := wrap(tt.in, tt.width)
+		if strings.Join(got, "|") != strings.Join(tt.exp, "|") {
+			t.Errorf("wrap(%q, %d) = %q, expected %q", tt.in, tt.width, got, tt.exp)
+		}
+	}
+}
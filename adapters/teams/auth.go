@@ -0,0 +1,268 @@
+package teams
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOpenIDURL is the OpenID metadata document describing the keys
+	// the Bot Framework signs requests with.
+	DefaultOpenIDURL = "https://login.botframework.com/v1/.well-known/openidconfiguration"
+
+	// DefaultTokenURL is used to get tokens for calling the connector API.
+	DefaultTokenURL = "https://login.microsoftonline.com/botframework.com/oauth2/v2.0/token"
+
+	issuer = "https://api.botframework.com"
+	scope  = "https://api.botframework.com/.default"
+
+	// keyRefresh is how often signing keys are refetched.
+	keyRefresh = 24 * time.Hour
+
+	// keyRefetch limits how often keys are fetched when a request is
+	// signed with a key we do not know, so that unauthenticated requests
+	// cannot cause a fetch each time.
+	keyRefetch = 5 * time.Minute
+)
+
+var errUnauthorized = errors.New("unauthorized")
+
+// verifier checks the JWTs sent with requests from the Bot Framework.
+type verifier struct {
+	appID     string
+	openIDURL string
+	client    *http.Client
+
+	sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetched   time.Time
+	attempted time.Time
+}
+
+// claims are the parts of a token we check.
+type claims struct {
+	Iss        string `json:"iss"`
+	Aud        string `json:"aud"`
+	Exp        int64  `json:"exp"`
+	Nbf        int64  `json:"nbf"`
+	ServiceURL string `json:"serviceurl"`
+}
+
+// verify checks the bearer token in an Authorization header, and returns
+// its claims.
+func (v *verifier) verify(ctx context.Context, auth string) (*claims, error) {
+	tok := strings.TrimPrefix(auth, "Bearer ")
+	if tok == auth {
+		return nil, errUnauthorized
+	}
+
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return nil, errUnauthorized
+	}
+
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, err
+	}
+	if hdr.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", hdr.Alg)
+	}
+
+	key, err := v.key(ctx, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, errUnauthorized
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+
+	// Allow for a little clock skew.
+	now := time.Now().Unix()
+	const skew = 300
+	switch {
+	case c.Iss != issuer:
+		return nil, fmt.Errorf("unexpected token issuer %q", c.Iss)
+	case c.Aud != v.appID:
+		return nil, fmt.Errorf("token is not for this bot")
+	case c.Exp+skew < now:
+		return nil, fmt.Errorf("token expired")
+	case c.Nbf-skew > now:
+		return nil, fmt.Errorf("token not yet valid")
+	}
+
+	return &c, nil
+}
+
+// key returns the signing key with the given ID, fetching the keys if we
+// do not have it, or ours are old. Keys are fetched at most once every
+// keyRefetch.
+func (v *verifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	k, ok := v.keys[kid]
+	if ok && time.Since(v.fetched) < keyRefresh {
+		return k, nil
+	}
+	if time.Since(v.attempted) < keyRefetch {
+		if ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	v.attempted = time.Now()
+
+	var meta struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, v.client, v.openIDURL, &meta); err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, v.client, meta.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+
+	k, ok = v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return k, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	bs, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+func getJSON(ctx context.Context, c *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s failed, %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// tokenSource gets, and caches, tokens for calling the connector API.
+type tokenSource struct {
+	appID       string
+	appPassword string
+	tokenURL    string
+	client      *http.Client
+
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (ts *tokenSource) get(ctx context.Context) (string, error) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	if ts.token != "" && time.Now().Before(ts.expires) {
+		return ts.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {ts.appID},
+		"client_secret": {ts.appPassword},
+		"scope":         {scope},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", ts.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get token, %s: %s", resp.Status, res.Error)
+	}
+
+	// Refresh a little before the token expires.
+	ts.token = res.AccessToken
+	ts.expires = time.Now().Add(time.Duration(res.ExpiresIn)*time.Second - time.Minute)
+
+	return ts.token, nil
+}
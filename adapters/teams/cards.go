@@ -0,0 +1,146 @@
+package teams
+
+import (
+	"strings"
+
+	"github.com/tcolgate/hugot"
+)
+
+// card is an Adaptive Card, see https://adaptivecards.io/
+type card map[string]interface{}
+
+// element is an element, or action, within an Adaptive Card.
+type element map[string]interface{}
+
+const adaptiveCardType = "application/vnd.microsoft.card.adaptive"
+
+func newCard(body []element) card {
+	return card{
+		"type":    "AdaptiveCard",
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"version": "1.4",
+		"body":    body,
+	}
+}
+
+func cardAttachment(c card) attachment {
+	return attachment{ContentType: adaptiveCardType, Content: c}
+}
+
+func textBlock(txt string) element {
+	return element{"type": "TextBlock", "text": txt, "wrap": true}
+}
+
+func factSet(fs []hugot.Field) element {
+	var facts []element
+	for _, f := range fs {
+		facts = append(facts, element{"title": f.Title, "value": f.Value})
+	}
+	return element{"type": "FactSet", "facts": facts}
+}
+
+// containerStyles maps the named slack attachment colors to container
+// styles.
+var containerStyles = map[string]string{
+	"good":    "good",
+	"warning": "warning",
+	"danger":  "attention",
+}
+
+// attachmentCard renders a message attachment as an Adaptive Card.
+func attachmentCard(a hugot.Attachment) card {
+	var body []element
+	if a.AuthorName != "" {
+		body = append(body, element{"type": "TextBlock", "text": a.AuthorName, "size": "small", "isSubtle": true})
+	}
+	if a.Title != "" {
+		title := a.Title
+		if a.TitleLink != "" {
+			title = "[" + a.Title + "](" + a.TitleLink + ")"
+		}
+		body = append(body, element{"type": "TextBlock", "text": title, "weight": "bolder", "size": "medium", "wrap": true})
+	}
+	if txt := strings.TrimSpace(a.Pretext + "\n" + a.Text); txt != "" {
+		body = append(body, textBlock(txt))
+	}
+	if len(a.Fields) > 0 {
+		var fs []hugot.Field
+		for _, f := range a.Fields {
+			fs = append(fs, hugot.Field{Title: f.Title, Value: f.Value})
+		}
+		body = append(body, factSet(fs))
+	}
+	if a.ImageURL != "" {
+		body = append(body, element{"type": "Image", "url": a.ImageURL})
+	}
+	if a.Footer != "" {
+		body = append(body, element{"type": "TextBlock", "text": a.Footer, "size": "small", "isSubtle": true, "wrap": true})
+	}
+
+	if s, ok := containerStyles[a.Color]; ok {
+		body = []element{{"type": "Container", "style": s, "items": body}}
+	}
+
+	return newCard(body)
+}
+
+// blocksCard renders blocks as an Adaptive Card. Action buttons submit
+// the button's ID and value as action_id and value.
+func blocksCard(bs []hugot.Block) card {
+	var body []element
+	separate := false
+	add := func(e element) {
+		if separate {
+			e["separator"] = true
+			separate = false
+		}
+		body = append(body, e)
+	}
+
+	for _, b := range bs {
+		switch b := b.(type) {
+		case hugot.Header:
+			add(element{"type": "TextBlock", "text": b.Text, "weight": "bolder", "size": "large", "wrap": true})
+		case hugot.Section:
+			if b.Text != "" {
+				add(textBlock(b.Text))
+			}
+			if len(b.Fields) > 0 {
+				add(factSet(b.Fields))
+			}
+		case hugot.Image:
+			add(element{"type": "Image", "url": b.URL, "altText": b.AltText})
+		case hugot.Code:
+			add(element{"type": "TextBlock", "text": b.Text, "fontType": "monospace", "wrap": true})
+		case hugot.Actions:
+			var as []element
+			for _, bt := range b.Buttons {
+				as = append(as, action(bt))
+			}
+			add(element{"type": "ActionSet", "actions": as})
+		case hugot.Divider:
+			separate = true
+		}
+	}
+
+	return newCard(body)
+}
+
+func action(bt hugot.Button) element {
+	if bt.URL != "" {
+		return element{"type": "Action.OpenUrl", "title": bt.Text, "url": bt.URL}
+	}
+
+	a := element{
+		"type":  "Action.Submit",
+		"title": bt.Text,
+		"data":  map[string]string{"action_id": bt.ID, "value": bt.Value},
+	}
+	switch bt.Style {
+	case hugot.ButtonPrimary:
+		a["style"] = "positive"
+	case hugot.ButtonDanger:
+		a["style"] = "destructive"
+	}
+	return a
+}
@@ -0,0 +1,373 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package teams implements an adapter for Microsoft Teams, and other
+// Bot Framework channels. Activities are pushed to the bot over HTTP, and
+// replies are sent with the Bot Connector REST API.
+package teams
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
+)
+
+// maxActivitySize limits the size of activities we will read.
+const maxActivitySize = 1 << 20
+
+// DefaultServiceHosts are the hosts of the Bot Framework's public connector
+// services. A leading "*." matches any subdomain.
+var DefaultServiceHosts = []string{"smba.trafficmanager.net", "*.botframework.com"}
+
+// Teams is an adapter that receives activities from the Bot Framework.
+// It is a hugot.WebHookHandler, and must be added to a Mux with
+// HandleHTTP. The handler's URL should then be set as the messaging
+// endpoint of the bot's registration.
+type Teams struct {
+	hugot.WebHookHandler
+
+	nick   string
+	dirPat *regexp.Regexp
+
+	client *http.Client
+	verify *verifier
+	tokens *tokenSource
+
+	serviceHosts []string
+
+	convsLock sync.RWMutex
+	services  map[string]string // conversation ID to service URL
+
+	msgs chan *hugot.Message
+
+	log hugot.Logger
+}
+
+// Opt functions are used to set options on the teams adapter.
+type Opt func(*Teams)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(t *Teams) {
+		t.log = l
+	}
+}
+
+// WithOpenIDURL sets the location of the OpenID metadata used to verify
+// incoming requests, this is mostly useful for testing.
+func WithOpenIDURL(u string) Opt {
+	return func(t *Teams) {
+		t.verify.openIDURL = u
+	}
+}
+
+// WithTokenURL sets the URL used to get tokens for the connector API,
+// this is mostly useful for testing.
+func WithTokenURL(u string) Opt {
+	return func(t *Teams) {
+		t.tokens.tokenURL = u
+	}
+}
+
+// WithServiceHosts sets the hosts that activities may give as their
+// service URL, replies, and the bot's credentials, are sent there. The
+// default is DefaultServiceHosts.
+func WithServiceHosts(hosts ...string) Opt {
+	return func(t *Teams) {
+		t.serviceHosts = hosts
+	}
+}
+
+// New creates a new Bot Framework adapter. appID and appPassword are the
+// credentials of the bot's registration, and nick is the name users
+// @mention the bot with.
+func New(appID, appPassword, nick string, opts ...Opt) (*Teams, error) {
+	if appID == "" || appPassword == "" {
+		return nil, errors.New("Bot Framework app ID and password must be set")
+	}
+
+	t := &Teams{
+		nick:   nick,
+		client: http.DefaultClient,
+		verify: &verifier{
+			appID:     appID,
+			openIDURL: DefaultOpenIDURL,
+			client:    http.DefaultClient,
+		},
+		tokens: &tokenSource{
+			appID:       appID,
+			appPassword: appPassword,
+			tokenURL:    DefaultTokenURL,
+			client:      http.DefaultClient,
+		},
+		serviceHosts: DefaultServiceHosts,
+		services:     map[string]string{},
+		msgs:         make(chan *hugot.Message),
+		log:          hugot.DefaultLogger,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.log = t.log.With(hugot.LogAdapter, "teams")
+
+	t.dirPat = regexp.MustCompile(fmt.Sprintf("(?m)^(!|(@?%s|<at>%s</at>)[:,]? )(.*)", regexp.QuoteMeta(nick), regexp.QuoteMeta(nick)))
+	t.WebHookHandler = hugot.NewWebHookHandler("teams", "receives activities from the bot framework", t.serveHTTP)
+
+	return t, nil
+}
+
+// Receive implements hugot.Receiver
+func (t *Teams) Receive() <-chan *hugot.Message {
+	return t.msgs
+}
+
+// Send implements hugot.Sender. Messages can only be sent to conversations
+// the bot has received an activity from. If the message has a ThreadID, it
+// is sent as a reply to that activity.
+func (t *Teams) Send(ctx context.Context, m *hugot.Message) string {
+	t.convsLock.RLock()
+	svc, ok := t.services[m.Channel]
+	t.convsLock.RUnlock()
+	if !ok {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", t)).Inc()
+		t.log.Error("message to unknown conversation", hugot.LogChannel, m.Channel)
+		return ""
+	}
+
+	a := activity{
+		Type:       "message",
+		Text:       m.Text,
+		TextFormat: "markdown",
+		ReplyToID:  m.ThreadID,
+	}
+	for _, att := range m.Attachments {
+		a.Attachments = append(a.Attachments, cardAttachment(attachmentCard(att)))
+	}
+	if len(m.Blocks) > 0 {
+		a.Attachments = append(a.Attachments, cardAttachment(blocksCard(m.Blocks)))
+	}
+
+	u := fmt.Sprintf("%s/v3/conversations/%s/activities", strings.TrimSuffix(svc, "/"), url.PathEscape(m.Channel))
+	if m.ThreadID != "" {
+		u += "/" + url.PathEscape(m.ThreadID)
+	}
+
+	var res struct {
+		ID string `json:"id"`
+	}
+	if err := t.post(ctx, u, a, &res); err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", t)).Inc()
+		t.log.Error("error sending message", hugot.LogChannel, m.Channel, "error", err)
+		return ""
+	}
+
+	return res.ID
+}
+
+// post sends in to the connector API, decoding the response into out.
+func (t *Teams) post(ctx context.Context, u string, in, out interface{}) error {
+	tok, err := t.tokens.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("POST %s failed, %s: %s", u, resp.Status, body)
+	}
+
+	// Some channels do not return the ID of the new activity.
+	json.NewDecoder(resp.Body).Decode(out)
+	return nil
+}
+
+func (t *Teams) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := t.verify.verify(r.Context(), r.Header.Get("Authorization"))
+	if err != nil {
+		t.log.Info("rejected request", "error", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var a activity
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxActivitySize)).Decode(&a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if a.From == nil || a.Conversation == nil {
+		http.Error(w, "activity has no sender or conversation", http.StatusBadRequest)
+		return
+	}
+
+	if a.ServiceURL != "" {
+		// The token is only proof that the bot framework sent the
+		// activity if it was issued for the same service.
+		if a.ServiceURL != c.ServiceURL || !t.allowedService(a.ServiceURL) {
+			t.log.Info("rejected request", "error", "untrusted service URL", "url", a.ServiceURL)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		t.convsLock.Lock()
+		t.services[a.Conversation.ID] = a.ServiceURL
+		t.convsLock.Unlock()
+	}
+
+	m := t.toHugot(&a)
+	if m == nil {
+		return
+	}
+
+	select {
+	case t.msgs <- m:
+	case <-r.Context().Done():
+		http.Error(w, r.Context().Err().Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// allowedService returns true if the host of the service URL u is one of
+// the serviceHosts.
+func (t *Teams) allowedService(u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	h := pu.Hostname()
+	for _, sh := range t.serviceHosts {
+		if sh == h || (strings.HasPrefix(sh, "*.") && strings.HasSuffix(h, sh[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// tags matches the HTML tags teams includes in message text.
+var tags = regexp.MustCompile(`<[^>]*>`)
+
+// toHugot converts a message activity to a hugot.Message. Other activity
+// types, including edits, are ignored. Messages that @mention the bot are
+// sent to it, wherever the mention is, and the mention is removed from
+// the text. Replies are given the ID of the activity they reply to as
+// their ThreadID.
+func (t *Teams) toHugot(a *activity) *hugot.Message {
+	if a.Type != "message" || (a.Recipient != nil && a.From.ID == a.Recipient.ID) {
+		return nil
+	}
+	t.log.Debug("received message", hugot.LogChannel, a.Conversation.ID, hugot.LogUser, a.From.Name, "text", a.Text)
+
+	private := a.Conversation.ConversationType == "personal"
+	tobot := private
+	txt := a.Text
+
+	if a.Recipient != nil {
+		for _, e := range a.Entities {
+			if e.Type != "mention" || e.Mentioned == nil || e.Mentioned.ID != a.Recipient.ID {
+				continue
+			}
+			tobot = true
+			txt = strings.Replace(txt, e.Text, "", 1)
+		}
+	}
+	txt = strings.TrimSpace(txt)
+
+	// Check if the message was sent @bot, if so, set it as to us
+	// and strip the leading politeness
+	dirMatch := t.dirPat.FindStringSubmatch(txt)
+	if len(dirMatch) > 1 && len(dirMatch[1]) > 0 {
+		tobot = true
+		txt = strings.Trim(dirMatch[3], " ")
+	}
+	txt = html.UnescapeString(tags.ReplaceAllString(txt, ""))
+
+	return &hugot.Message{
+		Channel:  a.Conversation.ID,
+		From:     a.From.Name,
+		UserID:   a.From.ID,
+		ID:       a.ID,
+		ThreadID: a.ReplyToID,
+		Text:     txt,
+		Private:  private,
+		ToBot:    tobot,
+	}
+}
+
+type account struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type conversation struct {
+	ID               string `json:"id"`
+	ConversationType string `json:"conversationType,omitempty"`
+}
+
+type activity struct {
+	Type         string        `json:"type"`
+	ID           string        `json:"id,omitempty"`
+	ServiceURL   string        `json:"serviceUrl,omitempty"`
+	From         *account      `json:"from,omitempty"`
+	Recipient    *account      `json:"recipient,omitempty"`
+	Conversation *conversation `json:"conversation,omitempty"`
+	Text         string        `json:"text,omitempty"`
+	TextFormat   string        `json:"textFormat,omitempty"`
+	ReplyToID    string        `json:"replyToId,omitempty"`
+	Attachments  []attachment  `json:"attachments,omitempty"`
+	Entities     []entity      `json:"entities,omitempty"`
+}
+
+// entity carries extra information about an activity, we only use
+// mentions.
+type entity struct {
+	Type      string   `json:"type"`
+	Mentioned *account `json:"mentioned,omitempty"`
+	Text      string   `json:"text,omitempty"`
+}
+
+type attachment struct {
+	ContentType string      `json:"contentType"`
+	Content     interface{} `json:"content"`
+}
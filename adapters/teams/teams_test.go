@@ -0,0 +1,318 @@
+package teams
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tcolgate/hugot"
+)
+
+// testSigner returns a fake OpenID metadata URL, serving the key that
+// the returned function signs tokens with.
+func testSigner(t *testing.T) (string, func(claims map[string]interface{}) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key, %v", err)
+	}
+
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openid", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"jwks_uri":"%s/keys"}`, srv.URL)
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"keys":[{"kty":"RSA","kid":"k1","n":"%s","e":"%s"}]}`,
+			base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	sign := func(claims map[string]interface{}) string {
+		enc := func(v interface{}) string {
+			bs, _ := json.Marshal(v)
+			return base64.RawURLEncoding.EncodeToString(bs)
+		}
+		s := enc(map[string]string{"alg": "RS256", "kid": "k1"}) + "." + enc(claims)
+		sum := sha256.Sum256([]byte(s))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		return s + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	return srv.URL + "/openid", sign
+}
+
+func TestToHugot(t *testing.T) {
+	tm, err := New("app", "pass", "hugot")
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	const bot = `"recipient":{"id":"28:hugot","name":"hugot"}`
+	var tests = []struct {
+		name     string
+		activity string
+		exp      *hugot.Message // nil if the activity should be ignored
+	}{
+		{"mention",
+			`{"type":"message","id":"a1",` + bot + `,"conversation":{"id":"c1","conversationType":"channel"},"text":"<at>hugot</at> ping",
+				"entities":[{"type":"mention","mentioned":{"id":"28:hugot","name":"hugot"},"text":"<at>hugot</at>"}]}`,
+			&hugot.Message{Channel: "c1", Text: "ping", ToBot: true}},
+		{"mention by a display name, after the text",
+			`{"type":"message","id":"a1",` + bot + `,"conversation":{"id":"c1","conversationType":"channel"},"text":"ping <at>Hugot Bot</at>\n",
+				"entities":[{"type":"mention","mentioned":{"id":"28:hugot","name":"Hugot Bot"},"text":"<at>Hugot Bot</at>"}]}`,
+			&hugot.Message{Channel: "c1", Text: "ping", ToBot: true}},
+		{"mention of another user",
+			`{"type":"message","id":"a1",` + bot + `,"conversation":{"id":"c1","conversationType":"channel"},"text":"<at>alice</at> ping",
+				"entities":[{"type":"mention","mentioned":{"id":"29:alice","name":"alice"},"text":"<at>alice</at>"}]}`,
+			&hugot.Message{Channel: "c1", Text: "alice ping"}},
+		{"mention without entities",
+			`{"type":"message","id":"a1",` + bot + `,"conversation":{"id":"c1","conversationType":"channel"},"text":"<at>hugot</at> ping"}`,
+			&hugot.Message{Channel: "c1", Text: "ping", ToBot: true}},
+		{"html",
+			`{"type":"message","id":"a1",` + bot + `,"conversation":{"id":"c1","conversationType":"channel"},"text":"<p>hello &amp; welcome</p>"}`,
+			&hugot.Message{Channel: "c1", Text: "hello & welcome"}},
+		{"personal chat",
+			`{"type":"message","id":"a1",` + bot + `,"conversation":{"id":"p1","conversationType":"personal"},"text":"status"}`,
+			&hugot.Message{Channel: "p1", Text: "status", ToBot: true, Private: true}},
+		{"reply in a thread",
+			`{"type":"message","id":"a2","replyToId":"a1",` + bot + `,"conversation":{"id":"c1;messageid=a1","conversationType":"channel"},"text":"<at>hugot</at> again",
+				"entities":[{"type":"mention","mentioned":{"id":"28:hugot"},"text":"<at>hugot</at>"}]}`,
+			&hugot.Message{Channel: "c1;messageid=a1", Text: "again", ToBot: true, ThreadID: "a1"}},
+
+		{"own message",
+			`{"type":"message","id":"a1","from":{"id":"28:hugot","name":"hugot"},` + bot + `,"conversation":{"id":"c1"},"text":"hugot: ping"}`,
+			nil},
+		{"edit",
+			`{"type":"messageUpdate","id":"a1",` + bot + `,"conversation":{"id":"c1"},"text":"hugot: ping"}`,
+			nil},
+		{"members added",
+			`{"type":"conversationUpdate","id":"a1",` + bot + `,"conversation":{"id":"c1"}}`,
+			nil},
+	}
+
+	for _, tt := range tests {
+		var a activity
+		if err := json.Unmarshal([]byte(tt.activity), &a); err != nil {
+			t.Fatalf("%s: bad activity, %v", tt.name, err)
+		}
+		if a.From == nil {
+			a.From = &account{ID: "29:bob", Name: "bob"}
+		}
+
+		m := tm.toHugot(&a)
+		switch {
+		case tt.exp == nil && m != nil:
+			t.Errorf("%s: expected activity to be ignored, got %#v", tt.name, m)
+		case tt.exp == nil:
+		case m == nil:
+			t.Errorf("%s: expected a message", tt.name)
+		case m.Channel != tt.exp.Channel || m.Text != tt.exp.Text || m.ToBot != tt.exp.ToBot || m.Private != tt.exp.Private ||
+			m.ThreadID != tt.exp.ThreadID || m.From != "bob" || m.UserID != "29:bob":
+			t.Errorf("%s: expected %#v, got %#v", tt.name, tt.exp, m)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	openID, sign := testSigner(t)
+
+	tm, err := New("app", "pass", "hugot", WithOpenIDURL(openID), WithServiceHosts("svc1.example.com", "*.example.net"))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	claims := func(aud, svc string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":        "https://api.botframework.com",
+			"aud":        aud,
+			"exp":        time.Now().Add(time.Hour).Unix(),
+			"serviceurl": svc,
+		}
+	}
+	msg := func(svc, typ string) string {
+		return fmt.Sprintf(`{"type":%q,"id":"a1","serviceUrl":%q,
+			"from":{"id":"29:bob","name":"bob"},"recipient":{"id":"28:hugot","name":"hugot"},
+			"conversation":{"id":"c1","conversationType":"channel"},"text":"hi"}`, typ, svc)
+	}
+
+	var tests = []struct {
+		name string
+		tok  string
+		body string
+		code int
+		svc  string // the service replies to c1 should go to
+	}{
+		{"token for another bot", sign(claims("other", "https://svc1.example.com")), msg("https://svc1.example.com", "message"), http.StatusUnauthorized, ""},
+		{"bad token", "not.a.token", msg("https://svc1.example.com", "message"), http.StatusUnauthorized, ""},
+		{"token for another service", sign(claims("app", "https://evil.example.com")), msg("https://svc1.example.com", "message"), http.StatusUnauthorized, ""},
+		{"service on an unknown host", sign(claims("app", "https://evil.example.com")), msg("https://evil.example.com", "message"), http.StatusUnauthorized, ""},
+		{"no sender", sign(claims("app", "https://svc1.example.com")), `{"type":"message","conversation":{"id":"c1"}}`, http.StatusBadRequest, ""},
+
+		// Activities we ignore are still acknowledged, and the service
+		// URL for a conversation can change between activities.
+		{"ignored activity", sign(claims("app", "https://svc1.example.com")), msg("https://svc1.example.com", "typing"), http.StatusOK, "https://svc1.example.com"},
+		{"new service", sign(claims("app", "https://a.example.net")), msg("https://a.example.net", "typing"), http.StatusOK, "https://a.example.net"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		r.Header.Set("Authorization", "Bearer "+tt.tok)
+		w := httptest.NewRecorder()
+		tm.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d %q", tt.name, tt.code, w.Code, w.Body.String())
+		}
+		if svc := tm.services["c1"]; svc != tt.svc {
+			t.Errorf("%s: expected replies to go to %q, got %q", tt.name, tt.svc, svc)
+		}
+	}
+
+	done := make(chan *hugot.Message, 1)
+	go func() { done <- <-tm.Receive() }()
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(msg("https://a.example.net", "message")))
+	r.Header.Set("Authorization", "Bearer "+sign(claims("app", "https://a.example.net")))
+	w := httptest.NewRecorder()
+	tm.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected activity to be accepted, got %d %q", w.Code, w.Body.String())
+	}
+	select {
+	case m := <-done:
+		if m.Text != "hi" || m.Channel != "c1" || m.ID != "a1" {
+			t.Fatalf("unexpected message %#v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+
+	for _, u := range []string{"https://svc1.example.com/emea/", "https://x.example.net"} {
+		if !tm.allowedService(u) {
+			t.Errorf("expected %s to be allowed", u)
+		}
+	}
+	for _, u := range []string{"https://evilexample.net", "https://example.net.evil.com", "://"} {
+		if tm.allowedService(u) {
+			t.Errorf("expected %s to be rejected", u)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	type sent struct {
+		path string
+		auth string
+		a    activity
+	}
+	sends := make(chan sent, 10)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("client_id") != "app" || r.PostForm.Get("client_secret") != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error_description":"bad credentials"}`)
+			return
+		}
+		io.WriteString(w, `{"access_token":"outbound","expires_in":3600}`)
+	})
+	mux.HandleFunc("/v3/conversations/", func(w http.ResponseWriter, r *http.Request) {
+		var a activity
+		json.NewDecoder(r.Body).Decode(&a)
+		sends <- sent{r.URL.EscapedPath(), r.Header.Get("Authorization"), a}
+		io.WriteString(w, `{"id":"reply1"}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tm, err := New("app", "pass", "hugot", WithTokenURL(srv.URL+"/token"))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+	tm.services["c1"] = srv.URL
+	tm.services["c1;messageid=a1"] = srv.URL
+
+	var tests = []struct {
+		m    hugot.Message
+		path string
+	}{
+		{hugot.Message{Channel: "c1", Text: "pong", ThreadID: "a1",
+			Blocks: []hugot.Block{hugot.Actions{Buttons: []hugot.Button{{ID: "approve", Text: "Approve", Value: "v1"}}}}},
+			"/v3/conversations/c1/activities/a1"},
+		{hugot.Message{Channel: "c1;messageid=a1", Text: "pong"},
+			"/v3/conversations/c1%3Bmessageid=a1/activities"},
+	}
+	for _, tt := range tests {
+		if id := tm.Send(context.Background(), &tt.m); id != "reply1" {
+			t.Fatalf("expected ID reply1, got %q", id)
+		}
+		s := <-sends
+		if s.path != tt.path || s.auth != "Bearer outbound" {
+			t.Fatalf("expected request to %s, got %s, with auth %q", tt.path, s.path, s.auth)
+		}
+		if s.a.Text != "pong" || s.a.ReplyToID != tt.m.ThreadID {
+			t.Fatalf("unexpected activity %#v", s.a)
+		}
+		if len(tt.m.Blocks) == 0 {
+			continue
+		}
+		if len(s.a.Attachments) != 1 || s.a.Attachments[0].ContentType != adaptiveCardType {
+			t.Fatalf("unexpected attachments %#v", s.a.Attachments)
+		}
+		bs, _ := json.Marshal(s.a.Attachments[0].Content)
+		if !strings.Contains(string(bs), `"action_id":"approve"`) || !strings.Contains(string(bs), `"Action.Submit"`) {
+			t.Fatalf("unexpected card %s", bs)
+		}
+	}
+
+	if id := tm.Send(context.Background(), &hugot.Message{Channel: "unknown", Text: "hi"}); id != "" {
+		t.Fatalf("expected send to unknown conversation to fail")
+	}
+}
+
+func TestVerifier_UnknownKey(t *testing.T) {
+	fetches := 0
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprintf(w, `{"jwks_uri":"%s/keys","keys":[]}`, srv.URL)
+	}))
+	defer srv.Close()
+
+	v := &verifier{appID: "app", openIDURL: srv.URL, client: srv.Client()}
+
+	enc := base64.RawURLEncoding.EncodeToString
+	tok := "Bearer " + enc([]byte(`{"alg":"RS256","kid":"nosuch"}`)) + "." + enc([]byte(`{}`)) + "." + enc([]byte("sig"))
+	for i := 0; i < 3; i++ {
+		if _, err := v.verify(context.Background(), tok); err == nil {
+			t.Fatalf("expected token with unknown key to be rejected")
+		}
+	}
+
+	// the metadata, and the keys
+	if fetches != 2 {
+		t.Fatalf("expected keys to be fetched once, got %d requests", fetches)
+	}
+}
+
+func TestAttachmentCard(t *testing.T) {
+	c := attachmentCard(hugot.Attachment{Title: "v1.2.3", Text: "deployed", Color: "danger"})
+	bs, _ := json.Marshal(c)
+	if !strings.Contains(string(bs), `"style":"attention"`) || !strings.Contains(string(bs), `"text":"deployed"`) {
+		t.Fatalf("unexpected card %s", bs)
+	}
+}
@@ -0,0 +1,82 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/tcolgate/hugot"
+)
+
+type inlineKeyboardButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+type inlineKeyboardMarkup struct {
+	InlineKeyboard [][]inlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// formatHTML renders the text and blocks of m using the subset of HTML
+// supported by telegram. Action buttons are returned as an inline
+// keyboard, with one row per set of actions. Buttons send callback data
+// of the form "ID:Value", which telegram limits to 64 bytes.
+func formatHTML(m *hugot.Message) (string, *inlineKeyboardMarkup) {
+	var out []string
+	if m.Text != "" {
+		out = append(out, html.EscapeString(m.Text))
+	}
+
+	var kb *inlineKeyboardMarkup
+	for _, b := range m.Blocks {
+		switch b := b.(type) {
+		case hugot.Header:
+			out = append(out, "<b>"+html.EscapeString(b.Text)+"</b>")
+		case hugot.Section:
+			if b.Text != "" {
+				out = append(out, html.EscapeString(b.Text))
+			}
+			for _, f := range b.Fields {
+				out = append(out, fmt.Sprintf("<b>%s</b>: %s", html.EscapeString(f.Title), html.EscapeString(f.Value)))
+			}
+		case hugot.Image:
+			// Images cannot be included in text messages, so we link to
+			// the image instead.
+			txt := b.Title
+			if txt == "" {
+				txt = b.AltText
+			}
+			if txt == "" {
+				txt = b.URL
+			}
+			out = append(out, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(b.URL), html.EscapeString(txt)))
+		case hugot.Code:
+			class := ""
+			if b.Language != "" {
+				class = fmt.Sprintf(` class="language-%s"`, html.EscapeString(b.Language))
+			}
+			out = append(out, fmt.Sprintf("<pre><code%s>%s</code></pre>", class, html.EscapeString(b.Text)))
+		case hugot.Actions:
+			var row []inlineKeyboardButton
+			for _, bt := range b.Buttons {
+				if bt.URL != "" {
+					row = append(row, inlineKeyboardButton{Text: bt.Text, URL: bt.URL})
+					continue
+				}
+				row = append(row, inlineKeyboardButton{Text: bt.Text, CallbackData: bt.ID + ":" + bt.Value})
+			}
+			if len(row) == 0 {
+				continue
+			}
+			if kb == nil {
+				kb = &inlineKeyboardMarkup{}
+			}
+			kb.InlineKeyboard = append(kb.InlineKeyboard, row)
+		case hugot.Divider:
+			out = append(out, "──────────")
+		}
+	}
+
+	return strings.Join(out, "\n"), kb
+}
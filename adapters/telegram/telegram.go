@@ -0,0 +1,348 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package telegram implements adapters for https://telegram.org, using
+// the Bot API. Updates can be received by long polling, or by webhook.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
+)
+
+// DefaultAPIURL is the base URL of the Telegram Bot API.
+const DefaultAPIURL = "https://api.telegram.org"
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+
+	// pollTimeout is how long telegram may hold a getUpdates request
+	// open waiting for new updates.
+	pollTimeout = 30
+)
+
+type telegram struct {
+	token  string
+	apiURL string
+	client *http.Client
+
+	id       int64
+	username string
+
+	dirPat *regexp.Regexp
+
+	msgs chan *hugot.Message
+
+	log hugot.Logger
+}
+
+// Opt functions are used to set options on the telegram adapters.
+type Opt func(*telegram)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(t *telegram) {
+		t.log = l
+	}
+}
+
+// WithAPIURL sets the base URL of the Bot API, this is mostly useful for
+// testing, or for running a local Bot API server.
+func WithAPIURL(u string) Opt {
+	return func(t *telegram) {
+		t.apiURL = strings.TrimSuffix(u, "/")
+	}
+}
+
+func newTelegram(token string, opts []Opt) (*telegram, error) {
+	if token == "" {
+		return nil, errors.New("Telegram bot token must be set")
+	}
+
+	t := &telegram{
+		token:  token,
+		apiURL: DefaultAPIURL,
+		client: http.DefaultClient,
+		msgs:   make(chan *hugot.Message),
+		log:    hugot.DefaultLogger,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.log = t.log.With(hugot.LogAdapter, "telegram")
+
+	var me user
+	if err := t.call(context.Background(), "getMe", nil, &me); err != nil {
+		return nil, fmt.Errorf("could not identify bot user, %w", err)
+	}
+	t.id = me.ID
+	t.username = me.Username
+
+	t.dirPat = dirPattern(t.username)
+
+	return t, nil
+}
+
+// cmdPat matches bot commands, such as /deploy@bot prod.
+var cmdPat = regexp.MustCompile(`^/([^\s@]+)(@(\S+))?(\s+(?s:(.*)))?$`)
+
+// dirPattern matches messages addressed to the bot. Usernames are not
+// case sensitive.
+func dirPattern(username string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf("(?m)^(!|(?i:(@?%s))[:,]? )(.*)", regexp.QuoteMeta(username)))
+}
+
+type polling struct {
+	*telegram
+
+	offset int64
+	start  sync.Once
+}
+
+// New creates a new adapter that receives updates by long polling the
+// Bot API with getUpdates. This cannot be used while the bot has a
+// webhook set.
+func New(token string, opts ...Opt) (hugot.Adapter, error) {
+	t, err := newTelegram(token, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &polling{telegram: t}, nil
+}
+
+// Receive implements hugot.Receiver
+func (p *polling) Receive() <-chan *hugot.Message {
+	p.start.Do(func() {
+		go p.run()
+	})
+	return p.msgs
+}
+
+// run polls for updates, backing off if requests fail.
+func (p *polling) run() {
+	ctx := context.Background()
+	backoff := minBackoff
+	for {
+		if err := p.poll(ctx); err != nil {
+			p.log.Error("polling for updates failed", "error", err, "retry", backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+	}
+}
+
+func (p *polling) poll(ctx context.Context) error {
+	req := map[string]interface{}{
+		"offset":          p.offset,
+		"timeout":         pollTimeout,
+		"allowed_updates": []string{"message"},
+	}
+	var ups []update
+	if err := p.call(ctx, "getUpdates", req, &ups); err != nil {
+		return err
+	}
+
+	for _, up := range ups {
+		p.offset = up.UpdateID + 1
+		if m := p.toHugot(&up); m != nil {
+			p.msgs <- m
+		}
+	}
+	return nil
+}
+
+// Send implements hugot.Sender, returning the ID of the sent message.
+// Channels are chat IDs, or @channelusername. If the message has a
+// ThreadID, it is sent as a reply to that message, which also places it
+// in the correct topic of forum chats.
+func (t *telegram) Send(ctx context.Context, m *hugot.Message) string {
+	if m.Channel == "" {
+		t.log.Error("cannot send message without a chat", hugot.LogUser, m.To)
+		return ""
+	}
+
+	req := sendMessage{
+		ChatID: m.Channel,
+		Text:   m.Text,
+	}
+	if len(m.Blocks) > 0 {
+		req.Text, req.ReplyMarkup = formatHTML(m)
+		req.ParseMode = "HTML"
+	}
+	if m.ThreadID != "" {
+		id, err := strconv.ParseInt(m.ThreadID, 10, 64)
+		if err == nil {
+			req.ReplyToMessageID = id
+			req.AllowSendingWithoutReply = true
+		}
+	}
+
+	var res message
+	if err := t.call(ctx, "sendMessage", req, &res); err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", t)).Inc()
+		t.log.Error("error sending message", hugot.LogChannel, m.Channel, "error", err)
+		return ""
+	}
+
+	return strconv.FormatInt(res.MessageID, 10)
+}
+
+// toHugot converts an update to a hugot.Message. Updates that are not new
+// text messages are ignored. Commands, such as /deploy@bot prod, are
+// converted to the text "deploy prod", and sent to the bot, unless they
+// are addressed to another bot.
+func (t *telegram) toHugot(up *update) *hugot.Message {
+	tm := up.Message
+	if tm == nil || tm.From == nil || tm.Text == "" || tm.From.ID == t.id {
+		return nil
+	}
+	t.log.Debug("received message", hugot.LogChannel, tm.Chat.ID, hugot.LogUser, tm.From.Username, "text", tm.Text)
+
+	private := tm.Chat.Type == "private"
+	tobot := private
+	txt := tm.Text
+
+	if cm := cmdPat.FindStringSubmatch(txt); cm != nil {
+		if cm[3] != "" && !strings.EqualFold(cm[3], t.username) {
+			// A command for another bot
+			return nil
+		}
+		tobot = true
+		txt = strings.TrimSpace(cm[1] + " " + cm[5])
+	} else if dirMatch := t.dirPat.FindStringSubmatch(txt); len(dirMatch) > 1 && len(dirMatch[1]) > 0 {
+		// Check if the message was sent @bot, if so, set it as to us
+		// and strip the leading politeness
+		tobot = true
+		txt = strings.Trim(dirMatch[3], " ")
+	}
+
+	from := tm.From.Username
+	if from == "" {
+		from = tm.From.FirstName
+	}
+
+	m := &hugot.Message{
+		Channel: strconv.FormatInt(tm.Chat.ID, 10),
+		From:    from,
+		UserID:  strconv.FormatInt(tm.From.ID, 10),
+		ID:      strconv.FormatInt(tm.MessageID, 10),
+		Text:    txt,
+		Private: private,
+		ToBot:   tobot,
+	}
+	if tm.IsTopicMessage {
+		m.ThreadID = strconv.FormatInt(tm.MessageThreadID, 10)
+	}
+
+	return m
+}
+
+// call calls a Bot API method, decoding the result into out.
+func (t *telegram) call(ctx context.Context, method string, in, out interface{}) error {
+	if in == nil {
+		in = struct{}{}
+	}
+	bs, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/bot%s/%s", t.apiURL, t.token, method)
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// The error includes the URL, and so our token.
+		return fmt.Errorf("%s failed, %w", method, errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	if !res.OK {
+		return fmt.Errorf("%s failed, %s", method, res.Description)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Result, out)
+}
+
+type update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *message `json:"message"`
+}
+
+type user struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	Username  string `json:"username"`
+}
+
+type chat struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+}
+
+type message struct {
+	MessageID       int64  `json:"message_id"`
+	MessageThreadID int64  `json:"message_thread_id"`
+	IsTopicMessage  bool   `json:"is_topic_message"`
+	From            *user  `json:"from"`
+	Chat            chat   `json:"chat"`
+	Text            string `json:"text"`
+}
+
+type sendMessage struct {
+	ChatID                   string                `json:"chat_id"`
+	Text                     string                `json:"text"`
+	ParseMode                string                `json:"parse_mode,omitempty"`
+	ReplyToMessageID         int64                 `json:"reply_to_message_id,omitempty"`
+	AllowSendingWithoutReply bool                  `json:"allow_sending_without_reply,omitempty"`
+	ReplyMarkup              *inlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
@@ -0,0 +1,311 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tcolgate/hugot"
+)
+
+type call struct {
+	method string
+	body   map[string]interface{}
+}
+
+// testAPI returns a fake Bot API, which records calls, and responds
+// with the JSON returned by f.
+func testAPI(t *testing.T, f func(c call) string) (*httptest.Server, chan call) {
+	calls := make(chan call, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := call{method: strings.TrimPrefix(r.URL.Path, "/bottoken/")}
+		json.NewDecoder(r.Body).Decode(&c.body)
+		calls <- c
+		io.WriteString(w, f(c))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+// testTelegram returns an adapter for the bot hugot_bot, with ID 1,
+// using the Bot API at apiURL.
+func testTelegram(apiURL string) *telegram {
+	return &telegram{
+		token:    "token",
+		apiURL:   apiURL,
+		client:   http.DefaultClient,
+		id:       1,
+		username: "hugot_bot",
+		dirPat:   dirPattern("hugot_bot"),
+		msgs:     make(chan *hugot.Message, 10),
+		log:      hugot.DefaultLogger,
+	}
+}
+
+func testUpdate(id int, chatType, username, text string) string {
+	return fmt.Sprintf(`{"update_id":%d,"message":{"message_id":%d,
+		"from":{"id":7,"first_name":"Bob","username":%q},
+		"chat":{"id":-100,"type":%q},"text":%q}}`, id, id, username, chatType, text)
+}
+
+func TestToHugot(t *testing.T) {
+	var tests = []struct {
+		chatType, text string
+		ignored        bool
+		expText        string
+		tobot, private bool
+	}{
+		{"group", "hello", false, "hello", false, false},
+		{"group", "/deploy@hugot_bot prod now", false, "deploy prod now", true, false},
+		{"group", "/deploy@Hugot_Bot", false, "deploy", true, false},
+		{"group", "/deploy@other_bot prod", true, "", false, false},
+		{"supergroup", "/ping", false, "ping", true, false},
+		{"group", "@hugot_bot ping", false, "ping", true, false},
+		{"group", "@Hugot_Bot ping", false, "ping", true, false},
+		{"group", "hugot_bot: ping", false, "ping", true, false},
+		{"group", "@hugot_botx ping", false, "@hugot_botx ping", false, false},
+		{"group", "ask @hugot_bot ping", false, "ask @hugot_bot ping", false, false},
+		{"private", "ping", false, "ping", true, true},
+		{"private", "/start", false, "start", true, true},
+		{"channel", "ping", false, "ping", false, false},
+	}
+
+	tg := testTelegram("")
+	for _, tt := range tests {
+		var up update
+		if err := json.Unmarshal([]byte(testUpdate(5, tt.chatType, "bob", tt.text)), &up); err != nil {
+			t.Fatalf("bad update, %v", err)
+		}
+
+		m := tg.toHugot(&up)
+		if tt.ignored {
+			if m != nil {
+				t.Errorf("expected %q to be ignored, got %#v", tt.text, m)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("expected message for %q", tt.text)
+			continue
+		}
+		if m.Text != tt.expText || m.ToBot != tt.tobot || m.Private != tt.private || m.ThreadID != "" ||
+			m.Channel != "-100" || m.From != "bob" || m.UserID != "7" || m.ID != "5" {
+			t.Errorf("unexpected message for %q, %#v", tt.text, m)
+		}
+	}
+}
+
+func TestToHugot_Updates(t *testing.T) {
+	var tests = []struct {
+		name   string
+		update string
+		exp    *hugot.Message // nil if the update should be ignored
+	}{
+		{"forum topic",
+			`{"update_id":1,"message":{"message_id":12,"message_thread_id":10,"is_topic_message":true,"from":{"id":7,"username":"bob"},"chat":{"id":-100,"type":"supergroup"},"text":"/ping"}}`,
+			&hugot.Message{ID: "12", From: "bob", Text: "ping", ToBot: true, ThreadID: "10"}},
+		{"reply outside a topic",
+			`{"update_id":1,"message":{"message_id":12,"message_thread_id":10,"from":{"id":7,"username":"bob"},"chat":{"id":-100,"type":"supergroup"},"text":"/ping"}}`,
+			&hugot.Message{ID: "12", From: "bob", Text: "ping", ToBot: true}},
+		{"no username",
+			`{"update_id":1,"message":{"message_id":12,"from":{"id":7,"first_name":"Bob"},"chat":{"id":-100,"type":"group"},"text":"/ping"}}`,
+			&hugot.Message{ID: "12", From: "Bob", Text: "ping", ToBot: true}},
+		{"own message",
+			`{"update_id":1,"message":{"message_id":12,"from":{"id":1,"username":"hugot_bot"},"chat":{"id":-100,"type":"group"},"text":"/ping"}}`,
+			nil},
+		{"edit",
+			`{"update_id":1,"edited_message":{"message_id":12,"from":{"id":7,"username":"bob"},"chat":{"id":-100,"type":"group"},"text":"/ping"}}`,
+			nil},
+		{"photo",
+			`{"update_id":1,"message":{"message_id":12,"from":{"id":7,"username":"bob"},"chat":{"id":-100,"type":"group"},"caption":"/ping"}}`,
+			nil},
+		{"channel post without a sender",
+			`{"update_id":1,"message":{"message_id":12,"chat":{"id":-100,"type":"channel"},"text":"/ping"}}`,
+			nil},
+	}
+
+	tg := testTelegram("")
+	for _, tt := range tests {
+		var up update
+		if err := json.Unmarshal([]byte(tt.update), &up); err != nil {
+			t.Fatalf("%s: bad update, %v", tt.name, err)
+		}
+
+		m := tg.toHugot(&up)
+		switch {
+		case tt.exp == nil && m != nil:
+			t.Errorf("%s: expected update to be ignored, got %#v", tt.name, m)
+		case tt.exp == nil:
+		case m == nil:
+			t.Errorf("%s: expected a message", tt.name)
+		case m.ID != tt.exp.ID || m.From != tt.exp.From || m.Text != tt.exp.Text || m.ToBot != tt.exp.ToBot || m.ThreadID != tt.exp.ThreadID:
+			t.Errorf("%s: expected %#v, got %#v", tt.name, tt.exp, m)
+		}
+	}
+}
+
+func TestPoll_Resume(t *testing.T) {
+	resps := []string{
+		fmt.Sprintf(`{"ok":true,"result":[%s,%s]}`, testUpdate(5, "private", "bob", "one"), testUpdate(6, "private", "bob", "two")),
+		`{"ok":false,"description":"Bad Gateway"}`,
+		`{"ok":true,"result":[]}`,
+	}
+	srv, calls := testAPI(t, func(c call) string {
+		resp := resps[0]
+		resps = resps[1:]
+		return resp
+	})
+
+	p := &polling{telegram: testTelegram(srv.URL)}
+	ctx := context.Background()
+
+	if err := p.poll(ctx); err != nil {
+		t.Fatalf("poll failed, %v", err)
+	}
+	if err := p.poll(ctx); err == nil {
+		t.Fatalf("expected poll to fail")
+	}
+	if err := p.poll(ctx); err != nil {
+		t.Fatalf("poll failed, %v", err)
+	}
+
+	// Each poll acknowledges the updates we have seen, and a failed poll
+	// must not lose, or repeat, any.
+	for i, exp := range []float64{0, 7, 7} {
+		c := <-calls
+		if off, _ := c.body["offset"].(float64); c.method != "getUpdates" || off != exp {
+			t.Errorf("%d: expected getUpdates from %v, got %s %v", i, exp, c.method, c.body)
+		}
+	}
+
+	for _, exp := range []string{"one", "two"} {
+		if m := <-p.msgs; m.Text != exp {
+			t.Errorf("expected %q, got %q", exp, m.Text)
+		}
+	}
+	if len(p.msgs) != 0 {
+		t.Errorf("expected no more messages, got %d", len(p.msgs))
+	}
+}
+
+func TestSend(t *testing.T) {
+	srv, calls := testAPI(t, func(c call) string {
+		if c.body["chat_id"] == "missing" {
+			return `{"ok":false,"description":"Bad Request: chat not found"}`
+		}
+		return `{"ok":true,"result":{"message_id":42,"chat":{"id":-100}}}`
+	})
+	tg := testTelegram(srv.URL)
+
+	id := tg.Send(context.Background(), &hugot.Message{
+		Channel:  "-100",
+		Text:     "a < b",
+		ThreadID: "5",
+		Blocks: []hugot.Block{
+			hugot.Code{Language: "go", Text: "x := 1"},
+			hugot.Actions{Buttons: []hugot.Button{
+				{ID: "approve", Text: "Approve", Value: "v1"},
+				{Text: "Docs", URL: "https://example.com"},
+			}},
+		},
+	})
+	if id != "42" {
+		t.Fatalf("expected ID 42, got %q", id)
+	}
+
+	c := <-calls
+	if c.method != "sendMessage" || c.body["chat_id"] != "-100" || c.body["parse_mode"] != "HTML" ||
+		c.body["reply_to_message_id"] != float64(5) || c.body["allow_sending_without_reply"] != true {
+		t.Fatalf("unexpected request %s %v", c.method, c.body)
+	}
+	txt, _ := c.body["text"].(string)
+	if txt != "a &lt; b\n<pre><code class=\"language-go\">x := 1</code></pre>" {
+		t.Fatalf("unexpected text %q", txt)
+	}
+	bs, _ := json.Marshal(c.body["reply_markup"])
+	if string(bs) != `{"inline_keyboard":[[{"callback_data":"approve:v1","text":"Approve"},{"text":"Docs","url":"https://example.com"}]]}` {
+		t.Fatalf("unexpected keyboard %s", bs)
+	}
+
+	// Plain text is sent as is, without a parse mode, so that
+	// characters that look like markup are not lost.
+	tg.Send(context.Background(), &hugot.Message{Channel: "@ops", Text: "1 < 2 & <b>"})
+	c = <-calls
+	if c.body["chat_id"] != "@ops" || c.body["text"] != "1 < 2 & <b>" || c.body["parse_mode"] != nil || c.body["reply_to_message_id"] != nil {
+		t.Fatalf("unexpected request %v", c.body)
+	}
+
+	if id := tg.Send(context.Background(), &hugot.Message{Channel: "missing", Text: "hi"}); id != "" {
+		t.Fatalf("expected send to missing chat to fail")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	srv, calls := testAPI(t, func(c call) string {
+		if c.method == "getMe" {
+			return `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Hugot","username":"hugot_bot"}}`
+		}
+		return `{"ok":true,"result":true}`
+	})
+	wh, err := NewWebhook("token", "s3cret", WithAPIURL(srv.URL))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+	<-calls
+
+	post := func(secret, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set(secretHeader, secret)
+		w := httptest.NewRecorder()
+		wh.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := post("wrong", testUpdate(1, "group", "bob", "/ping")); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected bad secret to be rejected, got %d", w.Code)
+	}
+	if w := post("", testUpdate(1, "group", "bob", "/ping")); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected missing secret to be rejected, got %d", w.Code)
+	}
+
+	// Updates we ignore must still be acknowledged, or telegram will
+	// retry them.
+	if w := post("s3cret", `{"update_id":2,"edited_message":{"message_id":2}}`); w.Code != http.StatusOK {
+		t.Fatalf("expected ignored update to be acknowledged, got %d", w.Code)
+	}
+
+	done := make(chan *hugot.Message, 1)
+	go func() { done <- <-wh.Receive() }()
+	if w := post("s3cret", testUpdate(3, "group", "bob", "/ping@hugot_bot")); w.Code != http.StatusOK {
+		t.Fatalf("expected update to be accepted, got %d", w.Code)
+	}
+	select {
+	case m := <-done:
+		if m.Text != "ping" || !m.ToBot || m.Private {
+			t.Fatalf("unexpected message %#v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+
+	if err := wh.SetWebhook(context.Background()); err == nil {
+		t.Fatalf("expected SetWebhook to fail without a URL")
+	}
+
+	wh.SetURL(&url.URL{Scheme: "https", Host: "bot.example.com", Path: "/telegram"})
+	if err := wh.SetWebhook(context.Background()); err != nil {
+		t.Fatalf("SetWebhook failed, %v", err)
+	}
+	c := <-calls
+	if c.method != "setWebhook" || c.body["secret_token"] != "s3cret" ||
+		c.body["url"] != "https://bot.example.com/telegram" {
+		t.Fatalf("unexpected call %v", c)
+	}
+}
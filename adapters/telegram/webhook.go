@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/tcolgate/hugot"
+)
+
+// maxUpdateSize limits the size of updates we will read.
+const maxUpdateSize = 1 << 20
+
+// secretHeader carries the secret token set when registering the webhook.
+const secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Webhook is an adapter that receives updates pushed by telegram. It is a
+// hugot.WebHookHandler, and must be added to a Mux with HandleHTTP. Once
+// the handler's URL has been set, SetWebhook registers it with telegram.
+type Webhook struct {
+	*telegram
+	hugot.WebHookHandler
+
+	secret string
+}
+
+// NewWebhook creates a new adapter that receives updates by webhook.
+// secret is passed to telegram when the webhook is registered, and is
+// used to verify that requests came from telegram. It may only contain
+// the characters A-Z, a-z, 0-9, _ and -.
+func NewWebhook(token, secret string, opts ...Opt) (*Webhook, error) {
+	if secret == "" {
+		return nil, errors.New("Telegram webhook secret must be set")
+	}
+
+	t, err := newTelegram(token, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Webhook{
+		telegram: t,
+		secret:   secret,
+	}
+	w.WebHookHandler = hugot.NewWebHookHandler("telegram", "receives updates from telegram", w.serveHTTP)
+
+	return w, nil
+}
+
+// Receive implements hugot.Receiver
+func (w *Webhook) Receive() <-chan *hugot.Message {
+	return w.msgs
+}
+
+// SetWebhook registers the handler's URL with telegram. Telegram will
+// only deliver updates to https URLs.
+func (w *Webhook) SetWebhook(ctx context.Context) error {
+	u := w.URL()
+	if u == nil || u.Host == "" {
+		return errors.New("webhook URL has not been set")
+	}
+
+	req := map[string]interface{}{
+		"url":             u.String(),
+		"secret_token":    w.secret,
+		"allowed_updates": []string{"message"},
+	}
+	return w.call(ctx, "setWebhook", req, nil)
+}
+
+func (w *Webhook) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(w.secret)) != 1 {
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var up update
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxUpdateSize)).Decode(&up); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m := w.toHugot(&up)
+	if m == nil {
+		return
+	}
+
+	select {
+	case w.msgs <- m:
+	case <-r.Context().Done():
+		http.Error(rw, r.Context().Err().Error(), http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"github.com/tcolgate/hugot"
+)
+
+// Message is the JSON representation of a hugot.Message, as received and
+// sent by the adapter.
+type Message struct {
+	ID       string `json:"id,omitempty"`
+	ThreadID string `json:"thread_id,omitempty"`
+
+	To      string `json:"to,omitempty"`
+	From    string `json:"from,omitempty"`
+	UserID  string `json:"user_id,omitempty"`
+	Channel string `json:"channel,omitempty"`
+
+	Text   string  `json:"text"`
+	Blocks []Block `json:"blocks,omitempty"`
+
+	Private bool `json:"private,omitempty"`
+	ToBot   bool `json:"to_bot,omitempty"`
+}
+
+// Block types, as used in Block.Type
+const (
+	BlockHeader  = "header"
+	BlockSection = "section"
+	BlockImage   = "image"
+	BlockCode    = "code"
+	BlockActions = "actions"
+	BlockDivider = "divider"
+)
+
+// Block is the JSON representation of a hugot.Block. Type determines
+// which of the other fields are used.
+type Block struct {
+	Type string `json:"type"`
+
+	Text     string   `json:"text,omitempty"`     // header, section, code
+	Fields   []Field  `json:"fields,omitempty"`   // section
+	URL      string   `json:"url,omitempty"`      // image
+	AltText  string   `json:"alt_text,omitempty"` // image
+	Title    string   `json:"title,omitempty"`    // image
+	Language string   `json:"language,omitempty"` // code
+	Buttons  []Button `json:"buttons,omitempty"`  // actions
+}
+
+// Field is the JSON representation of a hugot.Field.
+type Field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// Button is the JSON representation of a hugot.Button.
+type Button struct {
+	ID    string `json:"id,omitempty"`
+	Text  string `json:"text"`
+	Value string `json:"value,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Style string `json:"style,omitempty"`
+}
+
+// FromHugot converts a hugot.Message to its JSON representation.
+func FromHugot(m *hugot.Message) *Message {
+	out := &Message{
+		ID:       m.ID,
+		ThreadID: m.ThreadID,
+		To:       m.To,
+		From:     m.From,
+		UserID:   m.UserID,
+		Channel:  m.Channel,
+		Text:     m.Text,
+		Private:  m.Private,
+		ToBot:    m.ToBot,
+	}
+
+	for _, b := range m.Blocks {
+		switch b := b.(type) {
+		case hugot.Header:
+			out.Blocks = append(out.Blocks, Block{Type: BlockHeader, Text: b.Text})
+		case hugot.Section:
+			var fs []Field
+			for _, f := range b.Fields {
+				fs = append(fs, Field{Title: f.Title, Value: f.Value})
+			}
+			out.Blocks = append(out.Blocks, Block{Type: BlockSection, Text: b.Text, Fields: fs})
+		case hugot.Image:
+			out.Blocks = append(out.Blocks, Block{Type: BlockImage, URL: b.URL, AltText: b.AltText, Title: b.Title})
+		case hugot.Code:
+			out.Blocks = append(out.Blocks, Block{Type: BlockCode, Language: b.Language, Text: b.Text})
+		case hugot.Actions:
+			var bts []Button
+			for _, bt := range b.Buttons {
+				bts = append(bts, Button{ID: bt.ID, Text: bt.Text, Value: bt.Value, URL: bt.URL, Style: string(bt.Style)})
+			}
+			out.Blocks = append(out.Blocks, Block{Type: BlockActions, Buttons: bts})
+		case hugot.Divider:
+			out.Blocks = append(out.Blocks, Block{Type: BlockDivider})
+		}
+	}
+
+	return out
+}
+
+// ToHugot converts the JSON representation of a message to a
+// hugot.Message. Blocks of unknown types are ignored.
+func (m *Message) ToHugot() *hugot.Message {
+	out := &hugot.Message{
+		ID:       m.ID,
+		ThreadID: m.ThreadID,
+		To:       m.To,
+		From:     m.From,
+		UserID:   m.UserID,
+		Channel:  m.Channel,
+		Text:     m.Text,
+		Private:  m.Private,
+		ToBot:    m.ToBot,
+	}
+
+	for _, b := range m.Blocks {
+		switch b.Type {
+		case BlockHeader:
+			out.Blocks = append(out.Blocks, hugot.Header{Text: b.Text})
+		case BlockSection:
+			var fs []hugot.Field
+			for _, f := range b.Fields {
+				fs = append(fs, hugot.Field{Title: f.Title, Value: f.Value})
+			}
+			out.Blocks = append(out.Blocks, hugot.Section{Text: b.Text, Fields: fs})
+		case BlockImage:
+			out.Blocks = append(out.Blocks, hugot.Image{URL: b.URL, AltText: b.AltText, Title: b.Title})
+		case BlockCode:
+			out.Blocks = append(out.Blocks, hugot.Code{Language: b.Language, Text: b.Text})
+		case BlockActions:
+			var bts []hugot.Button
+			for _, bt := range b.Buttons {
+				bts = append(bts, hugot.Button{ID: bt.ID, Text: bt.Text, Value: bt.Value, URL: bt.URL, Style: hugot.ButtonStyle(bt.Style)})
+			}
+			out.Blocks = append(out.Blocks, hugot.Actions{Buttons: bts})
+		case BlockDivider:
+			out.Blocks = append(out.Blocks, hugot.Divider{})
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package webhook implements a generic adapter that exchanges messages
+// as JSON over HTTP. Incoming messages are POSTed to the adapter's
+// webhook, and outgoing messages are POSTed to a configured URL. This can
+// be used to bridge hugot to chat systems that have no adapter of their
+// own, or to drive a bot in end-to-end tests.
+//
+// Messages are JSON encoded Message structs. Requests in both directions
+// are signed with a shared secret: the TimestampHeader holds the time the
+// request was sent, in seconds since the Unix epoch, and the
+// SignatureHeader holds "sha256=" followed by the hex encoded HMAC-SHA256
+// of the timestamp, a ".", and the request body. Requests with timestamps
+// more than MaxAge from the current time are rejected, so that captured
+// requests cannot be replayed later.
+//
+// For example, to send a message to the bot from a shell:
+//
+//	body='{"channel":"ci","from":"builder","text":"deploy prod","to_bot":true}'
+//	ts=$(date +%s)
+//	sig=$(printf '%s.%s' "$ts" "$body" | openssl dgst -sha256 -hmac "$SECRET" | sed 's/^.* //')
+//	curl -H "X-Hugot-Timestamp: $ts" -H "X-Hugot-Signature: sha256=$sig" -d "$body" "$WEBHOOK_URL"
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
+)
+
+const (
+	// SignatureHeader holds the signature of a request.
+	SignatureHeader = "X-Hugot-Signature"
+
+	// TimestampHeader holds the time a request was signed.
+	TimestampHeader = "X-Hugot-Timestamp"
+
+	// MaxAge is how far a request's timestamp may be from the current
+	// time before the request is rejected.
+	MaxAge = 5 * time.Minute
+)
+
+// maxMessageSize limits the size of messages we will read.
+const maxMessageSize = 1 << 20
+
+// Adapter receives messages on its webhook, and sends them to a remote
+// URL. It is a hugot.WebHookHandler, and must be added to a Mux with
+// HandleHTTP.
+type Adapter struct {
+	hugot.WebHookHandler
+
+	secret  []byte
+	sendURL string
+	name    string
+	client  *http.Client
+
+	ids uint64
+
+	msgs chan *hugot.Message
+
+	log hugot.Logger
+}
+
+// Opt functions are used to set options on the webhook adapter.
+type Opt func(*Adapter)
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(a *Adapter) {
+		a.log = l
+	}
+}
+
+// WithHTTPClient sets the client used to send messages.
+func WithHTTPClient(c *http.Client) Opt {
+	return func(a *Adapter) {
+		a.client = c
+	}
+}
+
+// WithName sets the name of the webhook, which determines its URL. This
+// allows several adapters to be added to one Mux. The default name is
+// "webhook".
+func WithName(name string) Opt {
+	return func(a *Adapter) {
+		a.name = name
+	}
+}
+
+// New creates a new webhook adapter. secret is used to verify incoming
+// requests, and to sign outgoing ones. Sent messages are POSTed to
+// sendURL.
+func New(secret, sendURL string, opts ...Opt) (*Adapter, error) {
+	if secret == "" {
+		return nil, errors.New("webhook secret must be set")
+	}
+	if sendURL == "" {
+		return nil, errors.New("webhook send URL must be set")
+	}
+
+	a := &Adapter{
+		secret:  []byte(secret),
+		sendURL: sendURL,
+		name:    "webhook",
+		client:  &http.Client{Timeout: 30 * time.Second},
+		msgs:    make(chan *hugot.Message),
+		log:     hugot.DefaultLogger,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.log = a.log.With(hugot.LogAdapter, a.name)
+
+	a.WebHookHandler = hugot.NewWebHookHandler(a.name, "receives JSON messages", a.serveHTTP)
+
+	return a, nil
+}
+
+// Receive implements hugot.Receiver
+func (a *Adapter) Receive() <-chan *hugot.Message {
+	return a.msgs
+}
+
+// Send implements hugot.Sender. The message is POSTed to the send URL. If
+// the response body is a JSON object with an "id", that is returned as
+// the ID of the sent message.
+func (a *Adapter) Send(ctx context.Context, m *hugot.Message) string {
+	id, err := a.send(ctx, FromHugot(m))
+	if err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", a)).Inc()
+		a.log.Error("error sending message", hugot.LogChannel, m.Channel, "error", err)
+		return ""
+	}
+	return id
+}
+
+func (a *Adapter) send(ctx context.Context, m *Message) (string, error) {
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.sendURL, bytes.NewReader(bs))
+	if err != nil {
+		return "", err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, Sign(a.secret, ts, bs))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("POST %s failed, %s: %s", a.sendURL, resp.Status, body)
+	}
+
+	var res struct {
+		ID string `json:"id"`
+	}
+	// The receiver need not return anything.
+	json.NewDecoder(io.LimitReader(resp.Body, maxMessageSize)).Decode(&res)
+	return res.ID, nil
+}
+
+// serveHTTP receives a message. Messages without an ID are given one, and
+// the ID is returned in the response, as {"id": "..."}. Private messages
+// are always to the bot.
+func (a *Adapter) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !Verify(a.secret, r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader)) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var jm Message
+	if err := json.Unmarshal(body, &jm); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m := jm.ToHugot()
+	if m.ID == "" {
+		m.ID = "webhook" + strconv.FormatUint(atomic.AddUint64(&a.ids, 1), 10)
+	}
+	if m.Private {
+		m.ToBot = true
+	}
+	a.log.Debug("received message", hugot.LogChannel, m.Channel, hugot.LogUser, m.From, "text", m.Text)
+
+	select {
+	case a.msgs <- m:
+	case <-r.Context().Done():
+		http.Error(w, r.Context().Err().Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": m.ID})
+}
+
+// Sign returns the SignatureHeader value for body, sent with the
+// TimestampHeader value ts.
+func Sign(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, ts+".")
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the SignatureHeader value sig against body and the
+// TimestampHeader value ts, and that ts is within MaxAge of now.
+func Verify(secret []byte, ts string, body []byte, sig string) bool {
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := time.Since(time.Unix(secs, 0)); d > MaxAge || d < -MaxAge {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, ts, body)), []byte(sig))
+}
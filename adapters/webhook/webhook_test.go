@@ -0,0 +1,160 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tcolgate/hugot"
+)
+
+func TestReceive(t *testing.T) {
+	a, err := New("s3cret", "http://localhost/unused")
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	post := func(ts, sig, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set(TimestampHeader, ts)
+		r.Header.Set(SignatureHeader, sig)
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		return w
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	body := `{"channel":"ci","from":"builder","user_id":"u1","text":"deploy prod","private":true}`
+	if w := post(now, Sign([]byte("wrong"), now, []byte(body)), body); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected bad signature to be rejected, got %d", w.Code)
+	}
+	if w := post(now, Sign([]byte("s3cret"), now, []byte("{}")), body); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected signature of another body to be rejected, got %d", w.Code)
+	}
+	if w := post(now, Sign([]byte("s3cret"), stale, []byte(body)), body); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected signature with another timestamp to be rejected, got %d", w.Code)
+	}
+	if w := post(stale, Sign([]byte("s3cret"), stale, []byte(body)), body); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected stale request to be rejected, got %d", w.Code)
+	}
+	if w := post("", Sign([]byte("s3cret"), "", []byte(body)), body); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected request without a timestamp to be rejected, got %d", w.Code)
+	}
+
+	done := make(chan *hugot.Message, 1)
+	go func() { done <- <-a.Receive() }()
+
+	w := post(now, Sign([]byte("s3cret"), now, []byte(body)), body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected message to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+
+	var m *hugot.Message
+	select {
+	case m = <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+	if m.Channel != "ci" || m.From != "builder" || m.UserID != "u1" || m.Text != "deploy prod" || !m.Private || !m.ToBot {
+		t.Fatalf("unexpected message %#v", m)
+	}
+
+	var res struct{ ID string }
+	json.NewDecoder(w.Body).Decode(&res)
+	if res.ID == "" || res.ID != m.ID {
+		t.Fatalf("expected response with message ID %q, got %q", m.ID, res.ID)
+	}
+}
+
+func TestSend(t *testing.T) {
+	got := make(chan *Message, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !Verify([]byte("s3cret"), r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		var m Message
+		json.Unmarshal(body, &m)
+		got <- &m
+		if m.Channel == "broken" {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, `{"id":"remote1"}`)
+	}))
+	defer srv.Close()
+
+	a, err := New("s3cret", srv.URL)
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+
+	id := a.Send(context.Background(), &hugot.Message{
+		Channel:  "ci",
+		To:       "builder",
+		ThreadID: "t1",
+		Text:     "deployed",
+		Blocks: []hugot.Block{
+			hugot.Section{Text: "prod", Fields: []hugot.Field{{Title: "version", Value: "v1.2.3"}}},
+			hugot.Actions{Buttons: []hugot.Button{{ID: "rollback", Text: "Rollback", Value: "v1.2.2", Style: hugot.ButtonDanger}}},
+		},
+	})
+	if id != "remote1" {
+		t.Fatalf("expected ID remote1, got %q", id)
+	}
+
+	m := <-got
+	exp := &Message{
+		Channel:  "ci",
+		To:       "builder",
+		ThreadID: "t1",
+		Text:     "deployed",
+		Blocks: []Block{
+			{Type: BlockSection, Text: "prod", Fields: []Field{{Title: "version", Value: "v1.2.3"}}},
+			{Type: BlockActions, Buttons: []Button{{ID: "rollback", Text: "Rollback", Value: "v1.2.2", Style: "danger"}}},
+		},
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Fatalf("expected %#v, got %#v", exp, m)
+	}
+
+	if id := a.Send(context.Background(), &hugot.Message{Channel: "broken", Text: "hi"}); id != "" {
+		t.Fatalf("expected failed send to return no ID, got %q", id)
+	}
+}
+
+func TestBlocksRoundTrip(t *testing.T) {
+	in := &hugot.Message{
+		Text: "hi",
+		Blocks: []hugot.Block{
+			hugot.Header{Text: "Deploy"},
+			hugot.Section{Text: "done"},
+			hugot.Image{URL: "https://example.com/graph.png", AltText: "graph", Title: "Latency"},
+			hugot.Code{Language: "go", Text: "x := 1"},
+			hugot.Actions{Buttons: []hugot.Button{{Text: "Docs", URL: "https://example.com"}}},
+			hugot.Divider{},
+		},
+	}
+
+	bs, err := json.Marshal(FromHugot(in))
+	if err != nil {
+		t.Fatalf("marshal failed, %v", err)
+	}
+	var jm Message
+	if err := json.Unmarshal(bs, &jm); err != nil {
+		t.Fatalf("unmarshal failed, %v", err)
+	}
+	if out := jm.ToHugot(); !reflect.DeepEqual(out.Blocks, in.Blocks) {
+		t.Fatalf("expected %#v, got %#v", in.Blocks, out.Blocks)
+	}
+}
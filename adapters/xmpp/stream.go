@@ -0,0 +1,366 @@
+package xmpp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	nsClient   = "jabber:client"
+	nsStream   = "http://etherx.jabber.org/streams"
+	nsTLS      = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsSASL     = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsMUCOwner = "http://jabber.org/protocol/muc#owner"
+	nsData     = "jabber:x:data"
+)
+
+// conn is a client connection to an XMPP server.
+type conn struct {
+	net.Conn
+
+	r   *bufio.Reader
+	dec *xml.Decoder
+
+	wLock sync.Mutex
+
+	jid string // the full JID bound to this connection
+}
+
+func newConn(nc net.Conn) *conn {
+	c := &conn{}
+	c.reset(nc)
+	return c
+}
+
+// reset replaces the underlying connection, and starts decoding from
+// scratch, as required after STARTTLS.
+func (c *conn) reset(nc net.Conn) {
+	c.Conn = nc
+	c.r = bufio.NewReader(nc)
+	c.dec = xml.NewDecoder(c.r)
+}
+
+// open starts a new stream to the server, returning the stream features
+// it offers.
+func (c *conn) open(domain string) (*features, error) {
+	// The buffered reader is an io.ByteReader, so the decoder will not
+	// read beyond the elements it returns, and we can safely start a
+	// new decoder for the new stream.
+	c.dec = xml.NewDecoder(c.r)
+
+	hdr := fmt.Sprintf("<stream:stream to='%s' xmlns='%s' xmlns:stream='%s' version='1.0'>", escape(domain), nsClient, nsStream)
+	if err := c.sendRaw(hdr); err != nil {
+		return nil, err
+	}
+
+	for {
+		t, err := c.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			if se.Name.Space != nsStream || se.Name.Local != "stream" {
+				return nil, fmt.Errorf("expected stream, got %s", se.Name.Local)
+			}
+			break
+		}
+	}
+
+	se, err := c.next()
+	if err != nil {
+		return nil, err
+	}
+	if se.Name.Space != nsStream || se.Name.Local != "features" {
+		return nil, fmt.Errorf("expected stream features, got %s", se.Name.Local)
+	}
+
+	var fs features
+	if err := c.dec.DecodeElement(&fs, &se); err != nil {
+		return nil, err
+	}
+	return &fs, nil
+}
+
+// next returns the start of the next top level element in the stream.
+// The caller must decode, or skip, the element.
+func (c *conn) next() (xml.StartElement, error) {
+	for {
+		t, err := c.dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Space == nsStream && t.Name.Local == "error" {
+				var se streamError
+				c.dec.DecodeElement(&se, &t)
+				return xml.StartElement{}, se
+			}
+			return t, nil
+		case xml.EndElement:
+			// The server has closed the stream.
+			return xml.StartElement{}, io.EOF
+		}
+	}
+}
+
+// send marshals v, and writes it to the stream.
+func (c *conn) send(v interface{}) error {
+	bs, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(string(bs))
+}
+
+func (c *conn) sendRaw(s string) error {
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	_, err := io.WriteString(c.Conn, s)
+	return err
+}
+
+// negotiate secures the stream, authenticates, and binds a resource.
+func (c *conn) negotiate(x *xmpp) error {
+	fs, err := c.open(x.domain)
+	if err != nil {
+		return err
+	}
+
+	if fs.StartTLS != nil {
+		if err := c.sendRaw(fmt.Sprintf("<starttls xmlns='%s'/>", nsTLS)); err != nil {
+			return err
+		}
+		se, err := c.next()
+		if err != nil {
+			return err
+		}
+		if se.Name.Local != "proceed" {
+			return errors.New("server refused STARTTLS")
+		}
+
+		cfg := &tls.Config{}
+		if x.tlsConfig != nil {
+			cfg = x.tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = x.domain
+		}
+		tc := tls.Client(c.Conn, cfg)
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		c.reset(tc)
+
+		if fs, err = c.open(x.domain); err != nil {
+			return err
+		}
+	} else if !x.plaintext {
+		return errors.New("server does not support STARTTLS")
+	}
+
+	if !fs.hasMechanism("PLAIN") {
+		return errors.New("server does not support PLAIN authentication")
+	}
+	creds := base64.StdEncoding.EncodeToString([]byte("\x00" + x.local + "\x00" + x.password))
+	if err := c.sendRaw(fmt.Sprintf("<auth xmlns='%s' mechanism='PLAIN'>%s</auth>", nsSASL, creds)); err != nil {
+		return err
+	}
+	se, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch se.Name.Local {
+	case "success":
+		c.dec.Skip()
+	case "failure":
+		var f saslFailure
+		c.dec.DecodeElement(&f, &se)
+		return fmt.Errorf("authentication failed, %s", f.Condition.Local)
+	default:
+		return fmt.Errorf("unexpected authentication response %s", se.Name.Local)
+	}
+
+	if fs, err = c.open(x.domain); err != nil {
+		return err
+	}
+	if fs.Bind == nil {
+		return errors.New("server does not support resource binding")
+	}
+
+	const bindID = "bind1"
+	if err := c.send(iq{ID: bindID, Type: "set", Bind: &bind{Resource: x.resource}}); err != nil {
+		return err
+	}
+	for {
+		se, err := c.next()
+		if err != nil {
+			return err
+		}
+		if se.Name.Local != "iq" {
+			c.dec.Skip()
+			continue
+		}
+		var res iq
+		if err := c.dec.DecodeElement(&res, &se); err != nil {
+			return err
+		}
+		if res.ID != bindID {
+			continue
+		}
+		if res.Type != "result" || res.Bind == nil {
+			return errors.New("resource binding failed")
+		}
+		c.jid = res.Bind.JID
+		return nil
+	}
+}
+
+type features struct {
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms *struct {
+		Mechanism []string `xml:"mechanism"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms"`
+	Bind *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+func (fs *features) hasMechanism(m string) bool {
+	if fs.Mechanisms == nil {
+		return false
+	}
+	for _, fm := range fs.Mechanisms.Mechanism {
+		if fm == m {
+			return true
+		}
+	}
+	return false
+}
+
+type saslFailure struct {
+	Condition xml.Name `xml:",any"`
+}
+
+type streamError struct {
+	Condition xml.Name `xml:",any"`
+	Text      string   `xml:"urn:ietf:params:xml:ns:xmpp-streams text"`
+}
+
+func (se streamError) Error() string {
+	if se.Text != "" {
+		return fmt.Sprintf("stream error %s, %s", se.Condition.Local, se.Text)
+	}
+	return fmt.Sprintf("stream error %s", se.Condition.Local)
+}
+
+type message struct {
+	XMLName xml.Name `xml:"jabber:client message"`
+	ID      string   `xml:"id,attr,omitempty"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	Type    string   `xml:"type,attr,omitempty"`
+	Subject *string  `xml:"subject"`
+	Body    string   `xml:"body,omitempty"`
+	Thread  string   `xml:"thread,omitempty"`
+	Delay   *delay   `xml:"urn:xmpp:delay delay"`
+	MUCUser *mucUser `xml:"http://jabber.org/protocol/muc#user x"`
+}
+
+type delay struct {
+	Stamp string `xml:"stamp,attr"`
+}
+
+type presence struct {
+	XMLName xml.Name `xml:"jabber:client presence"`
+	ID      string   `xml:"id,attr,omitempty"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	Type    string   `xml:"type,attr,omitempty"`
+	MUC     *muc     `xml:"http://jabber.org/protocol/muc x"`
+	MUCUser *mucUser `xml:"http://jabber.org/protocol/muc#user x"`
+}
+
+type muc struct {
+	History *history `xml:"history"`
+}
+
+type history struct {
+	MaxStanzas int `xml:"maxstanzas,attr"`
+}
+
+type mucUser struct {
+	Invite *invite `xml:"invite"`
+	Item   *struct {
+		JID string `xml:"jid,attr"`
+	} `xml:"item"`
+	Status []struct {
+		Code string `xml:"code,attr"`
+	} `xml:"status"`
+}
+
+func (mu *mucUser) hasStatus(code string) bool {
+	if mu == nil {
+		return false
+	}
+	for _, s := range mu.Status {
+		if s.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+type invite struct {
+	To string `xml:"to,attr"`
+}
+
+type iq struct {
+	XMLName xml.Name     `xml:"jabber:client iq"`
+	ID      string       `xml:"id,attr"`
+	From    string       `xml:"from,attr,omitempty"`
+	To      string       `xml:"to,attr,omitempty"`
+	Type    string       `xml:"type,attr"`
+	Bind    *bind        `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Ping    *struct{}    `xml:"urn:xmpp:ping ping"`
+	Error   *stanzaError `xml:"error"`
+}
+
+type bind struct {
+	Resource string `xml:"resource,omitempty"`
+	JID      string `xml:"jid,omitempty"`
+}
+
+type stanzaError struct {
+	Type               string    `xml:"type,attr"`
+	ServiceUnavailable *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-stanzas service-unavailable"`
+}
+
+// splitJID splits a JID into its bare JID, and resource.
+func splitJID(jid string) (bare, resource string) {
+	if i := strings.Index(jid, "/"); i >= 0 {
+		return jid[:i], jid[i+1:]
+	}
+	return jid, ""
+}
+
+// localPart returns the local part of a JID.
+func localPart(jid string) string {
+	bare, _ := splitJID(jid)
+	if i := strings.Index(bare, "@"); i >= 0 {
+		return bare[:i]
+	}
+	return ""
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,526 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package xmpp implements an adapter for XMPP (Jabber) servers, with
+// support for multi-user chat rooms.
+package xmpp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+
+	dialTimeout = 30 * time.Second
+
+	// keepalive is how often whitespace is sent to the server, so that
+	// dead connections are noticed.
+	keepalive = time.Minute
+)
+
+// ErrNotConnected is returned when the adapter is not connected to the
+// XMPP server.
+var ErrNotConnected = errors.New("not connected to the XMPP server")
+
+type xmpp struct {
+	local    string
+	domain   string
+	resource string
+	password string
+	nick     string
+
+	server    string
+	tlsConfig *tls.Config
+	plaintext bool
+
+	dirPat *regexp.Regexp
+
+	roomsLock sync.Mutex
+	rooms     map[string]bool   // rooms we have joined
+	occupants map[string]string // occupant JIDs to real JIDs, where known
+
+	connLock sync.Mutex
+	conn     *conn
+
+	ids uint64
+
+	c     chan *hugot.Message
+	start sync.Once
+
+	log hugot.Logger
+}
+
+// Opt functions are used to set options on the xmpp adapter.
+type Opt func(*xmpp)
+
+// WithRooms sets multi-user chat rooms to join once connected, given as
+// bare room JIDs, such as ops@conference.example.com.
+func WithRooms(rooms ...string) Opt {
+	return func(x *xmpp) {
+		for _, r := range rooms {
+			x.rooms[r] = true
+		}
+	}
+}
+
+// WithNick sets the nick used in rooms, by default the local part of the
+// bot's JID is used.
+func WithNick(nick string) Opt {
+	return func(x *xmpp) {
+		x.nick = nick
+	}
+}
+
+// WithServer sets the host:port to connect to. By default the server is
+// found with a DNS SRV lookup for the JID's domain.
+func WithServer(addr string) Opt {
+	return func(x *xmpp) {
+		x.server = addr
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for STARTTLS.
+func WithTLSConfig(cfg *tls.Config) Opt {
+	return func(x *xmpp) {
+		x.tlsConfig = cfg
+	}
+}
+
+// WithPlaintext allows connecting to servers that do not support
+// STARTTLS. The bot's password will be sent unencrypted.
+func WithPlaintext() Opt {
+	return func(x *xmpp) {
+		x.plaintext = true
+	}
+}
+
+// WithLogger sets the Logger used by the adapter.
+func WithLogger(l hugot.Logger) Opt {
+	return func(x *xmpp) {
+		x.log = l
+	}
+}
+
+// New creates a new XMPP adapter, that will log in as jid. If jid
+// includes a resource it is used, otherwise the resource is "hugot".
+func New(jid, password string, opts ...Opt) (hugot.Adapter, error) {
+	bare, resource := splitJID(jid)
+	i := strings.Index(bare, "@")
+	if i <= 0 || i == len(bare)-1 {
+		return nil, fmt.Errorf("invalid JID %q", jid)
+	}
+	if resource == "" {
+		resource = "hugot"
+	}
+
+	x := &xmpp{
+		local:     bare[:i],
+		domain:    bare[i+1:],
+		resource:  resource,
+		password:  password,
+		nick:      bare[:i],
+		rooms:     map[string]bool{},
+		occupants: map[string]string{},
+		c:         make(chan *hugot.Message),
+		log:       hugot.DefaultLogger,
+	}
+	for _, opt := range opts {
+		opt(x)
+	}
+	x.log = x.log.With(hugot.LogAdapter, "xmpp")
+
+	x.dirPat = regexp.MustCompile(fmt.Sprintf("^%s[:, ]+(.*)", regexp.QuoteMeta(x.nick)))
+
+	return x, nil
+}
+
+// Send implements hugot.Sender. Messages to rooms we have joined are sent
+// to the room, any other channel is treated as a JID to chat with
+// directly. The stanza ID of the message is returned.
+func (x *xmpp) Send(ctx context.Context, m *hugot.Message) string {
+	x.Start()
+	if m.Private {
+		if m.Channel == "" {
+			if m.To != "" {
+				m.Channel = m.To
+			} else {
+				m.Channel = m.From
+			}
+		}
+	}
+	x.log.Debug("sending message", hugot.LogChannel, m.Channel, "text", m.Text)
+
+	typ := "chat"
+	if x.inRoom(m.Channel) {
+		typ = "groupchat"
+	}
+
+	id := x.nextID()
+	err := x.send(message{
+		ID:     id,
+		To:     m.Channel,
+		Type:   typ,
+		Body:   m.PlainText(),
+		Thread: m.ThreadID,
+	})
+	if err != nil {
+		metrics.SendFailures.WithLabelValues(fmt.Sprintf("%T", x)).Inc()
+		x.log.Error("error sending message", hugot.LogChannel, m.Channel, "error", err)
+		return ""
+	}
+
+	return id
+}
+
+// IsTextOnly implements hugot.TextOnly
+func (x *xmpp) IsTextOnly() {
+}
+
+// Receive implements hugot.Receiver
+func (x *xmpp) Receive() <-chan *hugot.Message {
+	x.Start()
+	return x.c
+}
+
+// Start connects to the server, if we have not already done so.
+func (x *xmpp) Start() {
+	x.start.Do(func() {
+		go x.run()
+	})
+}
+
+// run connects to the server, reconnecting with a backoff when the
+// connection fails.
+func (x *xmpp) run() {
+	backoff := minBackoff
+	for {
+		connected, err := x.connect()
+		if connected {
+			backoff = minBackoff
+		}
+		x.log.Error("disconnected", "error", err, "retry", backoff)
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// connect logs in to the server, and handles incoming stanzas until the
+// connection fails.
+func (x *xmpp) connect() (connected bool, err error) {
+	addr := x.server
+	if addr == "" {
+		addr = net.JoinHostPort(x.domain, "5222")
+		if _, srvs, err := net.LookupSRV("xmpp-client", "tcp", x.domain); err == nil && len(srvs) > 0 {
+			addr = net.JoinHostPort(strings.TrimSuffix(srvs[0].Target, "."), strconv.Itoa(int(srvs[0].Port)))
+		}
+	}
+
+	nc, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return false, err
+	}
+	c := newConn(nc)
+	defer c.Close()
+
+	if err := c.negotiate(x); err != nil {
+		return false, err
+	}
+	x.log.Info("connected", "server", addr, "jid", c.jid)
+
+	x.connLock.Lock()
+	x.conn = c
+	x.connLock.Unlock()
+	defer func() {
+		x.connLock.Lock()
+		x.conn = nil
+		x.connLock.Unlock()
+	}()
+
+	if err := c.send(presence{}); err != nil {
+		return true, err
+	}
+
+	x.roomsLock.Lock()
+	var rooms []string
+	for r := range x.rooms {
+		rooms = append(rooms, r)
+	}
+	x.occupants = map[string]string{}
+	x.roomsLock.Unlock()
+	for _, r := range rooms {
+		if err := c.send(x.joinPresence(r)); err != nil {
+			return true, err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(keepalive)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := c.sendRaw(" "); err != nil {
+					c.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return true, x.serve(c)
+}
+
+// serve handles incoming stanzas.
+func (x *xmpp) serve(c *conn) error {
+	for {
+		se, err := c.next()
+		if err != nil {
+			return err
+		}
+
+		switch se.Name.Local {
+		case "message":
+			var msg message
+			if err := c.dec.DecodeElement(&msg, &se); err != nil {
+				return err
+			}
+			if m := x.eventToHugot(&msg); m != nil {
+				x.c <- m
+			}
+		case "presence":
+			var p presence
+			if err := c.dec.DecodeElement(&p, &se); err != nil {
+				return err
+			}
+			x.handlePresence(c, &p)
+		case "iq":
+			var q iq
+			if err := c.dec.DecodeElement(&q, &se); err != nil {
+				return err
+			}
+			if err := x.handleIQ(c, &q); err != nil {
+				return err
+			}
+		default:
+			if err := c.dec.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handlePresence tracks the real JIDs of room occupants, where the room
+// makes them available, and configures rooms that we have created.
+func (x *xmpp) handlePresence(c *conn, p *presence) {
+	room, nick := splitJID(p.From)
+	if p.Type == "error" {
+		x.log.Error("presence error", hugot.LogChannel, room)
+		return
+	}
+
+	x.roomsLock.Lock()
+	if p.Type == "unavailable" {
+		delete(x.occupants, p.From)
+	} else if p.MUCUser != nil && p.MUCUser.Item != nil && p.MUCUser.Item.JID != "" {
+		jid, _ := splitJID(p.MUCUser.Item.JID)
+		x.occupants[p.From] = jid
+	}
+	x.roomsLock.Unlock()
+
+	// New rooms are locked until they are configured, we accept the
+	// default configuration.
+	if nick == x.nick && p.MUCUser.hasStatus("201") {
+		cfg := fmt.Sprintf("<iq type='set' id='%s' to='%s'><query xmlns='%s'><x xmlns='%s' type='submit'/></query></iq>",
+			x.nextID(), escape(room), nsMUCOwner, nsData)
+		if err := c.sendRaw(cfg); err != nil {
+			x.log.Error("could not configure room", hugot.LogChannel, room, "error", err)
+		}
+	}
+}
+
+// handleIQ responds to pings, and rejects any other requests.
+func (x *xmpp) handleIQ(c *conn, q *iq) error {
+	if q.Type != "get" && q.Type != "set" {
+		return nil
+	}
+
+	res := iq{ID: q.ID, To: q.From, Type: "result"}
+	if q.Ping == nil {
+		res.Type = "error"
+		res.Error = &stanzaError{Type: "cancel", ServiceUnavailable: &struct{}{}}
+	}
+	return c.send(res)
+}
+
+func (x *xmpp) eventToHugot(msg *message) *hugot.Message {
+	if msg.Body == "" || msg.Delay != nil || msg.Type == "error" {
+		// Errors, room history, and notifications such as typing.
+		return nil
+	}
+
+	bare, resource := splitJID(msg.From)
+	txt := msg.Body
+	tobot := false
+	priv := false
+	channel := bare
+	from := localPart(bare)
+
+	x.roomsLock.Lock()
+	inRoom := x.rooms[bare]
+	userID, ok := x.occupants[msg.From]
+	x.roomsLock.Unlock()
+	if !ok {
+		userID = bare
+	}
+
+	switch {
+	case msg.Type == "groupchat":
+		if resource == "" || resource == x.nick {
+			// Room announcements, and our own messages
+			return nil
+		}
+		from = resource
+		if !ok {
+			userID = msg.From
+		}
+
+		// Check if the message was sent @bot, if so, set it as to us
+		// and strip the leading politeness
+		dirMatch := x.dirPat.FindStringSubmatch(txt)
+		if len(dirMatch) > 1 {
+			tobot = true
+			txt = strings.Trim(dirMatch[1], " ")
+		}
+	case inRoom:
+		// A private message from a room occupant, which we must reply to
+		// via the room.
+		tobot = true
+		priv = true
+		channel = msg.From
+		from = resource
+		if !ok {
+			userID = msg.From
+		}
+	default:
+		tobot = true
+		priv = true
+	}
+
+	return &hugot.Message{
+		Channel:  channel,
+		From:     from,
+		To:       x.nick,
+		Text:     txt,
+		ToBot:    tobot,
+		UserID:   userID,
+		ID:       msg.ID,
+		ThreadID: msg.Thread,
+		Private:  priv,
+	}
+}
+
+func (x *xmpp) nextID() string {
+	return "hugot" + strconv.FormatUint(atomic.AddUint64(&x.ids, 1), 10)
+}
+
+func (x *xmpp) inRoom(room string) bool {
+	x.roomsLock.Lock()
+	defer x.roomsLock.Unlock()
+	return x.rooms[room]
+}
+
+func (x *xmpp) joinPresence(room string) presence {
+	return presence{
+		To:  room + "/" + x.nick,
+		MUC: &muc{History: &history{MaxStanzas: 0}},
+	}
+}
+
+func (x *xmpp) send(v interface{}) error {
+	x.connLock.Lock()
+	c := x.conn
+	x.connLock.Unlock()
+	if c == nil {
+		return ErrNotConnected
+	}
+	return c.send(v)
+}
+
+// Join joins a room. If we are not currently connected, the room is
+// joined once we connect.
+func (x *xmpp) Join(room string) error {
+	x.Start()
+	x.roomsLock.Lock()
+	x.rooms[room] = true
+	x.roomsLock.Unlock()
+
+	if err := x.send(x.joinPresence(room)); err != ErrNotConnected {
+		return err
+	}
+	return nil
+}
+
+// Invite invites user to a room.
+func (x *xmpp) Invite(room, user string) error {
+	return x.send(message{
+		To:      room,
+		MUCUser: &mucUser{Invite: &invite{To: user}},
+	})
+}
+
+// CreateChannel creates a room, by joining it. New rooms are created
+// with the server's default configuration.
+func (x *xmpp) CreateChannel(room string) error {
+	return x.Join(room)
+}
+
+// LeaveChannel leaves a room.
+func (x *xmpp) LeaveChannel(room string) error {
+	x.roomsLock.Lock()
+	delete(x.rooms, room)
+	x.roomsLock.Unlock()
+
+	return x.send(presence{To: room + "/" + x.nick, Type: "unavailable"})
+}
+
+// SetChannelTopic sets the subject of a room.
+func (x *xmpp) SetChannelTopic(room, topic string) error {
+	return x.send(message{To: room, Type: "groupchat", Subject: &topic})
+}
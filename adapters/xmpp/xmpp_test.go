@@ -0,0 +1,235 @@
+package xmpp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tcolgate/hugot"
+)
+
+const room = "ops@conference.example.com"
+
+// element is any element sent by the client.
+type element struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   string     `xml:",innerxml"`
+}
+
+func (e element) attr(name string) string {
+	for _, a := range e.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// testServer is the server side of a client connection.
+type testServer struct {
+	t *testing.T
+	net.Conn
+	dec *xml.Decoder
+}
+
+func (s *testServer) write(format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(s.Conn, format, args...); err != nil {
+		s.t.Fatalf("write failed, %v", err)
+	}
+}
+
+// openStream waits for the client to open a stream, and offers it
+// features.
+func (s *testServer) openStream(features string) {
+	for {
+		tok, err := s.dec.Token()
+		if err != nil {
+			s.t.Fatalf("waiting for stream, %v", err)
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "stream" {
+			break
+		}
+	}
+	s.write("<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' id='s1' from='example.com' version='1.0'>")
+	s.write("<stream:features>%s</stream:features>", features)
+}
+
+func (s *testServer) expect(name string) element {
+	s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		tok, err := s.dec.Token()
+		if err != nil {
+			s.t.Fatalf("waiting for %s, %v", name, err)
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			var e element
+			if err := s.dec.DecodeElement(&e, &se); err != nil {
+				s.t.Fatalf("bad %s, %v", name, err)
+			}
+			if se.Name.Local != name {
+				s.t.Fatalf("expected %s, got %s %s", name, se.Name.Local, e.Inner)
+			}
+			return e
+		}
+	}
+}
+
+// accept accepts a client connection, and logs it in.
+func accept(t *testing.T, ln net.Listener) *testServer {
+	nc, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept failed, %v", err)
+	}
+	s := &testServer{t: t, Conn: nc, dec: xml.NewDecoder(nc)}
+	t.Cleanup(func() { nc.Close() })
+
+	s.openStream("<mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms>")
+	auth := s.expect("auth")
+	creds, _ := base64.StdEncoding.DecodeString(auth.Inner)
+	if string(creds) != "\x00hugot\x00pass" || auth.attr("mechanism") != "PLAIN" {
+		t.Fatalf("unexpected auth %q", creds)
+	}
+	s.write("<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>")
+
+	s.openStream("<bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'/>")
+	b := s.expect("iq")
+	if !strings.Contains(b.Inner, "<resource>hugot</resource>") {
+		t.Fatalf("unexpected bind %s", b.Inner)
+	}
+	s.write("<iq type='result' id='%s'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><jid>hugot@example.com/hugot</jid></bind></iq>", b.attr("id"))
+
+	if p := s.expect("presence"); p.attr("to") != "" {
+		t.Fatalf("expected initial presence, got %v", p)
+	}
+	if p := s.expect("presence"); p.attr("to") != room+"/hugot" || !strings.Contains(p.Inner, "maxstanzas=\"0\"") {
+		t.Fatalf("expected to join room, got %v %s", p, p.Inner)
+	}
+
+	return s
+}
+
+func receive(t *testing.T, c <-chan *hugot.Message) *hugot.Message {
+	select {
+	case m := <-c:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+	return nil
+}
+
+func TestXMPP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, %v", err)
+	}
+	defer ln.Close()
+
+	a, err := New("hugot@example.com", "pass", WithServer(ln.Addr().String()), WithPlaintext(), WithRooms(room))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+	msgs := a.Receive()
+	s := accept(t, ln)
+
+	// Room history, and our own messages, are ignored.
+	s.write("<message from='%s/bob' type='groupchat' id='h1'><body>old</body><delay xmlns='urn:xmpp:delay' stamp='2020-01-01T00:00:00Z'/></message>", room)
+	s.write("<message from='%s/hugot' type='groupchat' id='h2'><body>mine</body></message>", room)
+
+	s.write("<presence from='%s/bob'><x xmlns='http://jabber.org/protocol/muc#user'><item jid='bob@example.com/laptop' role='participant'/></x></presence>", room)
+	s.write("<message from='%s/bob' type='groupchat' id='m1'><body>hugot: ping</body></message>", room)
+	m := receive(t, msgs)
+	if m.Channel != room || m.From != "bob" || m.UserID != "bob@example.com" || m.Text != "ping" || !m.ToBot || m.Private || m.ID != "m1" {
+		t.Fatalf("unexpected message %#v", m)
+	}
+
+	s.write("<message from='%s/carol' type='groupchat' id='m2'><body>hello all</body></message>", room)
+	m = receive(t, msgs)
+	if m.Channel != room || m.From != "carol" || m.UserID != room+"/carol" || m.Text != "hello all" || m.ToBot {
+		t.Fatalf("unexpected message %#v", m)
+	}
+
+	s.write("<message from='alice@example.com/phone' type='chat' id='m3'><body>status</body></message>")
+	m = receive(t, msgs)
+	if m.Channel != "alice@example.com" || m.From != "alice" || m.UserID != "alice@example.com" || !m.Private || !m.ToBot {
+		t.Fatalf("unexpected message %#v", m)
+	}
+
+	s.write("<message from='%s/bob' type='chat' id='m4'><body>psst</body></message>", room)
+	m = receive(t, msgs)
+	if m.Channel != room+"/bob" || m.From != "bob" || !m.Private || !m.ToBot {
+		t.Fatalf("unexpected message %#v", m)
+	}
+
+	s.write("<iq type='get' id='ping1' from='example.com'><ping xmlns='urn:xmpp:ping'/></iq>")
+	if res := s.expect("iq"); res.attr("id") != "ping1" || res.attr("type") != "result" {
+		t.Fatalf("unexpected ping response %v", res)
+	}
+	s.write("<iq type='get' id='q1' from='example.com'><query xmlns='http://jabber.org/protocol/disco#info'/></iq>")
+	if res := s.expect("iq"); res.attr("id") != "q1" || res.attr("type") != "error" {
+		t.Fatalf("unexpected query response %v", res)
+	}
+
+	id := a.Send(context.Background(), &hugot.Message{Channel: room, Text: "a < b"})
+	sent := s.expect("message")
+	if id == "" || sent.attr("id") != id || sent.attr("to") != room || sent.attr("type") != "groupchat" ||
+		sent.Inner != "<body>a &lt; b</body>" {
+		t.Fatalf("unexpected message %v %s", sent, sent.Inner)
+	}
+
+	a.Send(context.Background(), &hugot.Message{Channel: "alice@example.com", Text: "ok", Private: true})
+	if sent := s.expect("message"); sent.attr("type") != "chat" || sent.attr("to") != "alice@example.com" {
+		t.Fatalf("unexpected message %v", sent)
+	}
+
+	if err := a.(hugot.ChannelManager).SetChannelTopic(room, "deploys"); err != nil {
+		t.Fatalf("could not set topic, %v", err)
+	}
+	if sent := s.expect("message"); sent.Inner != "<subject>deploys</subject>" {
+		t.Fatalf("unexpected message %s", sent.Inner)
+	}
+
+	// The adapter should reconnect, and rejoin the room.
+	s.Close()
+	s = accept(t, ln)
+	s.write("<message from='%s/bob' type='groupchat' id='m5'><body>hugot, again</body></message>", room)
+	if m := receive(t, msgs); m.Text != "again" || !m.ToBot {
+		t.Fatalf("unexpected message %#v", m)
+	}
+}
+
+func TestRequireTLS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		dec := xml.NewDecoder(nc)
+		dec.Token()
+		io.WriteString(nc, "<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>"+
+			"<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms></stream:features>")
+		dec.Token()
+	}()
+
+	a, err := New("hugot@example.com", "pass", WithServer(ln.Addr().String()))
+	if err != nil {
+		t.Fatalf("could not create adapter, %v", err)
+	}
+	if connected, err := a.(*xmpp).connect(); connected || err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("expected connection without TLS to fail, got %v", err)
+	}
+}
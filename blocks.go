@@ -0,0 +1,281 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+package hugot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Block is an element of a rich message layout. Adapters render the
+// blocks of a message in whatever way suits the chat system, adapters that
+// only support text render them with PlainText. Text within blocks may
+// include simple markup, which is passed to the adapter unchanged.
+type Block interface {
+	isBlock()
+}
+
+// Header is a block holding a heading for the blocks that follow it.
+type Header struct {
+	Text string
+}
+
+// Section is a block of text, with an optional set of fields.
+type Section struct {
+	Text   string
+	Fields []Field
+}
+
+// Field is a titled value, displayed as part of a Section. Adapters may
+// lay out fields in columns.
+type Field struct {
+	Title string
+	Value string
+}
+
+// Image is a block displaying the image at URL. AltText describes the
+// image for adapters that cannot display it.
+type Image struct {
+	URL     string
+	AltText string
+	Title   string
+}
+
+// Code is a block of preformatted text, such as source code, or the
+// output of a command. Language is an optional hint for syntax
+// highlighting.
+type Code struct {
+	Language string
+	Text     string
+}
+
+// Actions is a block holding a row of buttons.
+type Actions struct {
+	Buttons []Button
+}
+
+// ButtonStyle hints at how a button should be displayed.
+type ButtonStyle string
+
+// The supported button styles.
+const (
+	ButtonDefault = ButtonStyle("")
+	ButtonPrimary = ButtonStyle("primary")
+	ButtonDanger  = ButtonStyle("danger")
+)
+
+// Button is either a link to URL, or, if URL is empty, an action that is
+// reported back to the bot with the button's ID and Value when pressed.
+// How actions are received is specific to each adapter.
+type Button struct {
+	ID    string
+	Text  string
+	Value string
+	URL   string
+	Style ButtonStyle
+}
+
+// Divider is a block that visually separates the blocks around it.
+type Divider struct{}
+
+func (Header) isBlock()  {}
+func (Section) isBlock() {}
+func (Image) isBlock()   {}
+func (Code) isBlock()    {}
+func (Actions) isBlock() {}
+func (Divider) isBlock() {}
+
+// PlainText renders blocks as plain text, for adapters that cannot
+// display rich messages. Buttons are listed, but cannot be pressed.
+func PlainText(bs []Block) string {
+	var ls []string
+	for _, b := range bs {
+		switch b := b.(type) {
+		case Header:
+			ls = append(ls, b.Text, strings.Repeat("=", len(b.Text)))
+		case Section:
+			if b.Text != "" {
+				ls = append(ls, b.Text)
+			}
+			for _, f := range b.Fields {
+				ls = append(ls, fmt.Sprintf("%s: %s", f.Title, f.Value))
+			}
+		case Image:
+			switch {
+			case b.Title != "":
+				ls = append(ls, fmt.Sprintf("%s: %s", b.Title, b.URL))
+			case b.AltText != "":
+				ls = append(ls, fmt.Sprintf("%s: %s", b.AltText, b.URL))
+			default:
+				ls = append(ls, b.URL)
+			}
+		case Code:
+			ls = append(ls, strings.TrimSuffix(b.Text, "\n"))
+		case Actions:
+			var bts []string
+			for _, bt := range b.Buttons {
+				if bt.URL != "" {
+					bts = append(bts, fmt.Sprintf("[%s] %s", bt.Text, bt.URL))
+					continue
+				}
+				bts = append(bts, fmt.Sprintf("[%s]", bt.Text))
+			}
+			ls = append(ls, strings.Join(bts, " "))
+		case Divider:
+			ls = append(ls, "---")
+		}
+	}
+	return strings.Join(ls, "\n")
+}
+
+// PlainText returns the text of m, followed by its blocks rendered as
+// plain text. Adapters that only support text should send this in place
+// of m.Text.
+func (m *Message) PlainText() string {
+	bt := PlainText(m.Blocks)
+	switch {
+	case bt == "":
+		return m.Text
+	case m.Text == "":
+		return bt
+	default:
+		return m.Text + "\n" + bt
+	}
+}
+
+// BlockBuilder builds a set of blocks from templates. The text passed to
+// each method is parsed as a text/template, and executed with the
+// builder's data. The text may invoke any of the templates in the set the
+// builder was created with. Building stops at the first error, which is
+// returned by Blocks.
+type BlockBuilder struct {
+	tmpls  *template.Template
+	data   interface{}
+	blocks []Block
+	err    error
+}
+
+// NewBlockBuilder creates a BlockBuilder that executes templates with
+// data. tmpls may be nil.
+func NewBlockBuilder(tmpls *template.Template, data interface{}) *BlockBuilder {
+	if tmpls == nil {
+		tmpls = template.New("")
+	}
+	return &BlockBuilder{tmpls: tmpls, data: data}
+}
+
+func (b *BlockBuilder) expand(txt string) string {
+	if b.err != nil || !strings.Contains(txt, "{{") {
+		return txt
+	}
+
+	t, err := b.tmpls.Clone()
+	if err == nil {
+		t, err = t.New("block").Parse(txt)
+	}
+	if err != nil {
+		b.err = err
+		return ""
+	}
+
+	out := bytes.Buffer{}
+	if err := t.Execute(&out, b.data); err != nil {
+		b.err = err
+		return ""
+	}
+	return out.String()
+}
+
+// Header adds a Header block.
+func (b *BlockBuilder) Header(txt string) *BlockBuilder {
+	b.blocks = append(b.blocks, Header{Text: b.expand(txt)})
+	return b
+}
+
+// Section adds a Section block.
+func (b *BlockBuilder) Section(txt string) *BlockBuilder {
+	b.blocks = append(b.blocks, Section{Text: b.expand(txt)})
+	return b
+}
+
+// Field adds a field to the preceding Section block, or to a new
+// Section if the previous block was not one.
+func (b *BlockBuilder) Field(title, value string) *BlockBuilder {
+	f := Field{Title: b.expand(title), Value: b.expand(value)}
+	if n := len(b.blocks); n > 0 {
+		if s, ok := b.blocks[n-1].(Section); ok {
+			s.Fields = append(s.Fields, f)
+			b.blocks[n-1] = s
+			return b
+		}
+	}
+	b.blocks = append(b.blocks, Section{Fields: []Field{f}})
+	return b
+}
+
+// Image adds an Image block.
+func (b *BlockBuilder) Image(url, alt string) *BlockBuilder {
+	b.blocks = append(b.blocks, Image{URL: b.expand(url), AltText: b.expand(alt)})
+	return b
+}
+
+// Code adds a Code block.
+func (b *BlockBuilder) Code(lang, txt string) *BlockBuilder {
+	b.blocks = append(b.blocks, Code{Language: lang, Text: b.expand(txt)})
+	return b
+}
+
+// Button adds an action button to the preceding Actions block, or to a
+// new Actions block if the previous block was not one.
+func (b *BlockBuilder) Button(id, txt, value string, style ButtonStyle) *BlockBuilder {
+	return b.button(Button{ID: id, Text: b.expand(txt), Value: b.expand(value), Style: style})
+}
+
+// LinkButton adds a button linking to url, as with Button.
+func (b *BlockBuilder) LinkButton(txt, url string) *BlockBuilder {
+	return b.button(Button{Text: b.expand(txt), URL: b.expand(url)})
+}
+
+func (b *BlockBuilder) button(bt Button) *BlockBuilder {
+	if n := len(b.blocks); n > 0 {
+		if as, ok := b.blocks[n-1].(Actions); ok {
+			as.Buttons = append(as.Buttons, bt)
+			b.blocks[n-1] = as
+			return b
+		}
+	}
+	b.blocks = append(b.blocks, Actions{Buttons: []Button{bt}})
+	return b
+}
+
+// Divider adds a Divider block.
+func (b *BlockBuilder) Divider() *BlockBuilder {
+	b.blocks = append(b.blocks, Divider{})
+	return b
+}
+
+// Blocks returns the blocks built, or the first error encountered
+// while expanding templates.
+func (b *BlockBuilder) Blocks() ([]Block, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.blocks, nil
+}
@@ -0,0 +1,69 @@
+package hugot_test
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/tcolgate/hugot"
+)
+
+func TestMessage_PlainText(t *testing.T) {
+	m := &hugot.Message{
+		Text: "release ready",
+		Blocks: []hugot.Block{
+			hugot.Header{Text: "v1.2.3"},
+			hugot.Section{Text: "changes", Fields: []hugot.Field{{Title: "Env", Value: "prod"}}},
+			hugot.Code{Text: "make deploy\n"},
+			hugot.Divider{},
+			hugot.Actions{Buttons: []hugot.Button{
+				{ID: "approve", Text: "Approve"},
+				{Text: "Diff", URL: "http://example.com/diff"},
+			}},
+		},
+	}
+
+	expected := `release ready
+v1.2.3
+======
+changes
+Env: prod
+make deploy
+---
+[Approve] [Diff] http://example.com/diff`
+	if txt := m.PlainText(); txt != expected {
+		t.Fatalf("expected %q, got %q", expected, txt)
+	}
+}
+
+func TestBlockBuilder(t *testing.T) {
+	tmpls := template.Must(template.New("").Parse(`{{define "who"}}{{.User}}{{end}}`))
+	data := struct{ Version, User string }{"v1.2.3", "bob"}
+
+	bs, err := hugot.NewBlockBuilder(tmpls, data).
+		Header("Release {{.Version}}").
+		Field("Requested by", `{{template "who" .}}`).
+		Field("Version", "{{.Version}}").
+		Button("approve", "Approve", "{{.Version}}", hugot.ButtonPrimary).
+		Button("deny", "Deny", "{{.Version}}", hugot.ButtonDanger).
+		Blocks()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(bs) != 3 {
+		t.Fatalf("expected 3 blocks, got %#v", bs)
+	}
+	if h := bs[0].(hugot.Header); h.Text != "Release v1.2.3" {
+		t.Fatalf("unexpected header %#v", h)
+	}
+	if s := bs[1].(hugot.Section); len(s.Fields) != 2 || s.Fields[0].Value != "bob" {
+		t.Fatalf("unexpected section %#v", s)
+	}
+	if as := bs[2].(hugot.Actions); len(as.Buttons) != 2 || as.Buttons[1].Value != "v1.2.3" {
+		t.Fatalf("unexpected actions %#v", as)
+	}
+
+	if _, err := hugot.NewBlockBuilder(nil, data).Section("{{.Missing}}").Blocks(); err == nil {
+		t.Fatalf("expected template error")
+	}
+}
@@ -24,16 +24,19 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/tcolgate/hugot"
 	"github.com/tcolgate/hugot/handlers/command"
 	"github.com/tcolgate/hugot/handlers/hears"
 	"github.com/tcolgate/hugot/handlers/mux"
+	"github.com/tcolgate/hugot/metrics"
 	"github.com/tcolgate/hugot/storage"
 	"github.com/tcolgate/hugot/storage/memory"
 	"github.com/tcolgate/hugot/storage/prefix"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -42,18 +45,24 @@ var DefaultBot *Bot
 
 func init() {
 	DefaultBot = New()
-	DefaultBot.Mux = mux.New("hugot", "")
 
 	http.Handle("/hugot", DefaultBot.Mux)
 	http.Handle("/hugot/", DefaultBot.Mux)
 
-	DefaultBot.Commands = command.Set{}
+	DefaultBot.Mux.HandleHTTP(hugot.NewWebHookHandler("metrics", "prometheus metrics", promhttp.Handler().ServeHTTP))
+}
 
-	DefaultBot.Mux.ToBot = DefaultBot.Commands
-	DefaultBot.Commands.MustAdd(DefaultBot.Mux)
+// DefaultShutdownTimeout is how long a bot will wait for handlers to
+// finish when shutting down, unless set with WithShutdownTimeout.
+const DefaultShutdownTimeout = 10 * time.Second
 
-	DefaultBot.Store = memory.New()
-}
+// ErrShutdownTimeout is returned by ListenAndServe if handlers did not
+// finish before the shutdown timeout.
+var ErrShutdownTimeout = errors.New("timed out waiting for handlers to finish")
+
+// ErrDropped is passed to hugot.Completer adapters for messages that were
+// not processed because of rate or concurrency limits.
+var ErrDropped = errors.New("message dropped")
 
 // Bot is the main type for implementing chat bots. They listen on one or more
 // adapters and pass messages to, and from handlers.
@@ -61,32 +70,150 @@ type Bot struct {
 	Store    storage.Storer
 	Mux      *mux.Mux
 	Commands command.Set
+
+	log             hugot.Logger
+	shutdownTimeout time.Duration
+
+	maxConcurrent int
+	userLimits    *limiters
+	channelLimits *limiters
+}
+
+// Opt functions are used to set options on the Bot
+type Opt func(*Bot)
+
+// WithLogger sets the Logger used by the bot. The logger is also passed
+// to handlers in the context, with fields describing the message being
+// processed. If no logger is set, hugot.DefaultLogger is used.
+func WithLogger(l hugot.Logger) Opt {
+	return func(b *Bot) {
+		b.log = l
+	}
+}
+
+// WithShutdownTimeout sets how long the bot will wait for in-flight
+// and background handlers to finish once it is shutting down.
+func WithShutdownTimeout(d time.Duration) Opt {
+	return func(b *Bot) {
+		b.shutdownTimeout = d
+	}
+}
+
+// WithMaxConcurrency limits the number of messages that the bot will
+// process at once. Messages arriving when the limit has been reached are
+// dropped, and the user is asked to try again.
+func WithMaxConcurrency(n int) Opt {
+	return func(b *Bot) {
+		b.maxConcurrent = n
+	}
+}
+
+// WithUserRateLimit limits each user to one message every d, with bursts
+// of up to burst messages. Messages over the limit are dropped, and the
+// user is asked to slow down.
+func WithUserRateLimit(every time.Duration, burst int) Opt {
+	return func(b *Bot) {
+		b.userLimits = newLimiters(every, burst)
+	}
+}
+
+// WithChannelRateLimit limits each channel to one message every d, with
+// bursts of up to burst messages. Messages over the limit are dropped, and
+// the channel is asked to slow down.
+func WithChannelRateLimit(every time.Duration, burst int) Opt {
+	return func(b *Bot) {
+		b.channelLimits = newLimiters(every, burst)
+	}
 }
 
-// New creates a new bot.
-func New() *Bot {
-	b := &Bot{}
+// New creates a new bot. The bot has an in memory store, and a Mux that
+// passes messages sent to the bot to its Commands.
+func New(opts ...Opt) *Bot {
+	b := &Bot{
+		Store:    memory.New(),
+		Mux:      mux.New("hugot", ""),
+		Commands: command.Set{},
+
+		shutdownTimeout: DefaultShutdownTimeout,
+	}
+	b.Mux.ToBot = b.Commands
+	b.Commands.MustAdd(b.Mux)
+
+	b.Configure(opts...)
+
 	return b
 }
 
+// Configure sets options on the bot. It must be called before
+// ListenAndServe.
+func (b *Bot) Configure(opts ...Opt) {
+	for _, opt := range opts {
+		opt(b)
+	}
+}
+
+// Configure sets options on the DefaultBot. It must be called before
+// ListenAndServe.
+func Configure(opts ...Opt) {
+	DefaultBot.Configure(opts...)
+}
+
+func (b *Bot) logger() hugot.Logger {
+	if b.log == nil {
+		return hugot.DefaultLogger
+	}
+	return b.log
+}
+
 // ListenAndServe runs the DefaultBot handler loop.
-func ListenAndServe(ctx context.Context, h hugot.Handler, a hugot.Adapter, as ...hugot.Adapter) {
-	DefaultBot.ListenAndServe(ctx, h, a, as...)
+func ListenAndServe(ctx context.Context, h hugot.Handler, a hugot.Adapter, as ...hugot.Adapter) error {
+	return DefaultBot.ListenAndServe(ctx, h, a, as...)
 }
 
 // ListenAndServe runs the handler h, passing all messages to/from
-// the provided adapter. The context may be used to gracefully shut
-// down the server.
-func (b *Bot) ListenAndServe(ctx context.Context, h hugot.Handler, a hugot.Adapter, as ...hugot.Adapter) {
+// the provided adapter. Messages that are replies to a handler waiting in
+// hugot.Ask are passed to that handler, rather than to h.
+//
+// Cancelling ctx shuts the bot down gracefully. The bot stops receiving
+// messages, cancels the context of any background handlers, and waits
+// for in-flight and background handlers to finish. The context passed to
+// in-flight handlers is cancelled if they have not finished within the
+// shutdown timeout.
+//
+// ListenAndServe always returns a non-nil error. This will be ctx.Err() if
+// ctx was cancelled, the error of the first adapter to stop receiving, or
+// ErrShutdownTimeout if the handlers did not finish in time.
+func (b *Bot) ListenAndServe(ctx context.Context, h hugot.Handler, a hugot.Adapter, as ...hugot.Adapter) error {
 	ctx = hugot.NewAdapterContext(ctx, a)
 
+	log := b.logger()
+	ctx = hugot.NewLoggerContext(ctx, log)
+
+	convs := hugot.NewConversations()
+	ctx = hugot.NewConversationsContext(ctx, convs)
+
 	if h == nil {
 		h = b.Mux
 	}
 
+	g, ctx := errgroup.WithContext(ctx)
+
+	// In-flight handlers are not cancelled as soon as we start shutting
+	// down, to give them a chance to finish.
+	hctx, hcancel := context.WithCancel(detach(ctx))
+	defer hcancel()
+
+	var running sync.WaitGroup
+
+	var slots chan struct{}
+	if b.maxConcurrent > 0 {
+		slots = make(chan struct{}, b.maxConcurrent)
+	}
+
 	an := fmt.Sprintf("%T", a)
 	if bh, ok := h.(hugot.BackgroundHandler); ok {
-		runBackgroundHandler(ctx, bh, hugot.NewResponseWriter(a, hugot.Message{}, an))
+		bctx := hugot.NewLoggerContext(ctx, log.With(hugot.LogAdapter, an))
+		runBackgroundHandler(bctx, &running, bh, hugot.NewResponseWriter(a, hugot.Message{}, an))
 	}
 
 	if wh, ok := h.(hugot.WebHookHandler); ok {
@@ -94,13 +221,13 @@ func (b *Bot) ListenAndServe(ctx context.Context, h hugot.Handler, a hugot.Adapt
 	}
 
 	type smrw struct {
-		w hugot.ResponseWriter
-		m *hugot.Message
+		w  hugot.ResponseWriter
+		m  *hugot.Message
+		a  hugot.Adapter
+		an string
 	}
 	mrws := make(chan smrw)
 
-	g, ctx := errgroup.WithContext(ctx)
-
 	for _, a := range append(as, a) {
 		a := a
 		g.Go(func() error {
@@ -111,8 +238,13 @@ func (b *Bot) ListenAndServe(ctx context.Context, h hugot.Handler, a hugot.Adapt
 					if m == nil {
 						return io.EOF
 					}
+					metrics.MessagesReceived.WithLabelValues(an).Inc()
 					rw := hugot.NewResponseWriter(a, *m, an)
-					mrws <- smrw{rw, m}
+					select {
+					case mrws <- smrw{rw, m, a, an}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
 				case <-ctx.Done():
 					return ctx.Err()
 				}
@@ -120,36 +252,149 @@ func (b *Bot) ListenAndServe(ctx context.Context, h hugot.Handler, a hugot.Adapt
 		})
 	}
 
+	errc := make(chan error, 1)
 	go func() {
-		g.Wait()
+		errc <- g.Wait()
 	}()
 
 	hn, _ := h.Describe()
-	for {
+	for ctx.Err() == nil {
 		select {
 		case mrw := <-mrws:
 			mrw.m.Store = prefix.New(b.Store, []string{hn})
-			go func(smrw) {
-				if err := h.ProcessMessage(ctx, mrw.w, mrw.m); err != nil {
-					mrw.w.Send(ctx, mrw.m.Replyf("%v\n", err))
+			if convs.Deliver(mrw.m) {
+				complete(hctx, mrw.a, mrw.m, nil)
+				continue
+			}
+			if !b.allow(hctx, mrw.w, mrw.m) {
+				complete(hctx, mrw.a, mrw.m, ErrDropped)
+				continue
+			}
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+				default:
+					if mrw.m.ToBot {
+						mrw.w.Send(hctx, mrw.m.Reply("I'm too busy to deal with that right now, please try again shortly"))
+					}
+					complete(hctx, mrw.a, mrw.m, ErrDropped)
+					continue
+				}
+			}
+			running.Add(1)
+			go func(mrw smrw) {
+				defer running.Done()
+				if slots != nil {
+					defer func() { <-slots }()
 				}
+				mctx := hugot.NewAdapterContext(hctx, mrw.a)
+				mctx = hugot.NewLoggerContext(mctx, log.With(
+					hugot.LogAdapter, mrw.an,
+					hugot.LogChannel, mrw.m.Channel,
+					hugot.LogUser, mrw.m.From))
+				err := h.ProcessMessage(mctx, mrw.w, mrw.m)
+				if err != nil {
+					mrw.w.Send(hctx, mrw.m.Replyf("%v\n", err))
+				}
+				complete(hctx, mrw.a, mrw.m, err)
 			}(mrw)
 
 		case <-ctx.Done():
-			return
 		}
 	}
+
+	log.Info("shutting down, waiting for handlers to finish", "timeout", b.shutdownTimeout)
+
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+
+	timeout := time.NewTimer(b.shutdownTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-done:
+	case <-timeout.C:
+		return ErrShutdownTimeout
+	}
+
+	select {
+	case err := <-errc:
+		return err
+	case <-timeout.C:
+		// Some adapters block in Receive, we report why we stopped
+		// rather than waiting for them.
+		return ctx.Err()
+	}
+}
+
+// allow applies the per user and per channel rate limits to m. If m is
+// over either limit, and directed at the bot, the sender is told to slow
+// down the first time the limit is hit.
+func (b *Bot) allow(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) bool {
+	now := time.Now()
+
+	if b.userLimits != nil {
+		u := m.UserID
+		if u == "" {
+			u = m.From
+		}
+		if ok, warn := b.userLimits.allow(u, now); !ok {
+			if warn && m.ToBot {
+				w.Send(ctx, m.Reply("Please slow down, I'll be ignoring you for a moment"))
+			}
+			return false
+		}
+	}
+
+	if b.channelLimits != nil {
+		if ok, warn := b.channelLimits.allow(m.Channel, now); !ok {
+			if warn && m.ToBot {
+				w.Send(ctx, m.Reply("This channel is too busy, please slow down"))
+			}
+			return false
+		}
+	}
+
+	return true
+}
+
+// complete tells a, if it is a hugot.Completer, that m has been dealt
+// with.
+func complete(ctx context.Context, a hugot.Adapter, m *hugot.Message, err error) {
+	if c, ok := a.(hugot.Completer); ok {
+		c.Complete(ctx, m, err)
+	}
 }
 
 // runBackgroundHandler starts the provided BackgroundHandler in a new
-// go routine.
-func runBackgroundHandler(ctx context.Context, h hugot.BackgroundHandler, w hugot.ResponseWriter) {
-	glog.Infof("Starting background %v\n", h)
+// go routine, tracked by wg.
+func runBackgroundHandler(ctx context.Context, wg *sync.WaitGroup, h hugot.BackgroundHandler, w hugot.ResponseWriter) {
+	n, _ := h.Describe()
+	hugot.LoggerFromContext(ctx).Info("starting background handler", hugot.LogHandler, n)
+	wg.Add(1)
 	go func(ctx context.Context, bh hugot.BackgroundHandler) {
-		h.StartBackground(ctx, w)
+		defer wg.Done()
+		bh.StartBackground(ctx, w)
 	}(ctx, h)
 }
 
+// detachedContext carries the values of a parent context, but is never
+// cancelled by it.
+type detachedContext struct {
+	context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
 // Raw adds the provided handler to the Mux of the DefaultBot.
 func Raw(hs ...hugot.Handler) error {
 	return DefaultBot.Mux.Raw(hs...)
@@ -0,0 +1,357 @@
+package bot_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/handlers/basic"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/hugottest"
+	"github.com/tcolgate/hugot/metrics"
+)
+
+func TestBot_Ask(t *testing.T) {
+	h := basic.New("ask", "asks a question", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		if m.Text != "deploy" {
+			return nil
+		}
+		r, err := hugot.Ask(ctx, w, m, "deploy to prod? (yes/no)")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "you said %s", r.Text)
+		return nil
+	})
+
+	in := make(chan *hugot.Message)
+	out := make(chan hugot.Message, 10)
+	ta := hugottest.NewAdapter(in, out)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go bot.New().ListenAndServe(ctx, h, ta)
+
+	ta.MessagesIn <- &hugot.Message{Text: "deploy", From: "bob", Channel: "ops"}
+	expect := func(txt string) {
+		select {
+		case m := <-out:
+			if m.Text != txt {
+				t.Fatalf("expected %q, got %q", txt, m.Text)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("timeout waiting for %q", txt)
+		}
+	}
+	expect("deploy to prod? (yes/no)")
+
+	ta.MessagesIn <- &hugot.Message{Text: "yes", From: "bob", Channel: "ops"}
+	expect("you said yes")
+}
+
+func TestAsk_Timeout(t *testing.T) {
+	ctx := hugot.NewConversationsContext(context.Background(), hugot.NewConversations())
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	m := &hugot.Message{Text: "deploy", From: "bob", Channel: "ops"}
+	_, err := hugot.Ask(ctx, hugot.NewNullResponseWriter(*m), m, "really?")
+	if err != hugot.ErrAskTimeout {
+		t.Fatalf("expected ErrAskTimeout, got %v", err)
+	}
+}
+
+func TestBot_GracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	h := basic.New("slow", "a slow handler", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+		return nil
+	})
+
+	in := make(chan *hugot.Message)
+	ta := hugottest.NewAdapter(in, make(chan hugot.Message, 10))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- bot.New().ListenAndServe(ctx, h, ta)
+	}()
+
+	in <- &hugot.Message{Text: "hello"}
+	<-started
+	cancel()
+
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	select {
+	case <-finished:
+	default:
+		t.Fatal("ListenAndServe returned before the handler finished")
+	}
+}
+
+func TestBot_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	h := basic.New("stuck", "a stuck handler", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	})
+
+	in := make(chan *hugot.Message)
+	ta := hugottest.NewAdapter(in, make(chan hugot.Message, 10))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- bot.New(bot.WithShutdownTimeout(10*time.Millisecond)).ListenAndServe(ctx, h, ta)
+	}()
+
+	in <- &hugot.Message{Text: "hello"}
+	<-started
+	cancel()
+
+	if err := <-errc; err != bot.ErrShutdownTimeout {
+		t.Fatalf("expected ErrShutdownTimeout, got %v", err)
+	}
+}
+
+func TestBot_AdapterClosed(t *testing.T) {
+	in := make(chan *hugot.Message)
+	ta := hugottest.NewAdapter(in, make(chan hugot.Message, 10))
+	h := basic.New("noop", "does nothing", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		return nil
+	})
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- bot.New().ListenAndServe(context.Background(), h, ta)
+	}()
+	close(in)
+
+	select {
+	case err := <-errc:
+		if err != io.EOF {
+			t.Fatalf("expected io.EOF, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ListenAndServe did not return when the adapter closed")
+	}
+}
+
+func TestBot_UserRateLimit(t *testing.T) {
+	h := basic.New("echo", "echos", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		fmt.Fprint(w, m.Text)
+		return nil
+	})
+
+	in := make(chan *hugot.Message)
+	out := make(chan hugot.Message, 10)
+	ta := hugottest.NewAdapter(in, out)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go bot.New(bot.WithUserRateLimit(time.Hour, 1)).ListenAndServe(ctx, h, ta)
+
+	expect := func(txt string) {
+		select {
+		case m := <-out:
+			if m.Text != txt {
+				t.Fatalf("expected %q, got %q", txt, m.Text)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("timeout waiting for %q", txt)
+		}
+	}
+
+	in <- &hugot.Message{Text: "one", From: "bob", ToBot: true}
+	expect("one")
+	in <- &hugot.Message{Text: "two", From: "bob", ToBot: true}
+	expect("Please slow down, I'll be ignoring you for a moment")
+	in <- &hugot.Message{Text: "three", From: "bob", ToBot: true}
+	in <- &hugot.Message{Text: "four", From: "alice", ToBot: true}
+	expect("four")
+}
+
+func TestBot_MaxConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	h := basic.New("block", "blocks", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		<-release
+		return nil
+	})
+
+	in := make(chan *hugot.Message)
+	out := make(chan hugot.Message, 10)
+	ta := hugottest.NewAdapter(in, out)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer close(release)
+
+	go bot.New(bot.WithMaxConcurrency(1)).ListenAndServe(ctx, h, ta)
+
+	in <- &hugot.Message{Text: "one", From: "bob", ToBot: true}
+	in <- &hugot.Message{Text: "two", From: "bob", ToBot: true}
+
+	select {
+	case m := <-out:
+		if m.Text != "I'm too busy to deal with that right now, please try again shortly" {
+			t.Fatalf("unexpected reply %q", m.Text)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("timeout waiting for busy reply")
+	}
+}
+
+type completion struct {
+	text string
+	err  error
+}
+
+// completingAdapter records the messages the bot reports as complete.
+type completingAdapter struct {
+	*hugottest.Adapter
+	done chan completion
+}
+
+func (a *completingAdapter) Complete(ctx context.Context, m *hugot.Message, err error) {
+	a.done <- completion{m.Text, err}
+}
+
+func TestBot_Complete(t *testing.T) {
+	errFailed := errors.New("failed")
+	h := basic.New("fail", "fails", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		if m.Text == "fail" {
+			return errFailed
+		}
+		return nil
+	})
+
+	in := make(chan *hugot.Message)
+	out := make(chan hugot.Message, 10)
+	ta := &completingAdapter{hugottest.NewAdapter(in, out), make(chan completion, 10)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go bot.New(bot.WithUserRateLimit(time.Hour, 2)).ListenAndServe(ctx, h, ta)
+
+	expect := func(txt string, err error) {
+		select {
+		case c := <-ta.done:
+			if c.text != txt || c.err != err {
+				t.Fatalf("expected %q completed with %v, got %q with %v", txt, err, c.text, c.err)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("timeout waiting for %q to complete", txt)
+		}
+	}
+
+	in <- &hugot.Message{Text: "ok", From: "bob", ToBot: true}
+	expect("ok", nil)
+	in <- &hugot.Message{Text: "fail", From: "bob", ToBot: true}
+	expect("fail", errFailed)
+	in <- &hugot.Message{Text: "ignored", From: "bob", ToBot: true}
+	expect("ignored", bot.ErrDropped)
+}
+
+func TestDefaultBot_Metrics(t *testing.T) {
+	c := metrics.MessagesReceived.WithLabelValues("test")
+	exp := testutil.ToFloat64(c) + 1
+	c.Inc()
+
+	rec := httptest.NewRecorder()
+	bot.DefaultBot.Mux.ServeHTTP(rec, httptest.NewRequest("GET", "/hugot/metrics", nil))
+
+	if !strings.Contains(rec.Body.String(), fmt.Sprintf(`hugot_messages_received_total{adapter="test"} %v`, exp)) {
+		t.Fatalf("expected metrics to be served, got %q", rec.Body.String())
+	}
+}
+
+func TestBot_Logger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := hugot.NewSlogLogger(slog.New(slog.NewTextHandler(buf, nil)))
+
+	done := make(chan struct{})
+	h := basic.New("log", "logs a message", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		defer close(done)
+		hugot.LoggerFromContext(ctx).Info("hello")
+		return nil
+	})
+
+	in := make(chan *hugot.Message)
+	out := make(chan hugot.Message, 10)
+	ta := hugottest.NewAdapter(in, out)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go bot.New(bot.WithLogger(l)).ListenAndServe(ctx, h, ta)
+
+	ta.MessagesIn <- &hugot.Message{Text: "hi", From: "bob", Channel: "ops"}
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timeout waiting for handler")
+	}
+
+	got := buf.String()
+	for _, f := range []string{"msg=hello", "adapter=", "channel=ops", "user=bob"} {
+		if !strings.Contains(got, f) {
+			t.Fatalf("expected %q in log output, got %q", f, got)
+		}
+	}
+}
+
+func TestNew_Defaults(t *testing.T) {
+	b := bot.New()
+	if b.Store == nil || b.Mux == nil || b.Commands == nil {
+		t.Fatalf("expected New to set up a store, mux and commands")
+	}
+	b.Configure(bot.WithMaxConcurrency(1))
+
+	b.Command(command.NewFunc(func(root *command.Command) error {
+		root.Use = "hello"
+		root.Run = func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+			fmt.Fprint(w, "hi")
+			return nil
+		}
+		return nil
+	}))
+
+	in := make(chan *hugot.Message)
+	out := make(chan hugot.Message, 10)
+	ta := hugottest.NewAdapter(in, out)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go b.ListenAndServe(ctx, nil, ta)
+
+	in <- &hugot.Message{Text: "hello", From: "bob", ToBot: true}
+	select {
+	case m := <-out:
+		if m.Text != "hi" {
+			t.Fatalf("unexpected reply %q", m.Text)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("timeout waiting for reply")
+	}
+}
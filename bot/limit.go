@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdle is how long a limiter must be unused before it is
+// discarded.
+const limiterIdle = 10 * time.Minute
+
+// limiters maintains a set of token bucket rate limiters, one per key.
+type limiters struct {
+	every time.Duration
+	burst int
+
+	sync.Mutex
+	ls        map[string]*limiter
+	lastPrune time.Time
+}
+
+type limiter struct {
+	*rate.Limiter
+	seen   time.Time
+	warned bool
+}
+
+func newLimiters(every time.Duration, burst int) *limiters {
+	return &limiters{
+		every: every,
+		burst: burst,
+		ls:    map[string]*limiter{},
+	}
+}
+
+// allow reports whether an event for key is allowed at now. If it is not,
+// warn is true the first time the limit is hit, so that the user can be
+// told without the warnings themselves flooding the channel.
+func (l *limiters) allow(key string, now time.Time) (ok bool, warn bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastPrune) > limiterIdle {
+		for k, li := range l.ls {
+			if now.Sub(li.seen) > limiterIdle {
+				delete(l.ls, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	li, found := l.ls[key]
+	if !found {
+		li = &limiter{Limiter: rate.NewLimiter(rate.Every(l.every), l.burst)}
+		l.ls[key] = li
+	}
+	li.seen = now
+
+	if li.AllowN(now, 1) {
+		li.warned = false
+		return true, false
+	}
+
+	warn = !li.warned
+	li.warned = true
+	return false, warn
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"context"
+
+	// Add some handlers
+	"github.com/golang/glog"
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/adapters/email"
+	"github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/handlers/command/ping"
+	"github.com/tcolgate/hugot/logging/glogger"
+)
+
+var (
+	address  = flag.String("email.address", "hugot@localhost", "Address the bot receives mail at")
+	imapAddr = flag.String("email.imap", "localhost:993", "IMAP server to read mail from")
+	smtpAddr = flag.String("email.smtp", "localhost:587", "SMTP server to send mail with")
+	user     = flag.String("email.user", "hugot", "IMAP and SMTP username")
+	pass     = flag.String("email.pass", os.Getenv("EMAIL_PASSWORD"), "IMAP and SMTP password")
+	mailbox  = flag.String("email.mailbox", "INBOX", "Mailbox to poll")
+)
+
+func main() {
+	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a, err := email.New(*address, *imapAddr, *smtpAddr, *user, *pass, email.WithMailbox(*mailbox))
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	ping.Register()
+
+	go http.ListenAndServe(":8081", nil)
+
+	if err := bot.ListenAndServe(ctx, nil, a); !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
+}
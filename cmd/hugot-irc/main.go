@@ -19,18 +19,25 @@ package main
 
 import (
 	"crypto/tls"
+	"errors"
 	"flag"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"context"
 
 	// Add some handlers
 	"github.com/fluffle/goirc/client"
+	"github.com/golang/glog"
+	"github.com/tcolgate/hugot"
 	"github.com/tcolgate/hugot/adapters/irc"
 	"github.com/tcolgate/hugot/bot"
 	"github.com/tcolgate/hugot/handlers/command/ping"
 	"github.com/tcolgate/hugot/handlers/hears/tableflip"
+	"github.com/tcolgate/hugot/logging/glogger"
 )
 
 var (
@@ -44,6 +51,7 @@ var (
 
 func main() {
 	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
 
 	c := client.NewConfig(*nick)
 	c.Server = *server
@@ -51,6 +59,9 @@ func main() {
 	c.Pass = *pass
 	c.SSLConfig = &tls.Config{ServerName: strings.Split(*server, ":")[0]}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	a := irc.New(c, *ircchan)
 
 	ping.Register()
@@ -58,5 +69,7 @@ func main() {
 
 	go http.ListenAndServe(":8081", nil)
 
-	bot.ListenAndServe(context.Background(), nil, a)
+	if err := bot.ListenAndServe(ctx, nil, a); !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
 }
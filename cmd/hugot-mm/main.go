@@ -18,14 +18,20 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"context"
 
 	"github.com/golang/glog"
+	"github.com/tcolgate/hugot"
 	bot "github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/logging/glogger"
 
 	// Add some handlers
 
@@ -43,8 +49,10 @@ var pass = flag.String("pass", "hugot", "Bot pass")
 
 func main() {
 	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
 
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	a, err := mm.New(*mmurl, *team, *mail, *pass)
 	if err != nil {
 		glog.Fatal(err)
@@ -63,5 +71,7 @@ func main() {
 	glog.Infof("webhook at %s", wh.URL())
 
 	go http.ListenAndServe(":8080", nil)
-	bot.ListenAndServe(ctx, nil, a)
+	if err := bot.ListenAndServe(ctx, nil, a); !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
 }
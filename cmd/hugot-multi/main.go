@@ -18,6 +18,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -25,7 +26,6 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"time"
 
 	"context"
 
@@ -33,6 +33,7 @@ import (
 	"github.com/tcolgate/hugot/adapters/shell"
 	"github.com/tcolgate/hugot/adapters/ssh"
 	bot "github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/logging/glogger"
 	cssh "golang.org/x/crypto/ssh"
 
 	"github.com/tcolgate/hugot"
@@ -45,7 +46,10 @@ import (
 	"github.com/tcolgate/hugot/handlers/testweb"
 )
 
-var nick = flag.String("nick", "minion", "Bot nick")
+var (
+	nick     = flag.String("nick", "minion", "Bot nick")
+	authKeys = flag.String("authorized-keys", "authorized_keys", "File of authorized user keys, the comment of each key is the username")
+)
 
 func bgHandler(ctx context.Context, w hugot.ResponseWriter) {
 	fmt.Fprint(w, "Starting backgroud")
@@ -60,6 +64,7 @@ func httpHandler(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	a1, err := shell.New(*nick)
@@ -69,18 +74,7 @@ func main() {
 
 	// An SSH server is represented by a ServerConfig, which holds
 	// certificate details and handles authentication of ServerConns.
-	config := &cssh.ServerConfig{
-		PublicKeyCallback: func(conn cssh.ConnMetadata, pkey cssh.PublicKey) (*cssh.Permissions, error) {
-			/*
-				pkey, err := models.SearchPublicKeyByContent(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
-				if err != nil {
-					// handle error
-					return nil, err
-				}
-				return &cssh.Permissions{Extensions: map[string]string{"key-id": com.ToStr(pkey.ID)}}, nil
-			*/
-			return nil, nil
-		}}
+	config := &cssh.ServerConfig{}
 
 	privateBytes, err := ioutil.ReadFile("host_rsa_key")
 	if err != nil {
@@ -101,7 +95,7 @@ func main() {
 		panic("failed to listen for connection")
 	}
 
-	a2 := ssh.New(*nick, listener, config)
+	a2 := ssh.New(*nick, listener, config, ssh.WithAuthorizedKeysFile(*authKeys))
 
 	bot.Background(hugot.NewBackgroundHandler("test bg", "testing bg", bgHandler))
 
@@ -115,15 +109,17 @@ func main() {
 	u, _ := url.Parse("http://localhost:8080")
 	bot.SetURL(u)
 
-	go bot.ListenAndServe(ctx, nil, a1, a2)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- bot.ListenAndServe(ctx, nil, a1, a2)
+	}()
 	go http.ListenAndServe(":8081", nil)
 
 	a1.Main()
 
 	cancel()
 
-	<-ctx.Done()
-
-	//delay to check we get the output
-	<-time.After(time.Second * 1)
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
 }
@@ -18,18 +18,21 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
-	"time"
+	"os"
+	"strconv"
 
 	"context"
 
 	"github.com/golang/glog"
 	"github.com/tcolgate/hugot/adapters/shell"
 	"github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/logging/glogger"
 	"github.com/tcolgate/hugot/storage/redis"
 
 	"github.com/tcolgate/hugot"
@@ -60,6 +63,7 @@ func httpHandler(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	a, err := shell.New(*nick)
@@ -78,22 +82,25 @@ func main() {
 	testcli.Register()
 	uptime.Register()
 	alias.Register()
-	roles.Register()
+	// The shell adapter identifies users by their uid.
+	roles.Register(roles.WithAdmins(strconv.Itoa(os.Getuid())))
 
 	bot.Background(hugot.NewBackgroundHandler("test bg", "testing bg", bgHandler))
 	bot.HandleHTTP(hugot.NewWebHookHandler("test", "test http", httpHandler))
 	u, _ := url.Parse("http://localhost:8080")
 	bot.SetURL(u)
 
-	go bot.ListenAndServe(ctx, nil, a)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- bot.ListenAndServe(ctx, hugot.Chain(bot.DefaultBot.Mux, hugot.Recover, hugot.Logging), a)
+	}()
 	go http.ListenAndServe(":8081", nil)
 
 	a.Main()
 
 	cancel()
 
-	<-ctx.Done()
-
-	//delay to check we get the output
-	<-time.After(time.Second * 1)
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
 }
@@ -18,6 +18,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -25,12 +26,16 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"context"
 
+	"github.com/golang/glog"
 	"github.com/tcolgate/hugot/adapters/ssh"
 	bot "github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/logging/glogger"
 
 	"github.com/tcolgate/hugot"
 
@@ -43,7 +48,10 @@ import (
 	"github.com/tcolgate/hugot/handlers/testweb"
 )
 
-var nick = flag.String("nick", "minion", "Bot nick")
+var (
+	nick     = flag.String("nick", "minion", "Bot nick")
+	authKeys = flag.String("authorized-keys", "authorized_keys", "File of authorized user keys, the comment of each key is the username")
+)
 
 func bgHandler(ctx context.Context, w hugot.ResponseWriter) {
 	fmt.Fprint(w, "Starting backgroud")
@@ -58,22 +66,15 @@ func httpHandler(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	ctx, cancel := context.WithCancel(context.Background())
 	// An SSH server is represented by a ServerConfig, which holds
 	// certificate details and handles authentication of ServerConns.
-	config := &cssh.ServerConfig{
-		PublicKeyCallback: func(conn cssh.ConnMetadata, pkey cssh.PublicKey) (*cssh.Permissions, error) {
-			/*
-				pkey, err := models.SearchPublicKeyByContent(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
-				if err != nil {
-					// handle error
-					return nil, err
-				}
-				return &cssh.Permissions{Extensions: map[string]string{"key-id": com.ToStr(pkey.ID)}}, nil
-			*/
-			return nil, nil
-		}}
+	// Client keys are checked by the adapter.
+	config := &cssh.ServerConfig{}
 
 	privateBytes, err := ioutil.ReadFile("host_rsa_key")
 	if err != nil {
@@ -94,7 +95,7 @@ func main() {
 		panic("failed to listen for connection")
 	}
 
-	a := ssh.New(*nick, listener, config)
+	a := ssh.New(*nick, listener, config, ssh.WithAuthorizedKeysFile(*authKeys))
 
 	ping.Register()
 	testcli.Register()
@@ -109,11 +110,7 @@ func main() {
 
 	go http.ListenAndServe(":8081", nil)
 
-	bot.ListenAndServe(ctx, nil, a)
-	cancel()
-
-	<-ctx.Done()
-
-	//delay to check we get the output
-	<-time.After(time.Second * 1)
+	if err := bot.ListenAndServe(ctx, nil, a); !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
 }
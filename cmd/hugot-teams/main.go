@@ -0,0 +1,77 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"context"
+
+	"github.com/golang/glog"
+	"github.com/tcolgate/hugot"
+	bot "github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/logging/glogger"
+
+	// Add some handlers
+
+	"github.com/tcolgate/hugot/adapters/teams"
+	"github.com/tcolgate/hugot/handlers/command/ping"
+	"github.com/tcolgate/hugot/handlers/command/testcli"
+	"github.com/tcolgate/hugot/handlers/hears/tableflip"
+	"github.com/tcolgate/hugot/handlers/testweb"
+)
+
+var appID = flag.String("app-id", os.Getenv("MICROSOFT_APP_ID"), "Bot Framework app ID")
+var appPassword = flag.String("app-password", os.Getenv("MICROSOFT_APP_PASSWORD"), "Bot Framework app password")
+var nick = flag.String("nick", "hugot", "Bot nick")
+
+func main() {
+	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	a, err := teams.New(*appID, *appPassword, *nick)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	bot.HandleHTTP(a)
+
+	ping.Register()
+	testcli.Register()
+	tableflip.Register()
+
+	wh := testweb.New()
+	bot.HandleHTTP(wh)
+
+	u, _ := url.Parse("http://localhost:8080")
+	bot.SetURL(u)
+
+	glog.Infof("webhook at %s", wh.URL())
+
+	go http.ListenAndServe(":8080", nil)
+	if err := bot.ListenAndServe(ctx, nil, a); !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"context"
+
+	"github.com/golang/glog"
+	"github.com/tcolgate/hugot"
+	bot "github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/logging/glogger"
+
+	// Add some handlers
+
+	"github.com/tcolgate/hugot/adapters/telegram"
+	"github.com/tcolgate/hugot/handlers/command/ping"
+	"github.com/tcolgate/hugot/handlers/command/testcli"
+	"github.com/tcolgate/hugot/handlers/hears/tableflip"
+	"github.com/tcolgate/hugot/handlers/testweb"
+)
+
+var token = flag.String("token", os.Getenv("TELEGRAM_TOKEN"), "Telegram bot token")
+var secret = flag.String("webhook-secret", os.Getenv("TELEGRAM_WEBHOOK_SECRET"), "Secret token, to receive updates by webhook rather than polling")
+var baseURL = flag.String("url", "http://localhost:8080", "Public URL of the bot's webhooks")
+
+func main() {
+	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var a hugot.Adapter
+	var tw *telegram.Webhook
+	var err error
+	if *secret != "" {
+		tw, err = telegram.NewWebhook(*token, *secret)
+		if err == nil {
+			bot.HandleHTTP(tw)
+			a = tw
+		}
+	} else {
+		a, err = telegram.New(*token)
+	}
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	ping.Register()
+	testcli.Register()
+	tableflip.Register()
+
+	wh := testweb.New()
+	bot.HandleHTTP(wh)
+
+	u, err := url.Parse(*baseURL)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	bot.SetURL(u)
+
+	glog.Infof("webhook at %s", wh.URL())
+
+	if tw != nil {
+		if err := tw.SetWebhook(ctx); err != nil {
+			glog.Fatal(err)
+		}
+		glog.Infof("telegram webhook at %s", tw.URL())
+	}
+
+	go http.ListenAndServe(":8080", nil)
+	if err := bot.ListenAndServe(ctx, nil, a); !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
+}
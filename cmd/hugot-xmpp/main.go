@@ -0,0 +1,72 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"context"
+
+	// Add some handlers
+	"github.com/golang/glog"
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/adapters/xmpp"
+	"github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/handlers/command/ping"
+	"github.com/tcolgate/hugot/handlers/hears/tableflip"
+	"github.com/tcolgate/hugot/logging/glogger"
+)
+
+var (
+	jid    = flag.String("xmpp.jid", "hugot@localhost", "JID to log in as")
+	pass   = flag.String("xmpp.pass", os.Getenv("XMPP_PASSWORD"), "XMPP password")
+	nick   = flag.String("nick", "hugot", "Bot nick in rooms")
+	server = flag.String("xmpp.server", "", "Server to connect to, found by DNS SRV lookup if not set")
+	room   = flag.String("xmpp.room", "", "Room to join")
+)
+
+func main() {
+	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := []xmpp.Opt{xmpp.WithNick(*nick), xmpp.WithServer(*server)}
+	if *room != "" {
+		opts = append(opts, xmpp.WithRooms(*room))
+	}
+	a, err := xmpp.New(*jid, *pass, opts...)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	ping.Register()
+	tableflip.Register()
+
+	go http.ListenAndServe(":8081", nil)
+
+	if err := bot.ListenAndServe(ctx, nil, a); !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
+}
@@ -18,16 +18,22 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"context"
 
 	"github.com/golang/glog"
+	"github.com/tcolgate/hugot"
 	"github.com/tcolgate/hugot/adapters/slack"
 	"github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/logging/glogger"
 
 	// Add some handlers
 
@@ -38,13 +44,48 @@ import (
 )
 
 var slackToken = flag.String("token", os.Getenv("SLACK_TOKEN"), "Slack API Token")
+var appToken = flag.String("app-token", os.Getenv("SLACK_APP_TOKEN"), "Slack app token, to receive messages with Socket Mode")
+var signingSecret = flag.String("signing-secret", os.Getenv("SLACK_SIGNING_SECRET"), "Slack signing secret, to receive messages with the Events API")
 var nick = flag.String("nick", "minion", "Bot nick")
 
 func main() {
 	flag.Parse()
+	hugot.DefaultLogger = glogger.New(2)
+	// Limits are optional, by default every message is processed.
+	bot.Configure(
+		bot.WithShutdownTimeout(30*time.Second),
+		bot.WithMaxConcurrency(64),
+		bot.WithUserRateLimit(time.Second, 5),
+		bot.WithChannelRateLimit(time.Second/5, 20),
+	)
 
-	ctx := context.Background()
-	a, err := slack.New(*slackToken, *nick)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var a hugot.Adapter
+	var as []hugot.Adapter
+	var err error
+	switch {
+	case *appToken != "":
+		a, err = slack.NewSocketMode(*appToken, *slackToken, *nick)
+	case *signingSecret != "":
+		var e *slack.Events
+		e, err = slack.NewEvents(*slackToken, *signingSecret, *nick)
+		if err != nil {
+			break
+		}
+		var i *slack.Interactions
+		i, err = slack.NewInteractions(*signingSecret, e)
+		if err != nil {
+			break
+		}
+		bot.HandleHTTP(e)
+		bot.HandleHTTP(i)
+		a, as = e, []hugot.Adapter{i}
+		bot.DefaultBot.Mux.ToBot = i.Actions(bot.DefaultBot.Mux.ToBot)
+	default:
+		a, err = slack.New(*slackToken, *nick)
+	}
 	if err != nil {
 		glog.Fatal(err)
 	}
@@ -62,5 +103,7 @@ func main() {
 	glog.Infof("webhook at %s", wh.URL())
 
 	go http.ListenAndServe(":8080", nil)
-	bot.ListenAndServe(ctx, nil, a)
+	if err := bot.ListenAndServe(ctx, nil, a, as...); !errors.Is(err, context.Canceled) {
+		glog.Fatal(err)
+	}
 }
@@ -7,6 +7,9 @@ type hugotCtxKey int
 //var adapterKey = hugotCtxKey(1)
 const (
 	adapterKey hugotCtxKey = iota
+	rolesKey
+	conversationsKey
+	loggerKey
 )
 
 // NewAdapterContext creates a context for passing an adapter. This is
@@ -28,3 +31,20 @@ func SenderFromContext(ctx context.Context) (Sender, bool) {
 	a, ok := ctx.Value(adapterKey).(Adapter)
 	return a, ok
 }
+
+// NewRolesContext creates a context carrying the set of roles held by
+// the user that sent the message being processed. This is normally
+// done by handlers/roles.
+func NewRolesContext(ctx context.Context, roles map[string]struct{}) context.Context {
+	return context.WithValue(ctx, rolesKey, roles)
+}
+
+// RolesFromContext returns the set of roles stored in a context. The
+// set is empty if no roles have been resolved for the user.
+func RolesFromContext(ctx context.Context) map[string]struct{} {
+	roles, _ := ctx.Value(rolesKey).(map[string]struct{})
+	if roles == nil {
+		roles = map[string]struct{}{}
+	}
+	return roles
+}
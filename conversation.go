@@ -0,0 +1,136 @@
+package hugot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// AskTimeout is the longest Ask will wait for a user to reply.
+var AskTimeout = 5 * time.Minute
+
+var (
+	// ErrNoConversations is returned by Ask if the context was not
+	// created by a bot that supports conversations.
+	ErrNoConversations = errors.New("conversations are not supported")
+
+	// ErrAskInProgress is returned by Ask if a handler is already waiting
+	// on a reply from the user in this channel.
+	ErrAskInProgress = errors.New("already waiting for a reply from this user")
+
+	// ErrAskTimeout is returned by Ask if the user did not reply in time.
+	ErrAskTimeout = errors.New("timed out waiting for a reply")
+)
+
+type convKey struct {
+	channel string
+	user    string
+}
+
+func convKeyFor(m *Message) convKey {
+	u := m.UserID
+	if u == "" {
+		u = m.From
+	}
+	return convKey{m.Channel, u}
+}
+
+// Conversations tracks handlers that are waiting on a reply from a user,
+// and is used by bots to route those replies. See Ask.
+type Conversations struct {
+	sync.Mutex
+	waiting map[convKey]chan *Message
+}
+
+// NewConversations creates an empty set of Conversations.
+func NewConversations() *Conversations {
+	return &Conversations{
+		waiting: map[convKey]chan *Message{},
+	}
+}
+
+// Deliver passes m to any handler waiting for a reply from the sender
+// of m, in the channel m arrived on. It returns true if m was delivered,
+// in which case it should not be processed any further.
+func (c *Conversations) Deliver(m *Message) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	k := convKeyFor(m)
+	rc, ok := c.waiting[k]
+	if !ok {
+		return false
+	}
+	delete(c.waiting, k)
+
+	rc <- m
+	return true
+}
+
+func (c *Conversations) wait(m *Message) (chan *Message, func(), error) {
+	c.Lock()
+	defer c.Unlock()
+
+	k := convKeyFor(m)
+	if _, ok := c.waiting[k]; ok {
+		return nil, nil, ErrAskInProgress
+	}
+
+	rc := make(chan *Message, 1)
+	c.waiting[k] = rc
+
+	cancel := func() {
+		c.Lock()
+		defer c.Unlock()
+		if c.waiting[k] == rc {
+			delete(c.waiting, k)
+		}
+	}
+
+	return rc, cancel, nil
+}
+
+// NewConversationsContext creates a context for passing a set of
+// Conversations to handlers.
+func NewConversationsContext(ctx context.Context, c *Conversations) context.Context {
+	return context.WithValue(ctx, conversationsKey, c)
+}
+
+// ConversationsFromContext returns the Conversations stored in a context.
+func ConversationsFromContext(ctx context.Context) (*Conversations, bool) {
+	c, ok := ctx.Value(conversationsKey).(*Conversations)
+	return c, ok
+}
+
+// Ask sends prompt as a reply to m, and waits for the user that sent m to
+// reply in the same channel. The reply is returned to the caller rather than
+// being processed by the bot's handlers. Ask gives up when ctx is done, or
+// after AskTimeout.
+func Ask(ctx context.Context, w ResponseWriter, m *Message, prompt string) (*Message, error) {
+	c, ok := ConversationsFromContext(ctx)
+	if !ok {
+		return nil, ErrNoConversations
+	}
+
+	rc, cancelWait, err := c.wait(m)
+	if err != nil {
+		return nil, err
+	}
+	defer cancelWait()
+
+	ctx, cancel := context.WithTimeout(ctx, AskTimeout)
+	defer cancel()
+
+	w.Send(ctx, m.Reply(prompt))
+
+	select {
+	case r := <-rc:
+		return r, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrAskTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
@@ -27,10 +27,17 @@
 // Adapters are used to integrate with external chat systems. Currently
 // the following adapters exist:
 //   slack - github.com/tcolgate/hugot/adapters/slack - for https://slack.com/
+//   discord - github.com/tcolgate/hugot/adapters/discord - for https://discord.com/
 //   mattermost - github.com/tcolgate/hugot/adapters/mattermost - for https://www.mattermost.org/
+//   matrix - github.com/tcolgate/hugot/adapters/matrix - for https://matrix.org/
+//   telegram - github.com/tcolgate/hugot/adapters/telegram - for https://telegram.org/
+//   teams - github.com/tcolgate/hugot/adapters/teams - for Microsoft Teams, via the Bot Framework
 //   irc - github.com/tcolgate/hugot/adapters/irc - simple irc adapter
+//   xmpp - github.com/tcolgate/hugot/adapters/xmpp - for XMPP (Jabber) servers, with multi-user chat
+//   webhook - github.com/tcolgate/hugot/adapters/webhook - exchanges JSON messages over HTTP, for bridging and testing
+//   email - github.com/tcolgate/hugot/adapters/email - reads mail with IMAP, and replies with SMTP
 //   shell - github.com/tcolgate/hugot/adapters/shell - simple readline based adapter
-//   ssh - github.com/tcolgate/hugot/adapters/ssh - Toy implementation of unauth'd ssh interface
+//   ssh - github.com/tcolgate/hugot/adapters/ssh - SSH server, authenticating users by public key
 //
 // Examples of using these adapters can be found in github.com/tcolgate/hugot/cmd
 //
@@ -60,5 +67,33 @@
 // a top level "help" Command handler is added to provide help on usage of the
 // various handlers added to the Mux.
 //
+// Middleware
+//
+// Middleware wraps a handler to add behaviour to all of the messages it
+// processes, and can be combined with Chain. Recover, Logging, Timeout and
+// Metrics middleware are provided.
+//
+// Metrics
+//
+// Prometheus metrics for the bot, its handlers and adapters, are defined in
+// github.com/tcolgate/hugot/metrics, and served by the default bot at
+// /hugot/metrics.
+//
+// Logging
+//
+// The bot, adapters and handlers log via a Logger, which can be set with
+// bot.WithLogger and the WithLogger option of each adapter, and defaults to
+// DefaultLogger. Handlers should use LoggerFromContext, which includes the
+// adapter, channel, user and handler for the message being processed. A
+// log/slog Logger is provided by NewSlogLogger, and a glog one by
+// github.com/tcolgate/hugot/logging/glogger.
+//
+// Rich Messages
+//
+// Messages may include Blocks, such as Sections, Fields, Buttons, Images and
+// Code, which each adapter renders natively. Adapters that only support text
+// send the result of Message.PlainText. A BlockBuilder can be used to build
+// blocks from templates.
+//
 // WARNING: The API is still subject to change.
 package hugot
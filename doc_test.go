@@ -42,7 +42,8 @@ func Example() {
 	flag.Parse()
 
 	// The context can be used to shutdown the bot and any
-	// Background handlers gracefully.
+	// Background handlers gracefully. ListenAndServe will
+	// return once they have finished.
 	ctx := context.Background()
 	a, err := slack.New(*slackToken, *nick)
 	if err != nil {
@@ -55,5 +56,7 @@ func Example() {
 
 	// This will start the default bot and process
 	// all messages seen by the slack adapter
-	bot.ListenAndServe(ctx, nil, a)
+	if err := bot.ListenAndServe(ctx, nil, a); err != nil {
+		glog.Error(err)
+	}
 }
@@ -24,19 +24,23 @@ import (
 	"context"
 
 	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/bot"
 	"github.com/tcolgate/hugot/handlers/command"
 )
 
-func ExampleMessage_Parse(ctx context.Context, w hugot.ResponseWriter, m *command.Message) error {
-	v := m.Bool("v", false, "be verbose")
-	dur := m.Duration("d", 1*time.Hour, "how long to do thing for")
-	baz := m.String("baz", "some argument", "another agument")
-
-	if err := m.Parse(); err != nil {
-		return err
-	}
-
-	fmt.Fprintf(w, "Got args %#v %#v %#v", *v, *dur, *baz)
-
-	return nil
+func Example_command() {
+	bot.Command(command.NewFunc(func(root *command.Command) error {
+		root.Use = "thing"
+		root.Short = "does a thing"
+
+		v := root.Flags().BoolP("verbose", "v", false, "be verbose")
+		dur := root.Flags().DurationP("duration", "d", 1*time.Hour, "how long to do thing for")
+		baz := root.Flags().String("baz", "some argument", "another agument")
+
+		root.Run = func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+			fmt.Fprintf(w, "Got args %#v %#v %#v", *v, *dur, *baz)
+			return nil
+		}
+		return nil
+	}))
 }
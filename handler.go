@@ -18,14 +18,10 @@
 package hugot
 
 import (
-	"flag"
 	"fmt"
 	"io"
-	"runtime/debug"
 
 	"context"
-
-	"github.com/golang/glog"
 )
 
 // Describer returns the name and description of a handler. This
@@ -57,6 +53,7 @@ type ResponseWriter interface {
 
 	SetChannel(c string) // Forces messages to a certain channel
 	SetTo(to string)     // Forces messages to a certain user
+	SetThread(id string) // Forces messages to a certain thread, "" for none
 	SetSender(a Sender)  // Forces messages to a different sender or adapter
 
 	Copy() ResponseWriter // Returns a copy of this response writer
@@ -71,6 +68,7 @@ type responseWriter struct {
 // NewResponseWriter creates a response writer that will send mesages via the
 // provided sender.
 func NewResponseWriter(s Sender, m Message, adapterName string) ResponseWriter {
+	m.ID = ""
 	return &responseWriter{s, m, adapterName}
 }
 
@@ -105,14 +103,20 @@ func (w *responseWriter) SetTo(s string) {
 	w.msg.To = s
 }
 
+// SetThread sets the thread for messages sent via this writer. Adapters
+// that do not support threads will send to the channel as normal.
+func (w *responseWriter) SetThread(id string) {
+	w.msg.ThreadID = id
+}
+
 // SetSender sets the target adapter for sender sent via this writer
 func (w *responseWriter) SetSender(s Sender) {
 	w.snd = s
 }
 
 // Send implements the Sender interface
-func (w *responseWriter) Send(ctx context.Context, m *Message) {
-	w.snd.Send(ctx, m)
+func (w *responseWriter) Send(ctx context.Context, m *Message) string {
+	return w.snd.Send(ctx, m)
 }
 
 // Copy returns a copy of this response writer
@@ -126,13 +130,6 @@ type nullSender struct {
 }
 
 // Send implements Send, and discards the message
-func (nullSender) Send(ctx context.Context, m *Message) {
-}
-
-func glogPanic() {
-	err := recover()
-	if err != nil && err != flag.ErrHelp {
-		glog.Error(err)
-		glog.Error(string(debug.Stack()))
-	}
+func (nullSender) Send(ctx context.Context, m *Message) string {
+	return ""
 }
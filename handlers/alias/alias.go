@@ -77,9 +77,9 @@ func (h *Alias) ProcessMessage(ctx context.Context, w hugot.ResponseWriter, m *h
 }
 
 // Help implements a command.Help hanndler for the alias handler
-func (h *Alias) Help(w io.Writer) error {
+func (h *Alias) Help(ctx context.Context, w io.Writer) error {
 	if hh, ok := h.up.(mux.Helper); ok {
-		return hh.Help(w)
+		return hh.Help(ctx, w)
 	}
 	return nil
 }
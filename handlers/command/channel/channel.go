@@ -0,0 +1,213 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package channel provides a command for managing chat channels, on
+// adapters that implement hugot.ChannelManager.
+package channel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/handlers/roles"
+)
+
+// ErrNotSupported is returned if the adapter the message came from cannot
+// manage channels.
+var ErrNotSupported = errors.New("I can't manage channels here")
+
+// New creates a new channel command. Users must hold any one of rs to
+// use it, if no roles are given the roles.Admin role is required.
+func New(rs ...string) *command.Handler {
+	if len(rs) == 0 {
+		rs = []string{roles.Admin}
+	}
+
+	return command.NewFunc(func(root *command.Command) error {
+		root.Use = "channel"
+		root.Short = "manage chat channels"
+		root.AnyRoles = rs
+
+		root.AddCommand(&command.Command{
+			Use:   "create channel",
+			Short: "create a channel, and join it",
+			Run:   create,
+		})
+
+		root.AddCommand(&command.Command{
+			Use:   "join channel",
+			Short: "join a channel",
+			Run:   join,
+		})
+
+		root.AddCommand(&command.Command{
+			Use:   "leave [channel]",
+			Short: "leave a channel, defaults to the current channel",
+			Run:   leave,
+		})
+
+		tctx := &topicCtx{}
+		topic := &command.Command{
+			Use:     "topic text...",
+			Short:   "set the topic of a channel",
+			Example: `channel topic -c war-room investigating elevated error rates`,
+			Run:     tctx.Topic,
+		}
+		tctx.c = topic.Flags().StringP("channel", "c", "", "Channel to set the topic of, defaults to the current channel")
+		root.AddCommand(topic)
+
+		ictx := &inviteCtx{}
+		invite := &command.Command{
+			Use:   "invite user [user...]",
+			Short: "invite users to a channel",
+			Run:   ictx.Invite,
+		}
+		ictx.c = invite.Flags().StringP("channel", "c", "", "Channel to invite users to, defaults to the current channel")
+		root.AddCommand(invite)
+
+		return nil
+	})
+}
+
+// Register installs this handler on bot.DefaultBot
+func Register(rs ...string) {
+	bot.Command(New(rs...))
+}
+
+func manager(ctx context.Context) (hugot.ChannelManager, error) {
+	a, _ := hugot.AdapterFromContext(ctx)
+	cm, ok := a.(hugot.ChannelManager)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return cm, nil
+}
+
+func create(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if len(args) != 1 {
+		return errors.New("you must provide the name of the channel to create")
+	}
+	cm, err := manager(ctx)
+	if err != nil {
+		return err
+	}
+	if err := cm.CreateChannel(args[0]); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "created channel %s", args[0])
+	return nil
+}
+
+func join(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if len(args) != 1 {
+		return errors.New("you must provide the channel to join")
+	}
+	cm, err := manager(ctx)
+	if err != nil {
+		return err
+	}
+	if err := cm.Join(args[0]); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "joined channel %s", args[0])
+	return nil
+}
+
+func leave(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if len(args) > 1 {
+		return errors.New("you can only leave one channel at a time")
+	}
+	ch := m.Channel
+	if len(args) == 1 {
+		ch = args[0]
+	}
+	cm, err := manager(ctx)
+	if err != nil {
+		return err
+	}
+
+	// We may not be able to say anything once we've left.
+	if ch == m.Channel {
+		fmt.Fprint(w, "bye!")
+	}
+	if err := cm.LeaveChannel(ch); err != nil {
+		return err
+	}
+	if ch != m.Channel {
+		fmt.Fprintf(w, "left channel %s", ch)
+	}
+	return nil
+}
+
+type topicCtx struct {
+	c *string
+}
+
+func (tc *topicCtx) Topic(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if len(args) == 0 {
+		return errors.New("you must provide a topic")
+	}
+	ch := *tc.c
+	if ch == "" {
+		ch = m.Channel
+	}
+	cm, err := manager(ctx)
+	if err != nil {
+		return err
+	}
+	if err := cm.SetChannelTopic(ch, strings.Join(args, " ")); err != nil {
+		return err
+	}
+
+	if ch != m.Channel {
+		fmt.Fprintf(w, "set topic of %s", ch)
+	}
+	return nil
+}
+
+type inviteCtx struct {
+	c *string
+}
+
+func (ic *inviteCtx) Invite(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if len(args) == 0 {
+		return errors.New("you must provide the users to invite")
+	}
+	ch := *ic.c
+	if ch == "" {
+		ch = m.Channel
+	}
+	cm, err := manager(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range args {
+		if err := cm.Invite(ch, u); err != nil {
+			return fmt.Errorf("could not invite %s, %v", u, err)
+		}
+	}
+
+	fmt.Fprintf(w, "invited %s to %s", strings.Join(args, ", "), ch)
+	return nil
+}
@@ -0,0 +1,109 @@
+package channel_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/handlers/command/channel"
+	"github.com/tcolgate/hugot/handlers/roles"
+	"github.com/tcolgate/hugot/hugottest"
+)
+
+type testManager struct {
+	*hugottest.Adapter
+	calls []string
+}
+
+func (tm *testManager) Join(c string) error {
+	tm.calls = append(tm.calls, "join "+c)
+	return nil
+}
+
+func (tm *testManager) Invite(c, u string) error {
+	tm.calls = append(tm.calls, fmt.Sprintf("invite %s %s", c, u))
+	return nil
+}
+
+func (tm *testManager) CreateChannel(c string) error {
+	tm.calls = append(tm.calls, "create "+c)
+	return nil
+}
+
+func (tm *testManager) LeaveChannel(c string) error {
+	tm.calls = append(tm.calls, "leave "+c)
+	return nil
+}
+
+func (tm *testManager) SetChannelTopic(c, t string) error {
+	tm.calls = append(tm.calls, fmt.Sprintf("topic %s %s", c, t))
+	return nil
+}
+
+func TestChannel(t *testing.T) {
+	var tests = []struct {
+		text string
+		call string
+	}{
+		{"channel create war-room", "create war-room"},
+		{"channel join war-room", "join war-room"},
+		{"channel leave", "leave ops"},
+		{"channel topic -c war-room errors are up", "topic war-room errors are up"},
+		{"channel topic errors are down", "topic ops errors are down"},
+		{"channel invite -c war-room alice bob", "invite war-room bob"},
+	}
+
+	for _, tt := range tests {
+		out := make(chan hugot.Message, 10)
+		tm := &testManager{Adapter: hugottest.NewAdapter(nil, out)}
+
+		cs := command.Set{}
+		cs.MustAdd(channel.New())
+
+		ctx := hugot.NewAdapterContext(context.Background(), tm)
+		ctx = hugot.NewRolesContext(ctx, map[string]struct{}{roles.Admin: {}})
+
+		m := &hugot.Message{Text: tt.text, Channel: "ops", From: "alice", ToBot: true}
+		if err := cs.ProcessMessage(ctx, hugot.NewResponseWriter(tm, *m, "test"), m); err != nil {
+			t.Fatalf("%q: unexpected error %v", tt.text, err)
+		}
+		if len(tm.calls) == 0 || tm.calls[len(tm.calls)-1] != tt.call {
+			t.Fatalf("%q: expected %q, got %v", tt.text, tt.call, tm.calls)
+		}
+	}
+}
+
+func TestChannel_Denied(t *testing.T) {
+	out := make(chan hugot.Message, 10)
+	tm := &testManager{Adapter: hugottest.NewAdapter(nil, out)}
+
+	cs := command.Set{}
+	cs.MustAdd(channel.New("incident"))
+
+	ctx := hugot.NewAdapterContext(context.Background(), tm)
+	m := &hugot.Message{Text: "channel join war-room", Channel: "ops", From: "bob", ToBot: true}
+	err := cs.ProcessMessage(ctx, hugot.NewResponseWriter(tm, *m, "test"), m)
+
+	var perr *command.PermissionError
+	if !errors.As(err, &perr) || len(tm.calls) != 0 {
+		t.Fatalf("expected permission error, got %v, calls %v", err, tm.calls)
+	}
+}
+
+func TestChannel_NotSupported(t *testing.T) {
+	out := make(chan hugot.Message, 10)
+	a := hugottest.NewAdapter(nil, out)
+
+	cs := command.Set{}
+	cs.MustAdd(channel.New())
+
+	ctx := hugot.NewAdapterContext(context.Background(), a)
+	ctx = hugot.NewRolesContext(ctx, map[string]struct{}{roles.Admin: {}})
+	m := &hugot.Message{Text: "channel join war-room", Channel: "ops", From: "alice", ToBot: true}
+	if err := cs.ProcessMessage(ctx, hugot.NewResponseWriter(a, *m, "test"), m); err != channel.ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
@@ -6,14 +6,17 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"regexp"
 	"sort"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	shellwords "github.com/mattn/go-shellwords"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/metrics"
 )
 
 type ctxKey int
@@ -57,6 +60,15 @@ type Setupper interface {
 type RunFunc func(ctx context.Context, w hugot.ResponseWriter, msg *hugot.Message, args []string) error
 type cobraFuncE func(*cobra.Command, []string) error
 
+// linkPat matches user and channel links, such as slack's <@U123|bob>,
+// which the shell parser would otherwise treat as redirections.
+var linkPat = regexp.MustCompile(`<[@#!][^<>\s'"]*>`)
+
+// parseArgs splits txt into command line arguments.
+func parseArgs(txt string) ([]string, error) {
+	return shellwords.Parse(linkPat.ReplaceAllString(txt, "'$0'"))
+}
+
 // Set is a collection of command to be run by a mux.Mux
 type Set map[string]*Handler
 
@@ -70,7 +82,7 @@ func (cs Set) ProcessMessage(ctx context.Context, w hugot.ResponseWriter, m *hug
 	var err error
 	var args []string
 
-	if args, err = shellwords.Parse(m.Text); err != nil {
+	if args, err = parseArgs(m.Text); err != nil {
 		args = strings.Split(m.Text, " ")
 	}
 	if len(args) == 0 {
@@ -96,6 +108,7 @@ func (cs Set) ProcessMessage(ctx context.Context, w hugot.ResponseWriter, m *hug
 	}
 
 	m.Text = strings.Join(args[1:], " ")
+	ctx = hugot.NewLoggerContext(ctx, hugot.LoggerFromContext(ctx).With(hugot.LogHandler, names[0]))
 	return matches[0].ProcessMessage(ctx, w, m)
 }
 
@@ -107,8 +120,9 @@ func (cs Set) MustAdd(c Setupper) {
 	cs[cob.Name()] = &Handler{c}
 }
 
-// Help implements mux.Helper for the command.CommandSet
-func (cs Set) Help(w io.Writer) error {
+// Help implements mux.Helper for the command.CommandSet. Commands the
+// user does not have the roles to run are not listed.
+func (cs Set) Help(ctx context.Context, w io.Writer) error {
 	out := &bytes.Buffer{}
 
 	tw := new(tabwriter.Writer)
@@ -129,10 +143,10 @@ func (cs Set) Help(w io.Writer) error {
 	for _, cn := range cns {
 		root := &Command{}
 		err := cs[cn].CommandSetup(root)
-		if err != nil {
+		if err != nil || !root.Permitted(ctx) {
 			continue
 		}
-		cob := root.cmdToCobra(context.TODO(), nil, nil)
+		cob := root.cmdToCobra(ctx, nil, nil)
 
 		fmt.Fprintf(tw, "  %s\t - %s\n", cob.Name(), cob.Short)
 	}
@@ -160,6 +174,13 @@ type Command struct {
 	SilenceErrors     bool
 	SilenceUsage      bool
 
+	// AnyRoles, if set, requires the user to hold at least one of the
+	// listed roles to run this command, or any of its subcommands.
+	AnyRoles []string
+	// AllRoles, if set, requires the user to hold every one of the
+	// listed roles to run this command, or any of its subcommands.
+	AllRoles []string
+
 	flags  *pflag.FlagSet
 	pflags *pflag.FlagSet
 
@@ -190,6 +211,29 @@ func (cmd *Command) AddCommand(scmd *Command) {
 	cmd.subcommands = append(cmd.subcommands, scmd)
 }
 
+// Permitted checks the roles held by the user, as found in ctx, against
+// the AnyRoles and AllRoles requirements of the command. Requirements
+// of any parent commands are not checked.
+func (cmd *Command) Permitted(ctx context.Context) bool {
+	roles := hugot.RolesFromContext(ctx)
+
+	for _, r := range cmd.AllRoles {
+		if _, ok := roles[r]; !ok {
+			return false
+		}
+	}
+
+	if len(cmd.AnyRoles) == 0 {
+		return true
+	}
+	for _, r := range cmd.AnyRoles {
+		if _, ok := roles[r]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // SetupFunc takes a Command and is expected to configure it
 // to provide some command functionality
 type SetupFunc func(*Command) error
@@ -215,31 +259,73 @@ func (h *Handler) ProcessMessage(ctx context.Context, w hugot.ResponseWriter, m
 	root := &Command{cob: &cobra.Command{}}
 	h.CommandSetup(root)
 
-	args, err := shellwords.Parse(m.Text)
+	args, err := parseArgs(m.Text)
 	if err != nil {
 		return ErrBadCLI
 	}
 
-	cob := root.cmdToCobra(ctx, w, m)
+	cmds := map[*cobra.Command]*Command{}
+	cob := root.buildCobra(ctx, w, m, cmds)
 	cob.SetOutput(w)
 	cob.SetArgs(args)
 
-	for _, c := range root.subcommands {
-		cob.AddCommand(c.cmdToCobra(ctx, w, m))
+	name := cob.Name()
+
+	// Refuse to run the command if the user lacks the roles required
+	// by it, or by any of its parents. If we cannot tell which command
+	// will be run, the user must at least be permitted to run the root.
+	target := cob
+	if c, _, err := cob.Find(args); err == nil {
+		target = c
+		name = target.CommandPath()
+	}
+	for c := target; c != nil; c = c.Parent() {
+		if cmd, ok := cmds[c]; ok && !cmd.Permitted(ctx) {
+			metrics.CommandsExecuted.WithLabelValues(name, "denied").Inc()
+			return &PermissionError{
+				Command:  name,
+				AnyRoles: cmd.AnyRoles,
+				AllRoles: cmd.AllRoles,
+			}
+		}
 	}
 
-	return cob.Execute()
+	start := time.Now()
+	err = cob.Execute()
+	metrics.HandlerDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+
+	result := "ok"
+	if err != nil && err != ErrSkipHears {
+		result = "error"
+	}
+	metrics.CommandsExecuted.WithLabelValues(name, result).Inc()
+
+	return err
 }
 
 // Help implements mux.Helper for the command.Handler
-func (h *Handler) Help(w io.Writer) error {
+func (h *Handler) Help(ctx context.Context, w io.Writer) error {
 	root := &Command{}
 	h.CommandSetup(root)
 
-	cob := root.cmdToCobra(context.TODO(), nil, nil)
+	cob := root.buildCobra(ctx, nil, nil, map[*cobra.Command]*Command{})
 	cob.SetOutput(w)
 
-	return root.cob.Help()
+	return cob.Help()
+}
+
+// buildCobra converts cmd, and all of its subcommands, to cobra commands.
+// Each cobra command created is recorded in cmds against the Command it
+// was built from.
+func (cmd *Command) buildCobra(ctx context.Context, w hugot.ResponseWriter, msg *hugot.Message, cmds map[*cobra.Command]*Command) *cobra.Command {
+	cob := cmd.cmdToCobra(ctx, w, msg)
+	cmds[cob] = cmd
+
+	for _, c := range cmd.subcommands {
+		cob.AddCommand(c.buildCobra(ctx, w, msg, cmds))
+	}
+
+	return cob
 }
 
 func (cmd *Command) cmdToCobra(ctx context.Context, w hugot.ResponseWriter, msg *hugot.Message) *cobra.Command {
@@ -250,6 +336,7 @@ func (cmd *Command) cmdToCobra(ctx context.Context, w hugot.ResponseWriter, msg
 		Example:       cmd.Example,
 		SilenceErrors: cmd.SilenceErrors,
 		SilenceUsage:  cmd.SilenceUsage,
+		Hidden:        !cmd.Permitted(ctx),
 	}
 	cob.PersistentPreRunE = cmd.PersistentPreRun.makeCobraRunEFunc(ctx, cmd, w, msg)
 	cob.PreRunE = cmd.PreRun.makeCobraRunEFunc(ctx, cmd, w, msg)
@@ -0,0 +1,130 @@
+package command_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/hugottest"
+)
+
+func testSet(ran *bool) command.Set {
+	cs := command.Set{}
+	cs.MustAdd(command.NewFunc(func(root *command.Command) error {
+		root.Use = "deploy"
+		root.Short = "deploy things"
+		root.AnyRoles = []string{"deployer", "admin"}
+		root.Run = func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+			*ran = true
+			return nil
+		}
+		root.AddCommand(&command.Command{
+			Use:      "prod",
+			Short:    "deploy to production",
+			AllRoles: []string{"prod"},
+			Run: func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+				*ran = true
+				return nil
+			},
+		})
+		return nil
+	}))
+	cs.MustAdd(command.NewFunc(func(root *command.Command) error {
+		root.Use = "ping"
+		root.Short = "ping the bot"
+		root.Run = func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+			return nil
+		}
+		return nil
+	}))
+	return cs
+}
+
+func TestCommand_Permissions(t *testing.T) {
+	var tests = []struct {
+		roles   []string
+		text    string
+		allowed bool
+	}{
+		{nil, "deploy", false},
+		{[]string{"deployer"}, "deploy", true},
+		{[]string{"admin"}, "deploy", true},
+		{[]string{"deployer"}, "deploy prod", false},
+		{[]string{"prod"}, "deploy prod", false},
+		{[]string{"deployer", "prod"}, "deploy prod", true},
+		{nil, "deploy nosuch", false},
+	}
+
+	for _, tt := range tests {
+		ran := false
+		cs := testSet(&ran)
+
+		rs := map[string]struct{}{}
+		for _, r := range tt.roles {
+			rs[r] = struct{}{}
+		}
+		ctx := hugot.NewRolesContext(context.Background(), rs)
+
+		rr := &hugottest.ResponseRecorder{MessagesOut: make(chan hugot.Message, 10)}
+		m := &hugot.Message{Text: tt.text, ToBot: true}
+		err := cs.ProcessMessage(ctx, hugot.NewResponseWriter(rr, *m, "test"), m)
+
+		var perr *command.PermissionError
+		if tt.allowed && (err != nil || !ran) {
+			t.Errorf("%q with roles %v: expected command to run, err = %v", tt.text, tt.roles, err)
+		}
+		if !tt.allowed && (!errors.As(err, &perr) || ran) {
+			t.Errorf("%q with roles %v: expected permission error, err = %v", tt.text, tt.roles, err)
+		}
+	}
+}
+
+func TestSet_HelpHidesForbidden(t *testing.T) {
+	ran := false
+	cs := testSet(&ran)
+
+	out := &bytes.Buffer{}
+	cs.Help(context.Background(), out)
+	if strings.Contains(out.String(), "deploy") {
+		t.Fatalf("expected deploy to be hidden from help, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "ping") {
+		t.Fatalf("expected ping to be in help, got %q", out.String())
+	}
+
+	ctx := hugot.NewRolesContext(context.Background(), map[string]struct{}{"deployer": {}})
+	out.Reset()
+	cs.Help(ctx, out)
+	if !strings.Contains(out.String(), "deploy") {
+		t.Fatalf("expected deploy to be listed in help, got %q", out.String())
+	}
+}
+
+func TestCommand_Links(t *testing.T) {
+	var got []string
+	cs := command.Set{}
+	cs.MustAdd(command.NewFunc(func(root *command.Command) error {
+		root.Use = "echo"
+		root.Run = func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+			got = args
+			return nil
+		}
+		return nil
+	}))
+
+	rr := &hugottest.ResponseRecorder{MessagesOut: make(chan hugot.Message, 10)}
+	m := &hugot.Message{Text: "echo <@U123|bob> <#C456> done", ToBot: true}
+	if err := cs.ProcessMessage(context.Background(), hugot.NewResponseWriter(rr, *m, "test"), m); err != nil {
+		t.Fatalf("echo failed, %v", err)
+	}
+
+	exp := []string{"<@U123|bob>", "<#C456>", "done"}
+	if fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Fatalf("expected args %q, got %q", exp, got)
+	}
+}
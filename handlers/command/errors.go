@@ -1,6 +1,10 @@
 package command
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	// ErrSkipHears suggests that a handler has dealt with a command,
@@ -33,3 +37,23 @@ func (e errUsage) Error() string {
 func ErrUsage(s string) error {
 	return errUsage(s)
 }
+
+// PermissionError is returned when a user does not hold the roles
+// required to run a command.
+type PermissionError struct {
+	Command  string
+	AnyRoles []string
+	AllRoles []string
+}
+
+// Error implements the Error interface for a PermissionError.
+func (e *PermissionError) Error() string {
+	var reqs []string
+	if len(e.AllRoles) > 0 {
+		reqs = append(reqs, fmt.Sprintf("all of the roles %s", strings.Join(e.AllRoles, ", ")))
+	}
+	if len(e.AnyRoles) > 0 {
+		reqs = append(reqs, fmt.Sprintf("one of the roles %s", strings.Join(e.AnyRoles, ", ")))
+	}
+	return fmt.Sprintf("permission denied, %s requires %s", e.Command, strings.Join(reqs, " and "))
+}
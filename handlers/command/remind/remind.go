@@ -0,0 +1,329 @@
+// Package remind provides a command for setting reminders. Pending
+// reminders are persisted in the store, and delivered by a background
+// handler, so they survive restarts of the bot.
+package remind
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/storage"
+	"github.com/tcolgate/hugot/storage/prefix"
+)
+
+// Reminder is a message to be delivered at a given time.
+type Reminder struct {
+	At      time.Time `json:"at"`
+	Channel string    `json:"channel"`
+	To      string    `json:"to,omitempty"`
+	From    string    `json:"from"`
+	Text    string    `json:"text"`
+}
+
+// Reminders implements the remind command, and a background handler
+// that delivers reminders once they are due.
+type Reminders struct {
+	seq  uint64
+	s    storage.Storer
+	wake chan struct{}
+}
+
+// New creates a reminders handler, pending reminders are kept in s.
+func New(s storage.Storer) *Reminders {
+	return &Reminders{
+		s:    prefix.New(s, []string{"reminders"}),
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Describe implements the Describer interface for Reminders.
+func (r *Reminders) Describe() (string, string) {
+	return "remind", "delivers reminders"
+}
+
+// Add stores a new reminder.
+func (r *Reminders) Add(rem Reminder) error {
+	bs, err := json.Marshal(rem)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("%s-%d", strconv.FormatInt(time.Now().UnixNano(), 36), atomic.AddUint64(&r.seq, 1))
+	if err := r.s.Set([]string{id}, string(bs)); err != nil {
+		return err
+	}
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pending returns all stored reminders, by id.
+func (r *Reminders) pending(ctx context.Context) (map[string]Reminder, error) {
+	keys, err := r.s.List([]string{})
+	if err != nil {
+		return nil, err
+	}
+
+	rems := map[string]Reminder{}
+	for _, k := range keys {
+		v, ok, err := r.s.Get(k)
+		if err != nil || !ok {
+			continue
+		}
+		var rem Reminder
+		if err := json.Unmarshal([]byte(v), &rem); err != nil {
+			hugot.LoggerFromContext(ctx).Error("discarding unparsable reminder", "key", k, "error", err)
+			r.s.Unset(k)
+			continue
+		}
+		rems[k[0]] = rem
+	}
+	return rems, nil
+}
+
+// StartBackground implements hugot.BackgroundHandler. Due reminders are
+// delivered via a ResponseWriter for the bot's adapter.
+func (r *Reminders) StartBackground(ctx context.Context, w hugot.ResponseWriter) {
+	if cw, ok := hugot.ResponseWriterFromContext(ctx); ok {
+		w = cw
+	}
+
+	for {
+		next := r.deliver(ctx, w, time.Now())
+
+		var t *time.Timer
+		var tc <-chan time.Time
+		if !next.IsZero() {
+			t = time.NewTimer(time.Until(next))
+			tc = t.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-r.wake:
+		case <-tc:
+		}
+
+		if t != nil {
+			t.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// deliver sends any reminders due at now, and returns the time the
+// next reminder is due.
+func (r *Reminders) deliver(ctx context.Context, w hugot.ResponseWriter, now time.Time) time.Time {
+	rems, err := r.pending(ctx)
+	if err != nil {
+		hugot.LoggerFromContext(ctx).Error("could not list reminders", "error", err)
+		return now.Add(time.Minute)
+	}
+
+	var next time.Time
+	for id, rem := range rems {
+		if rem.At.After(now) {
+			if next.IsZero() || rem.At.Before(next) {
+				next = rem.At
+			}
+			continue
+		}
+
+		if err := r.s.Unset([]string{id}); err != nil {
+			hugot.LoggerFromContext(ctx).Error("could not remove reminder", "reminder", id, "error", err)
+			continue
+		}
+
+		rw := w.Copy()
+		rw.SetChannel(rem.Channel)
+		rw.SetTo(rem.To)
+		if rem.To != "" {
+			fmt.Fprintf(rw, "%s, reminder: %s", rem.To, rem.Text)
+		} else {
+			fmt.Fprintf(rw, "reminder from %s: %s", rem.From, rem.Text)
+		}
+	}
+	return next
+}
+
+// CommandSetup implements command.Setupper for the remind command.
+// Channels are given by ID, or, on adapters that implement
+// hugot.Directory, by name.
+func (r *Reminders) CommandSetup(root *command.Command) error {
+	root.Use = "remind (me|channel) (in duration|at time) [to] message..."
+	root.Short = "set a reminder"
+	root.Example = "remind me in 2h to check the deploy\nremind #ops at 09:00 standup"
+
+	rctx := &remindCtx{r: r}
+	rctx.l = root.Flags().BoolP("list", "l", false, "List your pending reminders")
+	root.Run = rctx.Command
+
+	return nil
+}
+
+type remindCtx struct {
+	r *Reminders
+	l *bool
+}
+
+func (rc *remindCtx) Command(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if *rc.l {
+		return rc.list(ctx, w, m)
+	}
+
+	if len(args) < 4 {
+		return command.ErrUsage("remind (me|channel) (in duration|at time) [to] message...")
+	}
+
+	rem := Reminder{
+		Channel: m.Channel,
+		From:    m.From,
+	}
+	if args[0] == "me" {
+		rem.To = m.From
+	} else {
+		ch, err := hugot.ResolveChannel(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		rem.Channel = ch
+	}
+
+	at, rest, err := parseWhen(args[1:], time.Now())
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 && rest[0] == "to" {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return errors.New("what should I remind you of?")
+	}
+
+	rem.At = at
+	rem.Text = strings.Join(rest, " ")
+
+	if err := rc.r.Add(rem); err != nil {
+		return err
+	}
+
+	who := args[0]
+	if who == "me" {
+		who = "you"
+	}
+	fmt.Fprintf(w, "ok, I'll remind %s at %s", who, at.Format(time.RFC822))
+	return nil
+}
+
+func (rc *remindCtx) list(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+	rems, err := rc.r.pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	var mine []Reminder
+	for _, rem := range rems {
+		if rem.From == m.From {
+			mine = append(mine, rem)
+		}
+	}
+	if len(mine) == 0 {
+		fmt.Fprint(w, "You have no pending reminders")
+		return nil
+	}
+	sort.Slice(mine, func(i, j int) bool { return mine[i].At.Before(mine[j].At) })
+
+	out := &bytes.Buffer{}
+	tw := new(tabwriter.Writer)
+	tw.Init(out, 0, 8, 1, '\t', 0)
+	for _, rem := range mine {
+		fmt.Fprintf(tw, "  %s\t%s\t - %s\n", rem.At.Format(time.RFC822), rem.Channel, rem.Text)
+	}
+	tw.Flush()
+
+	io.Copy(w, out)
+	return nil
+}
+
+// parseWhen parses "in <duration>", "at HH:MM", or "at YYYY-MM-DD HH:MM"
+// from the start of args, returning the time and the remaining arguments.
+func parseWhen(args []string, now time.Time) (time.Time, []string, error) {
+	if len(args) < 2 {
+		return time.Time{}, nil, errors.New("expected in <duration> or at <time>")
+	}
+
+	switch args[0] {
+	case "in":
+		d, err := parseDuration(args[1])
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		if d <= 0 {
+			return time.Time{}, nil, errors.New("reminders must be in the future")
+		}
+		return now.Add(d), args[2:], nil
+	case "at":
+		if len(args) > 2 {
+			if t, err := time.ParseInLocation("2006-01-02 15:04", args[1]+" "+args[2], now.Location()); err == nil {
+				if !t.After(now) {
+					return time.Time{}, nil, errors.New("reminders must be in the future")
+				}
+				return t, args[3:], nil
+			}
+		}
+		t, err := time.ParseInLocation("15:04", args[1], now.Location())
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("could not understand time %q, use HH:MM or YYYY-MM-DD HH:MM", args[1])
+		}
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, args[2:], nil
+	default:
+		return time.Time{}, nil, fmt.Errorf("expected in or at, got %q", args[0])
+	}
+}
+
+// parseDuration extends time.ParseDuration to accept a number of days.
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("could not understand duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("could not understand duration %q", s)
+	}
+	return d, nil
+}
+
+// Register installs the remind command, and its background handler,
+// on bot.DefaultBot.
+func Register() {
+	r := New(bot.DefaultBot.Mux.Store())
+	bot.Command(command.New(r))
+	bot.Background(r)
+}
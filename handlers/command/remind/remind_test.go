@@ -0,0 +1,147 @@
+package remind
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/hugottest"
+	"github.com/tcolgate/hugot/storage/memory"
+)
+
+func TestParseWhen(t *testing.T) {
+	now := time.Date(2020, time.June, 1, 10, 30, 0, 0, time.UTC)
+
+	var tests = []struct {
+		args []string
+		at   time.Time
+		rest int
+	}{
+		{[]string{"in", "2h", "to", "x"}, now.Add(2 * time.Hour), 2},
+		{[]string{"in", "1d", "x"}, now.Add(24 * time.Hour), 1},
+		{[]string{"at", "11:00", "x"}, time.Date(2020, time.June, 1, 11, 0, 0, 0, time.UTC), 1},
+		{[]string{"at", "09:00", "x"}, time.Date(2020, time.June, 2, 9, 0, 0, 0, time.UTC), 1},
+		{[]string{"at", "2020-07-01", "09:00", "x"}, time.Date(2020, time.July, 1, 9, 0, 0, 0, time.UTC), 1},
+	}
+
+	for _, tt := range tests {
+		at, rest, err := parseWhen(tt.args, now)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.args, err)
+			continue
+		}
+		if !at.Equal(tt.at) || len(rest) != tt.rest {
+			t.Errorf("%v: expected %v with %d remaining, got %v with %v", tt.args, tt.at, tt.rest, at, rest)
+		}
+	}
+
+	for _, args := range [][]string{{"in", "soon"}, {"at", "9am"}, {"on", "monday"}, {"in", "-1h"}} {
+		if _, _, err := parseWhen(args, now); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestReminders_Deliver(t *testing.T) {
+	r := New(memory.New())
+	now := time.Now()
+	r.Add(Reminder{At: now.Add(-time.Second), Channel: "ops", To: "bob", Text: "check deploy"})
+	r.Add(Reminder{At: now.Add(time.Hour), Channel: "ops", Text: "later"})
+
+	out := make(chan hugot.Message, 2)
+	w := hugot.NewResponseWriter(&hugottest.ResponseRecorder{MessagesOut: out}, hugot.Message{}, "test")
+
+	next := r.deliver(context.Background(), w, now)
+	if !next.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected next reminder in an hour, got %v", next)
+	}
+
+	select {
+	case m := <-out:
+		if m.Channel != "ops" || m.Text != "bob, reminder: check deploy" {
+			t.Fatalf("unexpected reminder %#v", m)
+		}
+	default:
+		t.Fatal("expected a reminder to be delivered")
+	}
+
+	if rems, _ := r.pending(context.Background()); len(rems) != 1 {
+		t.Fatalf("expected 1 pending reminder, got %v", rems)
+	}
+}
+
+func TestReminders_Command(t *testing.T) {
+	cs := command.Set{}
+	cs.MustAdd(New(memory.New()))
+
+	var tests = []struct {
+		text, exp string
+	}{
+		{"remind me in 1h to check deploy", "ok, I'll remind you at "},
+		{"remind ops in 1h to check deploy", "ok, I'll remind ops at "},
+	}
+	for _, tt := range tests {
+		out := make(chan hugot.Message, 1)
+		m := &hugot.Message{Text: tt.text, From: "bob", Channel: "dev", ToBot: true}
+		w := hugot.NewResponseWriter(&hugottest.ResponseRecorder{MessagesOut: out}, *m, "test")
+		if err := cs.ProcessMessage(context.Background(), w, m); err != nil {
+			t.Fatalf("%q failed, %v", tt.text, err)
+		}
+		if r := <-out; !strings.HasPrefix(r.Text, tt.exp) {
+			t.Errorf("%q: expected reply starting %q, got %q", tt.text, tt.exp, r.Text)
+		}
+	}
+}
+
+type testDirectory struct {
+	*hugottest.ResponseRecorder
+}
+
+func (td *testDirectory) Receive() <-chan *hugot.Message {
+	return nil
+}
+
+func (td *testDirectory) UserID(u string) (string, error) {
+	return u, nil
+}
+
+func (td *testDirectory) ChannelID(c string) (string, error) {
+	if c == "#ops" {
+		return "C1", nil
+	}
+	return "", fmt.Errorf("unknown channel %q", c)
+}
+
+func TestReminders_CommandChannel(t *testing.T) {
+	r := New(memory.New())
+	cs := command.Set{}
+	cs.MustAdd(r)
+
+	rr := &hugottest.ResponseRecorder{MessagesOut: make(chan hugot.Message, 10)}
+	ctx := hugot.NewAdapterContext(context.Background(), &testDirectory{rr})
+	send := func(txt string) error {
+		m := &hugot.Message{Text: txt, From: "bob", Channel: "C2", ToBot: true}
+		return cs.ProcessMessage(ctx, hugot.NewResponseWriter(rr, *m, "test"), m)
+	}
+
+	if err := send("remind #nosuch in 1h to check deploy"); err == nil {
+		t.Fatalf("expected reminder for an unknown channel to fail")
+	}
+	if err := send("remind #ops in 1h to check deploy"); err != nil {
+		t.Fatalf("remind failed, %v", err)
+	}
+
+	rems, _ := r.pending(ctx)
+	if len(rems) != 1 {
+		t.Fatalf("expected 1 pending reminder, got %v", rems)
+	}
+	for _, rem := range rems {
+		if rem.Channel != "C1" {
+			t.Fatalf("expected reminder to be sent to C1, got %q", rem.Channel)
+		}
+	}
+}
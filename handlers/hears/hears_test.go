@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/bot"
 	"github.com/tcolgate/hugot/handlers/mux"
 	"github.com/tcolgate/hugot/hugottest"
 )
@@ -40,7 +41,7 @@ func TestHears_Match(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go hugot.ListenAndServe(ctx, mx, ta)
+	go bot.New().ListenAndServe(ctx, mx, ta)
 
 	ta.MessagesIn <- &hugot.Message{Text: "testing testing 123"}
 	select {
@@ -65,7 +66,7 @@ func TestHears_NoMatch(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go hugot.ListenAndServe(ctx, mx, ta)
+	go bot.New().ListenAndServe(ctx, mx, ta)
 
 	ta.MessagesIn <- &hugot.Message{Text: "123"}
 	select {
@@ -19,6 +19,7 @@ package mux
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -26,11 +27,12 @@ import (
 	"regexp"
 	"sync"
 	"text/tabwriter"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/tcolgate/hugot"
 	"github.com/tcolgate/hugot/handlers/command"
 	"github.com/tcolgate/hugot/handlers/hears"
+	"github.com/tcolgate/hugot/metrics"
 	"github.com/tcolgate/hugot/storage"
 	"github.com/tcolgate/hugot/storage/memory"
 	"github.com/tcolgate/hugot/storage/prefix"
@@ -90,20 +92,36 @@ func WithStore(s storage.Storer) Opt {
 	}
 }
 
+// Store returns the store used by the Mux. Handlers added to the mux
+// should use prefix.New to store their data under their own key.
+func (mx *Mux) Store() storage.Storer {
+	return mx.store
+}
+
 // Describe implements the Describe method of Handler for
 // the Mux
 func (mx *Mux) Describe() (string, string) {
 	return mx.name, mx.desc
 }
 
-// StartBackground starts any registered background handlers.
+// StartBackground starts any registered background handlers, and
+// returns once they have all returned.
 func (mx *Mux) StartBackground(ctx context.Context, w hugot.ResponseWriter) {
-	mx.Lock()
-	defer mx.Unlock()
+	mx.RLock()
+	hs := append([]hugot.BackgroundHandler{}, mx.BGHandlers...)
+	mx.RUnlock()
 
-	for _, h := range mx.BGHandlers {
-		go h.StartBackground(ctx, w.Copy())
+	wg := sync.WaitGroup{}
+	for _, h := range hs {
+		wg.Add(1)
+		go func(h hugot.BackgroundHandler) {
+			defer wg.Done()
+			n, _ := h.Describe()
+			ctx := withHandlerLogger(ctx, n)
+			h.StartBackground(ctx, w.Copy())
+		}(h)
 	}
+	wg.Wait()
 }
 
 // SetAdapter sets the adapter on all the webhook of this mux.
@@ -122,34 +140,62 @@ func (mx *Mux) SetAdapter(a hugot.Adapter) {
 // Then, if appropriate, the message will be matched against any Hears patterns
 // and all matching Heard functions will then be called.
 // Any unrecognized errors from the Command handlers will be passed back to the
-// user that sent us the message.
+// user that sent us the message. The first error returned by the command
+// or Hears handlers is returned, an unknown command is not an error if
+// any Hears handler matched the message.
 func (mx *Mux) ProcessMessage(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+	// Handlers may add further handlers to the mux, so we must not hold
+	// the lock while they run.
 	mx.RLock()
-	defer mx.RUnlock()
+	raws := append([]hugot.Handler{}, mx.RawHandlers...)
+	toBot := mx.ToBot
+	var hhs []hears.Hearer
+	for _, hs := range mx.HearsHandlers {
+		hhs = append(hhs, hs...)
+	}
+	store := mx.store
+	mx.RUnlock()
+
 	var err error
 
-	// We run all raw message handlers
-	for _, rh := range mx.RawHandlers {
+	// We run all raw message handlers, and wait for them to complete
+	// before returning.
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	for _, rh := range raws {
 		nm := m.Copy()
-		go rh.ProcessMessage(ctx, w, nm)
+		wg.Add(1)
+		go func(rh hugot.Handler) {
+			defer wg.Done()
+			hn, _ := rh.Describe()
+			start := time.Now()
+			rh.ProcessMessage(withHandlerLogger(ctx, hn), w, nm)
+			metrics.HandlerDuration.WithLabelValues(hn).Observe(time.Since(start).Seconds())
+		}(rh)
 	}
 
 	if m.ToBot && m.Text != "" {
 		nm := m.Copy()
-		err = mx.ToBot.ProcessMessage(ctx, w, nm)
+		err = toBot.ProcessMessage(ctx, w, nm)
 	}
 
 	if err == command.ErrSkipHears {
 		return nil
 	}
 
-	for _, hhs := range mx.HearsHandlers {
-		for _, hh := range hhs {
-			if ms := hh.Hears().FindAllStringSubmatch(m.Text, -1); ms != nil {
-				nm := m.Copy()
-				hn, _ := hh.Describe()
-				nm.Store = prefix.New(mx.store, []string{hn})
-				err = hh.Heard(ctx, w, nm, ms)
+	for _, hh := range hhs {
+		if ms := hh.Hears().FindAllStringSubmatch(m.Text, -1); ms != nil {
+			nm := m.Copy()
+			hn, _ := hh.Describe()
+			nm.Store = prefix.New(store, []string{hn})
+			metrics.HearsMatched.WithLabelValues(hn, hh.Hears().String()).Inc()
+			start := time.Now()
+			herr := hh.Heard(withHandlerLogger(ctx, hn), w, nm, ms)
+			metrics.HandlerDuration.WithLabelValues(hn).Observe(time.Since(start).Seconds())
+			// Text that was not a command, but was heard, has
+			// been dealt with.
+			if err == nil || errors.Is(err, command.ErrUnknownCommand) {
+				err = herr
 			}
 		}
 	}
@@ -157,6 +203,11 @@ func (mx *Mux) ProcessMessage(ctx context.Context, w hugot.ResponseWriter, m *hu
 	return err
 }
 
+// withHandlerLogger adds the handler name to the logger in ctx.
+func withHandlerLogger(ctx context.Context, n string) context.Context {
+	return hugot.NewLoggerContext(ctx, hugot.LoggerFromContext(ctx).With(hugot.LogHandler, n))
+}
+
 // Raw adds the provided handlers to the Mux. All
 // messages sent to the mux will be forwarded to this handler.
 func (mx *Mux) Raw(hs ...hugot.Handler) error {
@@ -198,9 +249,7 @@ type webHookBridge struct {
 }
 
 func (whb *webHookBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if glog.V(2) {
-		glog.Infof("webHookBridge ServeHTTP %v %s\n", *whb, *r)
-	}
+	hugot.LoggerFromContext(r.Context()).Debug("webhook request", "url", r.URL)
 	whb.nh.ServeHTTP(w, r)
 }
 
@@ -216,9 +265,7 @@ func (mx *Mux) HandleHTTP(h hugot.WebHookHandler) {
 	p := fmt.Sprintf("/%s/%s", mx.name, n)
 	mx.httpm.Handle(p, h)
 	mx.httpm.Handle(p+"/", h)
-	if glog.V(2) {
-		glog.Infof("registering %v at %s, on %v\n", h, p, mx.httpm)
-	}
+	hugot.DefaultLogger.Debug("registering webhook", hugot.LogHandler, n, "path", p)
 	mx.Webhooks[n] = h
 
 	mu := mx.url()
@@ -230,9 +277,7 @@ func (mx *Mux) HandleHTTP(h hugot.WebHookHandler) {
 // ServeHTTP iplements http.ServeHTTP for a Mux to allow it to
 // act as a web server.
 func (mx *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if glog.V(2) {
-		glog.Infof("Mux ServeHTTP %s\n", *r)
-	}
+	hugot.LoggerFromContext(r.Context()).Debug("mux request", hugot.LogHandler, mx.name, "url", r.URL)
 	mx.httpm.ServeHTTP(w, r)
 }
 
@@ -262,8 +307,11 @@ func (mx *Mux) SetURL(b *url.URL) {
 	}
 }
 
+// Helper is implemented by handlers that can describe their usage. The
+// context is that of the message requesting help, and can be used to
+// tailor the output to the user, e.g. to hide commands they cannot run.
 type Helper interface {
-	Help(io.Writer) error
+	Help(ctx context.Context, w io.Writer) error
 }
 
 func (mx *Mux) cmdHelp(w io.Writer, args []string) error {
@@ -289,7 +337,7 @@ func (mx *Mux) CommandSetup(cmd *command.Command) error {
 		tw.Init(out, 0, 8, 1, '\t', 0)
 
 		if hh, ok := mx.ToBot.(Helper); ok {
-			hh.Help(tw)
+			hh.Help(ctx, tw)
 		}
 
 		if len(mx.HearsHandlers) > 0 {
@@ -0,0 +1,65 @@
+package mux_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/handlers/basic"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/handlers/hears"
+	"github.com/tcolgate/hugot/handlers/mux"
+)
+
+func TestMux_RawHandlerAddsHandler(t *testing.T) {
+	mx := mux.New("test", "test mux")
+	mx.Raw(basic.New("adder", "adds a handler", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		return mx.Raw(basic.New("added", "was added", func(context.Context, hugot.ResponseWriter, *hugot.Message) error {
+			return nil
+		}))
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		m := &hugot.Message{Text: "hello"}
+		done <- mx.ProcessMessage(context.Background(), hugot.NewNullResponseWriter(*m), m)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deadlocked adding a handler from a raw handler")
+	}
+}
+
+func TestMux_ToBotHeard(t *testing.T) {
+	var tests = []struct {
+		text  string
+		heard error
+		exp   error
+	}{
+		{"tableflip", nil, nil},
+		{"tableflip", errors.New("table too heavy"), errors.New("table too heavy")},
+		{"deploy", nil, command.ErrUnknownCommand},
+	}
+	for _, tt := range tests {
+		mx := mux.New("test", "test mux")
+		mx.ToBot = command.Set{}
+		mx.Hears(hears.New("flipper", "flips tables", regexp.MustCompile(`tableflip`),
+			func(context.Context, hugot.ResponseWriter, *hugot.Message, [][]string) error {
+				return tt.heard
+			}))
+
+		m := &hugot.Message{Text: tt.text, ToBot: true}
+		err := mx.ProcessMessage(context.Background(), hugot.NewNullResponseWriter(*m), m)
+		if (err == nil) != (tt.exp == nil) || (err != nil && err.Error() != tt.exp.Error()) {
+			t.Errorf("%q returned %v, expected %v", tt.text, err, tt.exp)
+		}
+	}
+}
@@ -16,65 +16,177 @@
 // along with   If not, see <http://www.gnu.org/licenses/>.
 
 // Package roles is intended to provide Roles Based access controls
-// for users and channels. This is a work in progress, and is likely
-// to chnage.
+// for users and channels. Roles are granted to users, either everywhere,
+// or only within a given channel, and are persisted in the bot's store.
+//
+// Roles are granted to the UserID of a user, as provided by the adapter.
+// Messages from adapters that do not set a UserID are matched on their
+// From field instead. From is never used when a UserID is present, as on
+// many chat systems it is a display name that any user can change.
+//
+// The roles command takes users by ID. On adapters that implement
+// hugot.Directory, such as slack and mattermost, names and mentions are
+// also accepted, and are resolved to IDs before roles are stored.
 package roles
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"sort"
+	"text/tabwriter"
 
 	"github.com/tcolgate/hugot"
 	"github.com/tcolgate/hugot/bot"
 	"github.com/tcolgate/hugot/handlers/command"
 	"github.com/tcolgate/hugot/handlers/mux"
+	"github.com/tcolgate/hugot/scope"
 	"github.com/tcolgate/hugot/storage"
+	"github.com/tcolgate/hugot/storage/prefix"
+	"github.com/tcolgate/hugot/storage/scoped"
 )
 
+// Admin is the role required to grant and revoke roles.
+const Admin = "admin"
+
+// grantScopes are the scopes that a role can be granted in, most
+// specific first.
+var grantScopes = []scope.Scope{
+	scope.ChannelUser,
+	scope.User,
+}
+
 // Handler implements support for user roles
 type Handler struct {
 	up hugot.Handler
 	cs command.Set
+	s  storage.Storer
+
+	admins map[string]struct{}
+}
+
+// Opt functions are used to set options on the roles Handler
+type Opt func(*Handler)
+
+// WithAdmins is an option that grants the Admin role to the listed
+// user IDs in all channels. Names are only matched for adapters that do
+// not provide user IDs. At least one admin is needed
+// to bootstrap the granting of roles to other users.
+func WithAdmins(users ...string) Opt {
+	return func(h *Handler) {
+		for _, u := range users {
+			h.admins[u] = struct{}{}
+		}
+	}
 }
 
-// New creates a new roles handler.
-func New(up hugot.Handler, cs command.Set, s storage.Storer) *Handler {
-	cs.MustAdd(&manager{})
+// New creates a new roles handler. Grants are stored in s, and a
+// roles command is registered with cs to allow users to manage them.
+func New(up hugot.Handler, cs command.Set, s storage.Storer, opts ...Opt) *Handler {
+	h := &Handler{
+		up:     up,
+		cs:     cs,
+		s:      prefix.New(s, []string{"roles"}),
+		admins: map[string]struct{}{},
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
 
-	return &Handler{cs: cs, up: up}
+	cs.MustAdd(&manager{h})
+
+	return h
 }
 
-// Describe implements the Describer interface for the alias handler
+// Describe implements the Describer interface for the roles handler
 func (h *Handler) Describe() (string, string) {
 	return h.up.Describe()
 }
 
-// Help implements the command.Helper interfaace for the alias handler
-func (h *Handler) Help(w io.Writer) error {
+// Help implements the command.Helper interfaace for the roles handler
+func (h *Handler) Help(ctx context.Context, w io.Writer) error {
 	if hh, ok := h.up.(mux.Helper); ok {
-		return hh.Help(w)
+		return hh.Help(ctx, w)
 	}
 	return nil
 }
 
-type rolesCtxKeyType int
-
-const rolesCtxKey = rolesCtxKeyType(1)
-
 // ProcessMessage adds any roles the user has to the context
 func (h *Handler) ProcessMessage(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
-	nctx := context.WithValue(ctx, rolesCtxKey, map[string]struct{}{"admin": struct{}{}})
+	roles, err := h.Lookup(m.Channel, identities(m)...)
+	if err != nil {
+		return err
+	}
+	nctx := NewContext(ctx, roles)
 	return h.up.ProcessMessage(nctx, w, m)
 }
 
+// Lookup returns the set of roles held by any of the given user
+// identities within channel.
+func (h *Handler) Lookup(channel string, users ...string) (map[string]struct{}, error) {
+	roles := map[string]struct{}{}
+	for _, u := range users {
+		if _, ok := h.admins[u]; ok {
+			roles[Admin] = struct{}{}
+		}
+		for _, sc := range grantScopes {
+			keys, err := scoped.New(h.s, sc, channel, u).List([]string{})
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range keys {
+				if len(k) > 0 {
+					roles[k[0]] = struct{}{}
+				}
+			}
+		}
+	}
+	return roles, nil
+}
+
+// Grant grants role to user. If channel is empty, the role is granted
+// in all channels.
+func (h *Handler) Grant(channel, user, role, by string) error {
+	return grantStore(h.s, channel, user).Set([]string{role}, by)
+}
+
+// Revoke removes a role from a user. The channel must match that
+// given when the role was granted.
+func (h *Handler) Revoke(channel, user, role string) error {
+	return grantStore(h.s, channel, user).Unset([]string{role})
+}
+
+func grantStore(s storage.Storer, channel, user string) storage.Storer {
+	if channel == "" {
+		return scoped.New(s, scope.User, "", user)
+	}
+	return scoped.New(s, scope.ChannelUser, channel, user)
+}
+
+// identities returns the identities roles may be granted to for the
+// sender of m. On many adapters From is a display name that users can
+// change, so it is only used if the adapter does not provide a UserID.
+func identities(m *hugot.Message) []string {
+	switch {
+	case m.UserID != "":
+		return []string{m.UserID}
+	case m.From != "":
+		return []string{m.From}
+	}
+	return nil
+}
+
+// NewContext returns a context carrying the provided set of roles.
+func NewContext(ctx context.Context, roles map[string]struct{}) context.Context {
+	return hugot.NewRolesContext(ctx, roles)
+}
+
 // FromContext retrieves a set of roles from the current context
 func FromContext(ctx context.Context) map[string]struct{} {
-	ri := ctx.Value(rolesCtxKey)
-	roles := ri.(map[string]struct{})
-	if roles == nil {
-		roles = map[string]struct{}{}
-	}
-	return roles
+	return hugot.RolesFromContext(ctx)
 }
 
 // Check verifies the the current user has the requested role
@@ -113,23 +225,157 @@ func CheckAll(ctx context.Context, want []string) bool {
 }
 
 type manager struct {
+	h *Handler
 }
 
 func (am *manager) Describe() (string, string) {
 	return "roles", "manage roles"
 }
 
-func (am *manager) Command(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
-	return nil
-}
 func (am *manager) CommandSetup(root *command.Command) error {
 	root.Use = "roles"
-	root.Short = "manager roles"
-	root.Run = am.Command
+	root.Short = "manage user roles"
+
+	gctx := &grantCtx{h: am.h}
+	grant := &command.Command{
+		Use:      "grant user-id role [role...]",
+		Short:    "grant roles to a user",
+		Run:      gctx.Grant,
+		AnyRoles: []string{Admin},
+	}
+	gctx.c = grant.Flags().BoolP("channel", "c", false, "Grant the roles in the current channel only")
+	root.AddCommand(grant)
+
+	rctx := &grantCtx{h: am.h}
+	revoke := &command.Command{
+		Use:      "revoke user-id role [role...]",
+		Short:    "revoke roles from a user",
+		Run:      rctx.Revoke,
+		AnyRoles: []string{Admin},
+	}
+	rctx.c = revoke.Flags().BoolP("channel", "c", false, "Revoke roles granted in the current channel only")
+	root.AddCommand(revoke)
+
+	list := &command.Command{
+		Use:   "list [user-id]",
+		Short: "list the roles held by a user",
+		Run:   am.List,
+	}
+	root.AddCommand(list)
+
+	root.Run = am.List
+
+	return nil
+}
+
+type grantCtx struct {
+	h *Handler
+	c *bool
+}
+
+func (gc *grantCtx) channel(m *hugot.Message) string {
+	if *gc.c {
+		return m.Channel
+	}
+	return ""
+}
+
+func (gc *grantCtx) Grant(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if len(args) < 2 {
+		return errors.New("you must provide a user and at least one role")
+	}
+
+	u, err := hugot.ResolveUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, r := range args[1:] {
+		if err := gc.h.Grant(gc.channel(m), u, r, m.From); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "granted %v to %s", args[1:], u)
+	return nil
+}
+
+func (gc *grantCtx) Revoke(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if len(args) < 2 {
+		return errors.New("you must provide a user and at least one role")
+	}
+
+	u, err := hugot.ResolveUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, r := range args[1:] {
+		if err := gc.h.Revoke(gc.channel(m), u, r); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "revoked %v from %s", args[1:], u)
+	return nil
+}
+
+func (am *manager) List(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	users := identities(m)
+	switch len(args) {
+	case 0:
+	case 1:
+		u, err := hugot.ResolveUser(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		users = []string{u}
+	default:
+		return errors.New("list takes at most one user")
+	}
+
+	out := &bytes.Buffer{}
+	tw := new(tabwriter.Writer)
+	tw.Init(out, 0, 8, 1, '\t', 0)
+
+	n := 0
+	for _, u := range users {
+		if _, ok := am.h.admins[u]; ok {
+			fmt.Fprintf(tw, "  %s\t%s\t - built in\n", u, Admin)
+			n++
+		}
+		for _, sc := range grantScopes {
+			store := scoped.New(am.h.s, sc, m.Channel, u)
+			keys, err := store.List([]string{})
+			if err != nil {
+				return err
+			}
+			var rs []string
+			for _, k := range keys {
+				if len(k) > 0 {
+					rs = append(rs, k[0])
+				}
+			}
+			sort.Strings(rs)
+			for _, r := range rs {
+				by, _, _ := store.Get([]string{r})
+				fmt.Fprintf(tw, "  %s\t%s\t - %s, granted by %s\n", u, r, sc.Describe(m.Channel, u), by)
+				n++
+			}
+		}
+	}
+	tw.Flush()
+
+	if n == 0 {
+		fmt.Fprintf(w, "No roles for %v", users)
+		return nil
+	}
+
+	fmt.Fprintf(w, "Roles:\n%s", out.String())
 	return nil
 }
 
 // Register installs this handler on  bot.DefaultBot
-func Register() {
-	bot.DefaultBot.Mux.ToBot = New(bot.DefaultBot.Mux.ToBot, bot.DefaultBot.Commands, bot.DefaultBot.Store)
+func Register(opts ...Opt) {
+	bot.DefaultBot.Mux.ToBot = New(bot.DefaultBot.Mux.ToBot, bot.DefaultBot.Commands, bot.DefaultBot.Store, opts...)
 }
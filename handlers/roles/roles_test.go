@@ -0,0 +1,157 @@
+package roles_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/handlers/roles"
+	"github.com/tcolgate/hugot/hugottest"
+	"github.com/tcolgate/hugot/storage/memory"
+)
+
+type testRoles struct {
+	got map[string]struct{}
+}
+
+func (tr *testRoles) Describe() (string, string) {
+	return "test", "test roles"
+}
+
+func (tr *testRoles) ProcessMessage(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+	tr.got = roles.FromContext(ctx)
+	return nil
+}
+
+func TestRoles_Lookup(t *testing.T) {
+	h := roles.New(&testRoles{}, command.Set{}, memory.New())
+
+	if err := h.Grant("", "bob", "deployer", "alice"); err != nil {
+		t.Fatalf("grant failed, %v", err)
+	}
+	if err := h.Grant("ops", "bob", "admin", "alice"); err != nil {
+		t.Fatalf("grant failed, %v", err)
+	}
+
+	rs, _ := h.Lookup("ops", "bob")
+	if _, ok := rs["deployer"]; !ok {
+		t.Fatalf("expected deployer role in ops, got %v", rs)
+	}
+	if _, ok := rs["admin"]; !ok {
+		t.Fatalf("expected admin role in ops, got %v", rs)
+	}
+
+	rs, _ = h.Lookup("dev", "bob")
+	if _, ok := rs["admin"]; ok {
+		t.Fatalf("did not expect admin role in dev, got %v", rs)
+	}
+
+	h.Revoke("", "bob", "deployer")
+	rs, _ = h.Lookup("dev", "bob")
+	if len(rs) != 0 {
+		t.Fatalf("expected no roles after revoke, got %v", rs)
+	}
+}
+
+func TestRoles_Command(t *testing.T) {
+	cs := command.Set{}
+	tr := &testRoles{}
+	h := roles.New(cs, cs, memory.New(), roles.WithAdmins("U1"))
+	cs.MustAdd(command.NewFunc(func(root *command.Command) error {
+		root.Use = "whoami"
+		root.Run = func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+			return tr.ProcessMessage(ctx, w, m)
+		}
+		return nil
+	}))
+
+	rr := &hugottest.ResponseRecorder{MessagesOut: make(chan hugot.Message, 10)}
+	send := func(from, id, txt string) error {
+		m := &hugot.Message{From: from, UserID: id, Channel: "ops", Text: txt, ToBot: true}
+		return h.ProcessMessage(context.Background(), hugot.NewResponseWriter(rr, *m, "test"), m)
+	}
+
+	var perr *command.PermissionError
+	if err := send("bob", "U2", "roles grant bob deployer"); !errors.As(err, &perr) {
+		t.Fatalf("expected permission denied for non-admin, got %v", err)
+	}
+
+	if err := send("alice", "U1", "roles grant -c U2 deployer"); err != nil {
+		t.Fatalf("grant failed, %v", err)
+	}
+
+	if err := send("bob", "U2", "whoami"); err != nil {
+		t.Fatalf("whoami failed, %v", err)
+	}
+	if _, ok := tr.got["deployer"]; !ok {
+		t.Fatalf("expected bob to have deployer in context, got %v", tr.got)
+	}
+
+	// Display names can be changed, so are not trusted if there is a
+	// UserID.
+	if err := send("U1", "U3", "roles grant U2 admin"); !errors.As(err, &perr) {
+		t.Fatalf("expected permission denied for user named after an admin, got %v", err)
+	}
+	if err := send("U1", "", "whoami"); err != nil {
+		t.Fatalf("whoami failed, %v", err)
+	}
+	if _, ok := tr.got[roles.Admin]; !ok {
+		t.Fatalf("expected From to be used without a UserID, got %v", tr.got)
+	}
+}
+
+type testDirectory struct {
+	*hugottest.ResponseRecorder
+	users map[string]string
+}
+
+func (td *testDirectory) Receive() <-chan *hugot.Message {
+	return nil
+}
+
+func (td *testDirectory) UserID(u string) (string, error) {
+	if id, ok := td.users[u]; ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("unknown user %q", u)
+}
+
+func (td *testDirectory) ChannelID(c string) (string, error) {
+	return c, nil
+}
+
+func TestRoles_CommandDirectory(t *testing.T) {
+	cs := command.Set{}
+	h := roles.New(cs, cs, memory.New(), roles.WithAdmins("U1"))
+
+	rr := &hugottest.ResponseRecorder{MessagesOut: make(chan hugot.Message, 10)}
+	td := &testDirectory{ResponseRecorder: rr, users: map[string]string{"@bob": "U2", "<@U2>": "U2"}}
+	ctx := hugot.NewAdapterContext(context.Background(), td)
+	send := func(txt string) error {
+		m := &hugot.Message{From: "alice", UserID: "U1", Channel: "ops", Text: txt, ToBot: true}
+		return h.ProcessMessage(ctx, hugot.NewResponseWriter(rr, *m, "test"), m)
+	}
+
+	if err := send("roles grant @bob deployer"); err != nil {
+		t.Fatalf("grant failed, %v", err)
+	}
+	rs, _ := h.Lookup("ops", "U2")
+	if _, ok := rs["deployer"]; !ok {
+		t.Fatalf("expected deployer to be granted to U2, got %v", rs)
+	}
+
+	if err := send("roles grant @carol deployer"); err == nil {
+		t.Fatalf("expected grant to an unknown user to fail")
+	}
+
+	if err := send("roles revoke <@U2> deployer"); err != nil {
+		t.Fatalf("revoke failed, %v", err)
+	}
+	rs, _ = h.Lookup("ops", "U2")
+	if len(rs) != 0 {
+		t.Fatalf("expected no roles after revoke, got %v", rs)
+	}
+}
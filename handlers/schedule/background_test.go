@@ -0,0 +1,149 @@
+package schedule
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/hugottest"
+	"github.com/tcolgate/hugot/storage/memory"
+)
+
+type testTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// testClock is a clock whose time only changes when Advance is called.
+type testClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*testTimer
+}
+
+func (c *testClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *testClock) Timer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.Lock()
+	defer c.Unlock()
+	t := &testTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.fire()
+	return t.c, func() bool { return true }
+}
+
+// Advance moves the clock forward by d, firing any timers that are due.
+func (c *testClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// Wait blocks until something is waiting on a timer.
+func (c *testClock) Wait(t *testing.T) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		c.Lock()
+		n := len(c.timers)
+		c.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for a timer")
+}
+
+// fire sends to, and removes, any due timers, c must be locked.
+func (c *testClock) fire() {
+	var ts []*testTimer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			ts = append(ts, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = ts
+}
+
+func runBackground(t *testing.T, s *Scheduler) chan hugot.Message {
+	rr := &hugottest.ResponseRecorder{MessagesOut: make(chan hugot.Message, 10)}
+	w := hugot.NewResponseWriter(rr, hugot.Message{}, "test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.StartBackground(ctx, w)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return rr.MessagesOut
+}
+
+func expectMessage(t *testing.T, out chan hugot.Message, channel, text string) {
+	t.Helper()
+	select {
+	case m := <-out:
+		if m.Channel != channel || m.Text != text {
+			t.Fatalf("expected %q in %s, got %q in %s", text, channel, m.Text, m.Channel)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", text)
+	}
+}
+
+func TestScheduler_StartBackground(t *testing.T) {
+	// A Monday, a minute before standup.
+	clk := &testClock{now: time.Date(2020, time.June, 1, 8, 59, 0, 0, time.UTC)}
+	s := New(memory.New())
+	s.clock = clk
+
+	if err := s.Add(Schedule{Name: "standup", Spec: "0 9 * * 1-5", Channel: "dev", Text: "standup time"}); err != nil {
+		t.Fatalf("add failed, %v", err)
+	}
+	out := runBackground(t, s)
+	clk.Wait(t)
+
+	clk.Advance(30 * time.Second)
+	select {
+	case m := <-out:
+		t.Fatalf("schedule ran early, got %q", m.Text)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clk.Advance(30 * time.Second)
+	expectMessage(t, out, "dev", "standup time")
+
+	clk.Advance(24 * time.Hour)
+	expectMessage(t, out, "dev", "standup time")
+}
+
+func TestScheduler_StartBackgroundLoad(t *testing.T) {
+	store := memory.New()
+	if err := New(store).Add(Schedule{Name: "standup", Spec: "0 9 * * 1-5", Channel: "dev", Text: "standup time"}); err != nil {
+		t.Fatalf("add failed, %v", err)
+	}
+
+	// A new Scheduler, as after a restart, should pick up the
+	// stored schedule when it starts.
+	clk := &testClock{now: time.Date(2020, time.June, 1, 8, 59, 0, 0, time.UTC)}
+	s := New(store)
+	s.clock = clk
+	out := runBackground(t, s)
+	clk.Wait(t)
+
+	clk.Advance(time.Minute)
+	expectMessage(t, out, "dev", "standup time")
+}
@@ -0,0 +1,188 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec describes when a schedule should run. It is parsed from either a
+// standard five field cron expression (minute, hour, day of month, month,
+// day of week), one of the descriptors @yearly, @monthly, @weekly, @daily,
+// @hourly, or a fixed interval given as "@every <duration>".
+type Spec struct {
+	every time.Duration
+
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type field struct {
+	min, max int
+}
+
+var (
+	minuteField = field{0, 59}
+	hourField   = field{0, 23}
+	domField    = field{1, 31}
+	monthField  = field{1, 12}
+	dowField    = field{0, 7} // 0 and 7 are both Sunday
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSpec parses a schedule specification.
+func ParseSpec(s string) (*Spec, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(s, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval, %v", err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("interval %s is too short", d)
+		}
+		return &Spec{every: d}, nil
+	}
+
+	if d, ok := descriptors[s]; ok {
+		s = d
+	}
+
+	fs := strings.Fields(s)
+	if len(fs) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression %q, got %d", s, len(fs))
+	}
+
+	var err error
+	spec := &Spec{
+		domStar: fs[2] == "*",
+		dowStar: fs[4] == "*",
+	}
+	if spec.minute, err = parseField(fs[0], minuteField); err != nil {
+		return nil, err
+	}
+	if spec.hour, err = parseField(fs[1], hourField); err != nil {
+		return nil, err
+	}
+	if spec.dom, err = parseField(fs[2], domField); err != nil {
+		return nil, err
+	}
+	if spec.month, err = parseField(fs[3], monthField); err != nil {
+		return nil, err
+	}
+	if spec.dow, err = parseField(fs[4], dowField); err != nil {
+		return nil, err
+	}
+	// Allow 7 to be used for Sunday
+	if has(spec.dow, 7) {
+		spec.dow = (spec.dow | 1) &^ (1 << 7)
+	}
+
+	if spec.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never runs", s)
+	}
+
+	return spec, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps
+// into a bitset.
+func parseField(s string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			rs := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(rs[0])
+			hi, err2 = strconv.Atoi(rs[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, f.min, f.max)
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+func (s *Spec) dayMatches(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+	// As with cron, if both day fields are restricted, a match on either
+	// is sufficient.
+	if !s.domStar && !s.dowStar {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Next returns the first time after t that the schedule should run. The
+// zero time is returned if the schedule will not run in the next five
+// years.
+func (s *Spec) Next(t time.Time) time.Time {
+	if s.every != 0 {
+		return t.Add(s.every)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !has(s.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !has(s.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
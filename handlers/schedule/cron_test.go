@@ -0,0 +1,64 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpec_Next(t *testing.T) {
+	// A Monday
+	from := time.Date(2020, time.June, 1, 10, 30, 15, 0, time.UTC)
+
+	var tests = []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2020, time.June, 1, 10, 31, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2020, time.June, 2, 9, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, time.June, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, time.July, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 8 * * 0", time.Date(2020, time.June, 7, 8, 30, 0, 0, time.UTC)},
+		{"30 8 * * 7", time.Date(2020, time.June, 7, 8, 30, 0, 0, time.UTC)},
+		{"0 9 * * 2-7", time.Date(2020, time.June, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 6-7", time.Date(2020, time.June, 6, 9, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2024, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, time.June, 1, 11, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2020, time.June, 1, 10, 31, 45, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseSpec(tt.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.next) {
+			t.Errorf("%q: expected next run at %v, got %v", tt.spec, tt.next, got)
+		}
+	}
+}
+
+func TestParseSpec_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "@every 1ms", "@every soon", "0 0 30 2 *", "0 0 * * 8"} {
+		if _, err := ParseSpec(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestScheduler_DueSkipsFinished(t *testing.T) {
+	now := time.Now()
+	hourly, _ := ParseSpec("@hourly")
+	s := &Scheduler{jobs: map[string]*job{
+		"done":   {Schedule: Schedule{Name: "done"}, spec: hourly},
+		"hourly": {Schedule: Schedule{Name: "hourly"}, spec: hourly, next: now.Add(time.Minute)},
+	}}
+
+	js, next := s.due(now)
+	if len(js) != 0 {
+		t.Fatalf("expected no jobs to be due, got %d", len(js))
+	}
+	if !next.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected next run at %v, got %v", now.Add(time.Minute), next)
+	}
+}
@@ -0,0 +1,420 @@
+// Package schedule provides a background handler that sends messages, or
+// runs handler functions, on a cron schedule or at fixed intervals.
+//
+// Schedules added from chat, using the schedule command, are persisted
+// in the bot's store so that they survive restarts.
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/bot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/handlers/roles"
+	"github.com/tcolgate/hugot/storage"
+	"github.com/tcolgate/hugot/storage/prefix"
+)
+
+// Schedule describes a message to send to a channel when Spec
+// is next due.
+type Schedule struct {
+	Name    string `json:"name"`
+	Spec    string `json:"spec"`
+	Channel string `json:"channel"`
+	Text    string `json:"text"`
+	Creator string `json:"creator,omitempty"`
+}
+
+// clock provides the current time, and timers, to the Scheduler, so
+// that they can be controlled in tests.
+type clock interface {
+	Now() time.Time
+	Timer(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Timer(d time.Duration) (<-chan time.Time, func() bool) {
+	t := time.NewTimer(d)
+	return t.C, t.Stop
+}
+
+type job struct {
+	Schedule
+	spec *Spec
+	f    hugot.HandlerFunc
+	next time.Time
+}
+
+// Scheduler is a hugot.BackgroundHandler that runs a set of schedules.
+// It also implements command.Setupper to allow users to manage schedules.
+type Scheduler struct {
+	s     storage.Storer
+	rs    []string
+	clock clock
+
+	sync.Mutex
+	jobs    map[string]*job
+	changed chan struct{}
+
+	running sync.WaitGroup
+}
+
+// ErrBuiltIn is returned when attempting to replace, or remove, a
+// schedule that was added with AddFunc.
+var ErrBuiltIn = errors.New("schedule is built in")
+
+// New creates a new Scheduler. Schedules are persisted in s. Users must
+// hold any one of rs to add or remove schedules, if no roles are given
+// the roles.Admin role is required.
+func New(s storage.Storer, rs ...string) *Scheduler {
+	if len(rs) == 0 {
+		rs = []string{roles.Admin}
+	}
+
+	return &Scheduler{
+		s:       prefix.New(s, []string{"schedules"}),
+		rs:      rs,
+		clock:   realClock{},
+		jobs:    map[string]*job{},
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Describe implements the Describer interface for the Scheduler.
+func (s *Scheduler) Describe() (string, string) {
+	return "schedule", "runs scheduled messages and jobs"
+}
+
+// Add adds a schedule to send a message, and persists it in the store.
+// Any existing schedule of the same name is replaced, unless it was added
+// with AddFunc.
+func (s *Scheduler) Add(sch Schedule) error {
+	spec, err := ParseSpec(sch.Spec)
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.Marshal(sch)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkBuiltIn(sch.Name); err != nil {
+		return err
+	}
+	if err := s.s.Set([]string{sch.Name}, string(bs)); err != nil {
+		return err
+	}
+
+	s.add(&job{Schedule: sch, spec: spec})
+	return nil
+}
+
+// AddFunc adds a schedule that runs f, with messages sent via
+// the handler's ResponseWriter going to channel. Schedules added
+// with AddFunc are not persisted.
+func (s *Scheduler) AddFunc(name, spec, channel string, f hugot.HandlerFunc) error {
+	sp, err := ParseSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.add(&job{Schedule: Schedule{Name: name, Spec: spec, Channel: channel}, spec: sp, f: f})
+	return nil
+}
+
+// add adds j to the running jobs, s must be locked.
+func (s *Scheduler) add(j *job) {
+	j.next = j.spec.Next(s.clock.Now())
+	s.jobs[j.Name] = j
+	s.notify()
+}
+
+// Remove removes a schedule. Schedules added with AddFunc cannot be
+// removed.
+func (s *Scheduler) Remove(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.jobs[name]; !ok {
+		return fmt.Errorf("no schedule named %s", name)
+	}
+	if err := s.checkBuiltIn(name); err != nil {
+		return err
+	}
+	if err := s.s.Unset([]string{name}); err != nil {
+		return err
+	}
+
+	delete(s.jobs, name)
+	s.notify()
+	return nil
+}
+
+// checkBuiltIn returns an error if the schedule name was added with
+// AddFunc, s must be locked.
+func (s *Scheduler) checkBuiltIn(name string) error {
+	if j, ok := s.jobs[name]; ok && j.f != nil {
+		return fmt.Errorf("%w, %s cannot be changed", ErrBuiltIn, name)
+	}
+	return nil
+}
+
+// notify wakes the background loop, s must be locked.
+func (s *Scheduler) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// load reads any persisted schedules from the store.
+func (s *Scheduler) load(ctx context.Context) error {
+	keys, err := s.s.List([]string{})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		v, ok, err := s.s.Get(k)
+		if err != nil || !ok {
+			continue
+		}
+		var sch Schedule
+		if err := json.Unmarshal([]byte(v), &sch); err != nil {
+			hugot.LoggerFromContext(ctx).Error("could not parse stored schedule", "key", k, "error", err)
+			continue
+		}
+		spec, err := ParseSpec(sch.Spec)
+		if err != nil {
+			hugot.LoggerFromContext(ctx).Error("invalid stored schedule", "key", k, "error", err)
+			continue
+		}
+
+		s.Lock()
+		if err := s.checkBuiltIn(sch.Name); err != nil {
+			hugot.LoggerFromContext(ctx).Error("ignoring stored schedule", "key", k, "error", err)
+		} else {
+			s.add(&job{Schedule: sch, spec: spec})
+		}
+		s.Unlock()
+	}
+
+	return nil
+}
+
+// due returns the jobs due to run at now, and the time at which the
+// next job is due.
+func (s *Scheduler) due(now time.Time) ([]*job, time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	var js []*job
+	var next time.Time
+	for _, j := range s.jobs {
+		if j.next.IsZero() {
+			// This schedule will not run again.
+			continue
+		}
+		if !j.next.After(now) {
+			js = append(js, j)
+			j.next = j.spec.Next(now)
+		}
+		if !j.next.IsZero() && (next.IsZero() || j.next.Before(next)) {
+			next = j.next
+		}
+	}
+	return js, next
+}
+
+// StartBackground implements hugot.BackgroundHandler, and runs schedules
+// until ctx is cancelled. It returns once any running jobs have finished.
+func (s *Scheduler) StartBackground(ctx context.Context, w hugot.ResponseWriter) {
+	defer s.running.Wait()
+
+	if err := s.load(ctx); err != nil {
+		hugot.LoggerFromContext(ctx).Error("could not load schedules", "error", err)
+	}
+
+	for {
+		js, next := s.due(s.clock.Now())
+		for _, j := range js {
+			s.running.Add(1)
+			go func(j *job) {
+				defer s.running.Done()
+				s.run(ctx, w, j)
+			}(j)
+		}
+
+		var tc <-chan time.Time
+		stop := func() bool { return false }
+		if !next.IsZero() {
+			tc, stop = s.clock.Timer(next.Sub(s.clock.Now()))
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-s.changed:
+		case <-tc:
+		}
+
+		stop()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, w hugot.ResponseWriter, j *job) {
+	w = w.Copy()
+	w.SetChannel(j.Channel)
+
+	if j.f == nil {
+		fmt.Fprint(w, j.Text)
+		return
+	}
+
+	m := &hugot.Message{Channel: j.Channel}
+	if err := j.f(ctx, w, m); err != nil {
+		hugot.LoggerFromContext(ctx).Error("scheduled job failed", "schedule", j.Name, "error", err)
+	}
+}
+
+// CommandSetup implements command.Setupper, providing a command for
+// users to manage schedules.
+func (s *Scheduler) CommandSetup(root *command.Command) error {
+	root.Use = "schedule"
+	root.Short = "manage scheduled messages"
+
+	actx := &addCtx{s: s}
+	add := &command.Command{
+		Use:      "add name spec text...",
+		Short:    "send a message on a schedule",
+		Example:  `schedule add standup "0 9 * * 1-5" time for standup`,
+		Run:      actx.Command,
+		AnyRoles: s.rs,
+	}
+	actx.c = add.Flags().StringP("channel", "c", "", "Channel to send the message to, defaults to the current channel")
+	root.AddCommand(add)
+
+	root.AddCommand(&command.Command{
+		Use:   "list",
+		Short: "list schedules",
+		Run:   s.listCmd,
+	})
+
+	root.AddCommand(&command.Command{
+		Use:      "rm name",
+		Short:    "remove a schedule",
+		Run:      s.rmCmd,
+		AnyRoles: s.rs,
+	})
+
+	root.Run = s.listCmd
+
+	return nil
+}
+
+type addCtx struct {
+	s *Scheduler
+	c *string
+}
+
+func (ac *addCtx) Command(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if len(args) < 3 {
+		return errors.New("you must provide a name, a schedule and a message")
+	}
+
+	ch := *ac.c
+	if ch == "" {
+		ch = m.Channel
+	}
+
+	sch := Schedule{
+		Name:    args[0],
+		Spec:    args[1],
+		Channel: ch,
+		Text:    strings.Join(args[2:], " "),
+		Creator: m.From,
+	}
+	if err := ac.s.Add(sch); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "added schedule %s", sch.Name)
+	return nil
+}
+
+func (s *Scheduler) rmCmd(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	if len(args) != 1 {
+		return errors.New("you must provide the name of the schedule to remove")
+	}
+	if err := s.Remove(args[0]); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "removed schedule %s", args[0])
+	return nil
+}
+
+func (s *Scheduler) listCmd(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message, args []string) error {
+	s.Lock()
+	var names []string
+	for n := range s.jobs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	out := &bytes.Buffer{}
+	tw := new(tabwriter.Writer)
+	tw.Init(out, 0, 8, 1, '\t', 0)
+	for _, n := range names {
+		j := s.jobs[n]
+		what := j.Text
+		if j.f != nil {
+			what = "(built in)"
+		}
+		fmt.Fprintf(tw, "  %s\t`%s`\t%s\tnext %s\t - %s\n", j.Name, j.Spec, j.Channel, j.next.Format(time.RFC822), what)
+	}
+	s.Unlock()
+	tw.Flush()
+
+	if len(names) == 0 {
+		fmt.Fprint(w, "No schedules")
+		return nil
+	}
+
+	io.Copy(w, out)
+	return nil
+}
+
+// Register installs a Scheduler, and its command, on bot.DefaultBot.
+// Users must hold any one of rs to add or remove schedules.
+func Register(rs ...string) *Scheduler {
+	s := New(bot.DefaultBot.Store, rs...)
+	bot.Background(s)
+	bot.Command(command.New(s))
+	return s
+}
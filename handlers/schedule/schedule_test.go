@@ -0,0 +1,92 @@
+package schedule_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/handlers/command"
+	"github.com/tcolgate/hugot/handlers/schedule"
+	"github.com/tcolgate/hugot/hugottest"
+	"github.com/tcolgate/hugot/storage/memory"
+)
+
+func TestScheduler_Persist(t *testing.T) {
+	store := memory.New()
+	s := schedule.New(store)
+
+	err := s.Add(schedule.Schedule{Name: "standup", Spec: "0 9 * * 1-5", Channel: "dev", Text: "standup time"})
+	if err != nil {
+		t.Fatalf("add failed, %v", err)
+	}
+
+	if err := s.Add(schedule.Schedule{Name: "bad", Spec: "0 9 * *", Channel: "dev", Text: "never"}); err == nil {
+		t.Fatalf("expected invalid spec to be rejected")
+	}
+
+	keys, _ := store.List([]string{})
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 stored schedule, got %v", keys)
+	}
+
+	if err := s.Remove("standup"); err != nil {
+		t.Fatalf("remove failed, %v", err)
+	}
+
+	keys, _ = store.List([]string{})
+	if len(keys) != 0 {
+		t.Fatalf("expected no stored schedules, got %v", keys)
+	}
+}
+
+func TestScheduler_BuiltIn(t *testing.T) {
+	s := schedule.New(memory.New())
+
+	f := func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error { return nil }
+	if err := s.AddFunc("backup", "@daily", "ops", f); err != nil {
+		t.Fatalf("add failed, %v", err)
+	}
+
+	err := s.Add(schedule.Schedule{Name: "backup", Spec: "@hourly", Channel: "ops", Text: "hijacked"})
+	if !errors.Is(err, schedule.ErrBuiltIn) {
+		t.Fatalf("expected built in schedule to be protected, got %v", err)
+	}
+	if err := s.Remove("backup"); !errors.Is(err, schedule.ErrBuiltIn) {
+		t.Fatalf("expected built in schedule to be protected, got %v", err)
+	}
+}
+
+func TestScheduler_CommandRoles(t *testing.T) {
+	s := schedule.New(memory.New(), "scheduler")
+	cs := command.Set{}
+	cs.MustAdd(s)
+
+	rr := &hugottest.ResponseRecorder{MessagesOut: make(chan hugot.Message, 10)}
+	send := func(txt string, rs ...string) error {
+		roles := map[string]struct{}{}
+		for _, r := range rs {
+			roles[r] = struct{}{}
+		}
+		ctx := hugot.NewRolesContext(context.Background(), roles)
+		m := &hugot.Message{From: "bob", Channel: "dev", Text: txt, ToBot: true}
+		return cs.ProcessMessage(ctx, hugot.NewResponseWriter(rr, *m, "test"), m)
+	}
+
+	var perr *command.PermissionError
+	if err := send("schedule add -c ops standup @daily hello"); !errors.As(err, &perr) {
+		t.Fatalf("expected add to require a role, got %v", err)
+	}
+	if err := send("schedule add -c ops standup @daily hello", "scheduler"); err != nil {
+		t.Fatalf("add failed, %v", err)
+	}
+	if err := send("schedule list"); err != nil {
+		t.Fatalf("expected list to be allowed, got %v", err)
+	}
+	if err := send("schedule rm standup"); !errors.As(err, &perr) {
+		t.Fatalf("expected rm to require a role, got %v", err)
+	}
+	if err := send("schedule rm standup", "scheduler"); err != nil {
+		t.Fatalf("rm failed, %v", err)
+	}
+}
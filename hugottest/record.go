@@ -2,6 +2,8 @@ package hugottest
 
 import (
 	"context"
+	"strconv"
+	"sync/atomic"
 
 	"github.com/tcolgate/hugot"
 )
@@ -11,24 +13,32 @@ import (
 type ResponseRecorder struct {
 	MessagesOut chan hugot.Message
 
-	defchan string
-	defto   string
+	defchan   string
+	defto     string
+	defthread string
+
+	sent int64
 }
 
-// Send is called to send a message to the recorder's channel.
-func (rr *ResponseRecorder) Send(ctx context.Context, m *hugot.Message) {
+// Send is called to send a message to the recorder's channel. Messages
+// are given sequential IDs, starting at "1".
+func (rr *ResponseRecorder) Send(ctx context.Context, m *hugot.Message) string {
 	if m.Channel == "" {
 		m.Channel = rr.defchan
 	}
-	rr.MessagesOut <- *m
+	out := *m
+	out.ID = strconv.FormatInt(atomic.AddInt64(&rr.sent, 1), 10)
+	rr.MessagesOut <- out
+	return out.ID
 }
 
 // Wrtie implement io.Write, but sending data written to it as a single
 // hugot.Message.
 func (rr *ResponseRecorder) Write(bs []byte) (int, error) {
 	nmsg := hugot.Message{
-		Channel: rr.defchan,
-		To:      rr.defto,
+		Channel:  rr.defchan,
+		To:       rr.defto,
+		ThreadID: rr.defthread,
 	}
 	nmsg.Text = string(bs)
 	rr.Send(context.TODO(), &nmsg)
@@ -45,3 +55,8 @@ func (rr *ResponseRecorder) SetChannel(c string) {
 func (rr *ResponseRecorder) SetTo(to string) {
 	rr.defto = to
 }
+
+// SetThread sets the thread that data sent with Write will be sent to.
+func (rr *ResponseRecorder) SetThread(id string) {
+	rr.defthread = id
+}
@@ -9,14 +9,5 @@ type MessagePlayer struct {
 
 // Receive will return a mesasge from the player's channel.
 func (mp *MessagePlayer) Receive() <-chan *hugot.Message {
-	c := make(chan *hugot.Message)
-
-	go func() {
-		defer close(c)
-		for {
-			m := <-mp.MessagesIn
-			c <- m
-		}
-	}()
-	return c
+	return mp.MessagesIn
 }
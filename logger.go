@@ -0,0 +1,95 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+package hugot
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger is used by the bot, adapters and handlers to log structured
+// messages. args are alternating keys and values, as used by log/slog.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+
+	// With returns a Logger that includes args in every message.
+	With(args ...interface{}) Logger
+}
+
+// Keys used for the common structured fields of log messages.
+const (
+	LogAdapter = "adapter"
+	LogChannel = "channel"
+	LogUser    = "user"
+	LogHandler = "handler"
+)
+
+// DefaultLogger is used by the bot and adapters if no other Logger is
+// given. It logs to slog.Default().
+var DefaultLogger Logger = NewSlogLogger(nil)
+
+// NewSlogLogger returns a Logger that logs to l. If l is nil, messages
+// are logged to slog.Default().
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (sl slogLogger) logger() *slog.Logger {
+	if sl.l == nil {
+		return slog.Default()
+	}
+	return sl.l
+}
+
+func (sl slogLogger) Debug(msg string, args ...interface{}) {
+	sl.logger().Debug(msg, args...)
+}
+
+func (sl slogLogger) Info(msg string, args ...interface{}) {
+	sl.logger().Info(msg, args...)
+}
+
+func (sl slogLogger) Error(msg string, args ...interface{}) {
+	sl.logger().Error(msg, args...)
+}
+
+func (sl slogLogger) With(args ...interface{}) Logger {
+	return slogLogger{sl.logger().With(args...)}
+}
+
+// NewLoggerContext creates a context carrying the Logger to be used
+// by handlers. The bot adds the adapter, channel and user of the
+// message being processed.
+func NewLoggerContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// LoggerFromContext returns the Logger stored in a context, or
+// DefaultLogger if there is none.
+func LoggerFromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey).(Logger); ok {
+		return l
+	}
+	return DefaultLogger
+}
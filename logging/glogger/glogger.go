@@ -0,0 +1,83 @@
+// Copyright (c) 2016 Tristan Colgate-McFarlane
+//
+// This file is part of hugot.
+//
+// hugot is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// hugot is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with hugot.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package glogger provides a hugot.Logger that logs via glog, for
+// binaries that still want glog's flags and output format.
+package glogger
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/tcolgate/hugot"
+)
+
+type logger struct {
+	debug glog.Level
+	args  []interface{}
+}
+
+// New returns a hugot.Logger that logs via glog. Debug messages are only
+// logged if glog's verbosity is at least debug. Structured fields are
+// appended to the message as key=value pairs.
+func New(debug glog.Level) hugot.Logger {
+	return &logger{debug: debug}
+}
+
+func (l *logger) Debug(msg string, args ...interface{}) {
+	if glog.V(l.debug) {
+		glog.InfoDepth(1, l.format(msg, args))
+	}
+}
+
+func (l *logger) Info(msg string, args ...interface{}) {
+	glog.InfoDepth(1, l.format(msg, args))
+}
+
+func (l *logger) Error(msg string, args ...interface{}) {
+	glog.ErrorDepth(1, l.format(msg, args))
+}
+
+func (l *logger) With(args ...interface{}) hugot.Logger {
+	nargs := make([]interface{}, 0, len(l.args)+len(args))
+	nargs = append(nargs, l.args...)
+	nargs = append(nargs, args...)
+	return &logger{debug: l.debug, args: nargs}
+}
+
+func (l *logger) format(msg string, args []interface{}) string {
+	buf := bytes.NewBufferString(msg)
+	all := append(append([]interface{}{}, l.args...), args...)
+	for i := 0; i < len(all); i += 2 {
+		if i+1 == len(all) {
+			fmt.Fprintf(buf, " !BADKEY=%s", value(all[i]))
+			break
+		}
+		fmt.Fprintf(buf, " %v=%s", all[i], value(all[i+1]))
+	}
+	return buf.String()
+}
+
+func value(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
@@ -23,7 +23,6 @@ import (
 	"fmt"
 	"text/template"
 
-	"github.com/golang/glog"
 	"github.com/slack-go/slack"
 	"github.com/tcolgate/hugot/storage"
 )
@@ -41,7 +40,14 @@ type Message struct {
 
 	UserID string // Verified user identitify within the source adapter
 
-	Text        string // A plain text message
+	ID       string // The adapter's identifier for this message, if known
+	ThreadID string // The ID of the thread the message belongs to, if any
+
+	Text   string  // A plain text message
+	Blocks []Block // An optional rich layout, displayed after Text
+
+	// Deprecated: Attachments are only supported by some adapters, use
+	// Blocks.
 	Attachments []Attachment
 
 	Private bool
@@ -54,17 +60,22 @@ type Message struct {
 func (m *Message) Copy() *Message {
 	nm := *m
 	copy(nm.Attachments, m.Attachments)
+	nm.Blocks = append([]Block(nil), m.Blocks...)
 	return &nm
 }
 
 // Attachment represents a rich message attachment and is directly
 // modeled on the Slack attachments API
+//
+// Deprecated: use Blocks, which all adapters can render.
 type Attachment slack.Attachment
 
-// Reply returns a messsage with Text tx and the From and To fields switched
+// Reply returns a messsage with Text tx and the From and To fields switched.
+// If m was part of a thread, the reply will be sent to the same thread.
 func (m *Message) Reply(txt string) *Message {
 	out := *m
 	out.Text = txt
+	out.ID = ""
 
 	out.From = ""
 	out.To = m.From
@@ -72,6 +83,25 @@ func (m *Message) Reply(txt string) *Message {
 	return &out
 }
 
+// ReplyInThread returns a reply to m, as with Reply, that will be sent in
+// a thread started from m if m was not already part of one. Adapters that
+// do not support threads send the reply to the channel as normal.
+func (m *Message) ReplyInThread(txt string) *Message {
+	out := m.Reply(txt)
+	out.ThreadID = m.Thread()
+	return out
+}
+
+// Thread returns the ID of the thread a reply to m should be sent to in
+// order to keep the conversation in a thread. This is m's ThreadID, or its
+// ID if it is not already part of a thread.
+func (m *Message) Thread() string {
+	if m.ThreadID != "" {
+		return m.ThreadID
+	}
+	return m.ID
+}
+
 // Replyf returns message with txt set to the fmt.Printf style formatting,
 // and the from/to fields switched.
 func (m *Message) Replyf(s string, is ...interface{}) *Message {
@@ -83,7 +113,7 @@ func stringFromTemplate(tmpls *template.Template, name string, data interface{})
 		out := bytes.Buffer{}
 		err := tmpls.ExecuteTemplate(&out, name, data)
 		if err != nil {
-			glog.Infof("error expanding %v template, %v", name, err.Error())
+			DefaultLogger.Error("error expanding template", "template", name, "error", err)
 			return ""
 		}
 		return out.String()
@@ -94,6 +124,8 @@ func stringFromTemplate(tmpls *template.Template, name string, data interface{})
 // AttachmentFieldFromTemplates builds an attachment field from a set of templates
 // templates can include "field_title","field_value", and "field_short". "field_short"
 // should expand to "true" or "false".
+//
+// Deprecated: use a BlockBuilder.
 func AttachmentFieldFromTemplates(tmpls *template.Template, data interface{}) (slack.AttachmentField, error) {
 	fieldTitle := stringFromTemplate(tmpls, "field_title", data)
 	fieldValue := stringFromTemplate(tmpls, "field_title", data)
@@ -116,6 +148,8 @@ func AttachmentFieldFromTemplates(tmpls *template.Template, data interface{}) (s
 // thumb_url, pretext, text, fallback, fields_json.
 // fields_json will be parsed as json, and any fields found will be appended to those in the fields
 // arguments
+//
+// Deprecated: use a BlockBuilder.
 func AttachmentFromTemplates(tmpls *template.Template, data interface{}, fields ...slack.AttachmentField) (Attachment, error) {
 	title := stringFromTemplate(tmpls, "title", data)
 	titleLink := stringFromTemplate(tmpls, "title_link", data)
@@ -132,9 +166,9 @@ func AttachmentFromTemplates(tmpls *template.Template, data interface{}, fields
 	fieldsJSON := stringFromTemplate(tmpls, "fields_json", data)
 	var jfields []slack.AttachmentField
 	if fieldsJSON != "" {
-		err := json.Unmarshal([]byte(fieldsJSON), jfields)
+		err := json.Unmarshal([]byte(fieldsJSON), &jfields)
 		if err != nil {
-			glog.Infof("error parsing fields as json, ", err.Error())
+			DefaultLogger.Error("error parsing fields as json", "error", err)
 		}
 	}
 
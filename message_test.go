@@ -0,0 +1,27 @@
+package hugot_test
+
+import (
+	"testing"
+
+	"github.com/tcolgate/hugot"
+)
+
+func TestMessage_ReplyInThread(t *testing.T) {
+	m := &hugot.Message{ID: "1", From: "bob", Channel: "ops", Text: "deploy"}
+
+	if r := m.Reply("ok"); r.ThreadID != "" || r.ID != "" || r.To != "bob" {
+		t.Fatalf("unexpected reply %#v", r)
+	}
+
+	if r := m.ReplyInThread("ok"); r.ThreadID != "1" {
+		t.Fatalf("expected reply in thread 1, got %q", r.ThreadID)
+	}
+
+	m = &hugot.Message{ID: "2", ThreadID: "1", From: "bob", Channel: "ops", Text: "deploy"}
+	if r := m.Reply("ok"); r.ThreadID != "1" {
+		t.Fatalf("expected reply in thread 1, got %q", r.ThreadID)
+	}
+	if r := m.ReplyInThread("ok"); r.ThreadID != "1" {
+		t.Fatalf("expected reply in thread 1, got %q", r.ThreadID)
+	}
+}
@@ -0,0 +1,88 @@
+// Package metrics defines the Prometheus metrics exported by hugot's
+// bots, handlers and adapters. The metrics are registered with the
+// default Prometheus registry, and served by bot.DefaultBot under the
+// metrics web hook.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// MessagesReceived counts messages received, by adapter.
+	MessagesReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "hugot",
+			Name:      "messages_received_total",
+			Help:      "Count of messages received from adapters.",
+		},
+		[]string{"adapter"},
+	)
+
+	// SendFailures counts messages that adapters failed to send.
+	SendFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "hugot",
+			Name:      "adapter_send_failures_total",
+			Help:      "Count of messages adapters failed to send.",
+		},
+		[]string{"adapter"},
+	)
+
+	// CommandsExecuted counts commands run, by command and result. The
+	// result is one of ok, error or denied.
+	CommandsExecuted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "hugot",
+			Name:      "commands_total",
+			Help:      "Count of commands executed, by result.",
+		},
+		[]string{"command", "result"},
+	)
+
+	// HearsMatched counts messages matched by hears handlers.
+	HearsMatched = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "hugot",
+			Name:      "hears_matches_total",
+			Help:      "Count of messages matched by hears handlers.",
+		},
+		[]string{"handler", "pattern"},
+	)
+
+	// HandlerResults counts messages processed by handlers wrapped with
+	// the hugot.Metrics middleware, by result. The result is ok or error.
+	HandlerResults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "hugot",
+			Name:      "handler_results_total",
+			Help:      "Count of messages processed by handlers, by result.",
+		},
+		[]string{"handler", "result"},
+	)
+
+	// HandlerDuration observes the time taken by handlers to process
+	// messages. It is observed once for each handler a mux passes a
+	// message to, and by the hugot.Metrics middleware. For commands, the
+	// handler is the path of the command that was run.
+	HandlerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "hugot",
+			Name:      "handler_duration_seconds",
+			Help:      "Time taken by handlers to process messages.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 9),
+		},
+		[]string{"handler"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		MessagesReceived,
+		SendFailures,
+		CommandsExecuted,
+		HearsMatched,
+		HandlerResults,
+		HandlerDuration,
+	)
+}
@@ -0,0 +1,181 @@
+package hugot
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"time"
+
+	"github.com/tcolgate/hugot/metrics"
+)
+
+// Middleware wraps a Handler to add functionality, such as logging,
+// before or after messages are processed.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with each of the middlewares ms. The first middleware
+// is the outermost, and will see each message first.
+func Chain(h Handler, ms ...Middleware) Handler {
+	for i := len(ms) - 1; i >= 0; i-- {
+		h = ms[i](h)
+	}
+	return h
+}
+
+// The optional interfaces a wrapped handler may implement. These do not
+// include Describer so that they can be embedded together.
+type (
+	helper interface {
+		Help(ctx context.Context, w io.Writer) error
+	}
+	backgrounder interface {
+		StartBackground(ctx context.Context, w ResponseWriter)
+	}
+	webHooker interface {
+		URL() *url.URL
+		SetURL(*url.URL)
+		SetAdapter(Adapter)
+		http.Handler
+	}
+)
+
+type wrapper struct {
+	up Handler
+	f  HandlerFunc
+}
+
+func (w *wrapper) Describe() (string, string) {
+	return w.up.Describe()
+}
+
+func (w *wrapper) ProcessMessage(ctx context.Context, rw ResponseWriter, m *Message) error {
+	return w.f(ctx, rw, m)
+}
+
+// WrapHandler returns a Handler with the same description as up, that
+// calls f to process messages. The returned handler also implements
+// any of the Help (see mux.Helper), BackgroundHandler and WebHookHandler
+// interfaces that up implements, by calling the methods of up. It is
+// intended for use when writing Middleware.
+func WrapHandler(up Handler, f HandlerFunc) Handler {
+	w := &wrapper{up, f}
+
+	hh, isHelper := up.(helper)
+	bh, isBackground := up.(backgrounder)
+	wh, isWebHook := up.(webHooker)
+
+	switch {
+	case isHelper && isBackground && isWebHook:
+		return &struct {
+			*wrapper
+			helper
+			backgrounder
+			webHooker
+		}{w, hh, bh, wh}
+	case isHelper && isBackground:
+		return &struct {
+			*wrapper
+			helper
+			backgrounder
+		}{w, hh, bh}
+	case isHelper && isWebHook:
+		return &struct {
+			*wrapper
+			helper
+			webHooker
+		}{w, hh, wh}
+	case isBackground && isWebHook:
+		return &struct {
+			*wrapper
+			backgrounder
+			webHooker
+		}{w, bh, wh}
+	case isHelper:
+		return &struct {
+			*wrapper
+			helper
+		}{w, hh}
+	case isBackground:
+		return &struct {
+			*wrapper
+			backgrounder
+		}{w, bh}
+	case isWebHook:
+		return &struct {
+			*wrapper
+			webHooker
+		}{w, wh}
+	default:
+		return w
+	}
+}
+
+// Recover is a Middleware that recovers from any panic while processing
+// a message. The panic is logged, and returned as an error.
+func Recover(next Handler) Handler {
+	return WrapHandler(next, func(ctx context.Context, w ResponseWriter, m *Message) (err error) {
+		defer func() {
+			r := recover()
+			if r == nil || r == flag.ErrHelp {
+				return
+			}
+			n, _ := next.Describe()
+			LoggerFromContext(ctx).Error("panic in handler", LogHandler, n, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("handler %s failed unexpectedly", n)
+		}()
+		return next.ProcessMessage(ctx, w, m)
+	})
+}
+
+// Logging is a Middleware that logs each message processed, how long
+// it took, and any error returned.
+func Logging(next Handler) Handler {
+	return WrapHandler(next, func(ctx context.Context, w ResponseWriter, m *Message) error {
+		n, _ := next.Describe()
+		l := LoggerFromContext(ctx).With(LogHandler, n)
+		start := time.Now()
+		err := next.ProcessMessage(ctx, w, m)
+		if err != nil {
+			l.Info("processed message", "duration", time.Since(start), "error", err)
+		} else {
+			l.Debug("processed message", "duration", time.Since(start))
+		}
+		return err
+	})
+}
+
+// Timeout returns a Middleware that cancels the context passed to the
+// handler if it has not finished processing a message within d.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return WrapHandler(next, func(ctx context.Context, w ResponseWriter, m *Message) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next.ProcessMessage(ctx, w, m)
+		})
+	}
+}
+
+// Metrics is a Middleware that records the time taken to process each
+// message in metrics.HandlerDuration, and its result in
+// metrics.HandlerResults, labelled with the name of the handler. A Mux
+// already records the duration of each handler it passes messages to, so
+// Metrics is intended for handlers that are used without one.
+func Metrics(next Handler) Handler {
+	return WrapHandler(next, func(ctx context.Context, w ResponseWriter, m *Message) error {
+		n, _ := next.Describe()
+		start := time.Now()
+		err := next.ProcessMessage(ctx, w, m)
+		metrics.HandlerDuration.WithLabelValues(n).Observe(time.Since(start).Seconds())
+		res := "ok"
+		if err != nil {
+			res = "error"
+		}
+		metrics.HandlerResults.WithLabelValues(n, res).Inc()
+		return err
+	})
+}
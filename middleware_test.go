@@ -0,0 +1,111 @@
+package hugot_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tcolgate/hugot"
+	"github.com/tcolgate/hugot/handlers/basic"
+	"github.com/tcolgate/hugot/handlers/mux"
+	"github.com/tcolgate/hugot/metrics"
+)
+
+func TestChain_Order(t *testing.T) {
+	var order []string
+	mw := func(n string) hugot.Middleware {
+		return func(next hugot.Handler) hugot.Handler {
+			return hugot.WrapHandler(next, func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+				order = append(order, n)
+				return next.ProcessMessage(ctx, w, m)
+			})
+		}
+	}
+
+	h := basic.New("test", "test handler", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		order = append(order, "handler")
+		return nil
+	})
+
+	m := &hugot.Message{}
+	hugot.Chain(h, mw("one"), mw("two")).ProcessMessage(context.Background(), hugot.NewNullResponseWriter(*m), m)
+
+	if got := strings.Join(order, ","); got != "one,two,handler" {
+		t.Fatalf("expected middlewares to run in order, got %s", got)
+	}
+}
+
+type helpHandler struct {
+	hugot.Handler
+}
+
+func (helpHandler) Help(ctx context.Context, w io.Writer) error {
+	return nil
+}
+
+func TestWrapHandler_PreservesInterfaces(t *testing.T) {
+	var h hugot.Handler = hugot.Chain(mux.New("test", "test mux"), hugot.Logging, hugot.Recover)
+
+	if _, ok := h.(hugot.BackgroundHandler); !ok {
+		t.Errorf("expected wrapped mux to be a BackgroundHandler")
+	}
+	if _, ok := h.(hugot.WebHookHandler); !ok {
+		t.Errorf("expected wrapped mux to be a WebHookHandler")
+	}
+	if _, ok := h.(mux.Helper); ok {
+		t.Errorf("did not expect wrapped mux to be a Helper")
+	}
+	if n, _ := h.Describe(); n != "test" {
+		t.Errorf("expected wrapped handler to be named test, got %s", n)
+	}
+
+	h = hugot.Chain(helpHandler{basic.New("help", "", nil)}, hugot.Timeout(time.Second))
+	if _, ok := h.(mux.Helper); !ok {
+		t.Errorf("expected wrapped handler to be a Helper")
+	}
+	if _, ok := h.(hugot.BackgroundHandler); ok {
+		t.Errorf("did not expect wrapped handler to be a BackgroundHandler")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := basic.New("panicky", "panics", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		panic("oops")
+	})
+
+	m := &hugot.Message{}
+	err := hugot.Recover(h).ProcessMessage(context.Background(), hugot.NewNullResponseWriter(*m), m)
+	if err == nil {
+		t.Fatal("expected panic to be returned as an error")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	h := basic.New("flaky", "fails on request", func(ctx context.Context, w hugot.ResponseWriter, m *hugot.Message) error {
+		if m.Text == "fail" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	mh := hugot.Metrics(h)
+
+	ok := metrics.HandlerResults.WithLabelValues("flaky", "ok")
+	failed := metrics.HandlerResults.WithLabelValues("flaky", "error")
+	okBefore, failedBefore := testutil.ToFloat64(ok), testutil.ToFloat64(failed)
+
+	for _, txt := range []string{"hello", "fail", "again"} {
+		m := &hugot.Message{Text: txt}
+		mh.ProcessMessage(context.Background(), hugot.NewNullResponseWriter(*m), m)
+	}
+
+	if d := testutil.ToFloat64(ok) - okBefore; d != 2 {
+		t.Errorf("expected 2 ok results, got %v", d)
+	}
+	if d := testutil.ToFloat64(failed) - failedBefore; d != 1 {
+		t.Errorf("expected 1 error result, got %v", d)
+	}
+}
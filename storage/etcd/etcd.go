@@ -6,7 +6,6 @@ import (
 
 	"github.com/coreos/etcd/clientv3"
 
-	"github.com/golang/glog"
 	"github.com/tcolgate/hugot/storage"
 )
 
@@ -24,7 +23,6 @@ func New(cli *clientv3.Client) *Store {
 func (s *Store) Get(key []string) (string, bool, error) {
 	val, err := s.cli.Get(context.Background(), storage.PathToKey(key))
 	if err != nil {
-		glog.Info(err)
 		return "", false, err
 	}
 
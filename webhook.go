@@ -2,10 +2,9 @@ package hugot
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/url"
-
-	"github.com/golang/glog"
 )
 
 // WebHookHandler handlers are used to expose a registered handler via a web server.
@@ -60,9 +59,7 @@ func (bwhh *baseWebHookHandler) Describe() (string, string) {
 }
 
 func (bwhh *baseWebHookHandler) SetURL(u *url.URL) {
-	if glog.V(2) {
-		glog.Infof("SetURL to %s", *u)
-	}
+	DefaultLogger.Debug("webhook URL set", LogHandler, bwhh.name, "url", u)
 	bwhh.url = u
 }
 
@@ -71,8 +68,6 @@ func (bwhh *baseWebHookHandler) URL() *url.URL {
 }
 
 func (bwhh *baseWebHookHandler) SetAdapter(a Adapter) {
-	if glog.V(3) {
-		glog.Infof("WebHander adapter set to %#v", a)
-	}
+	DefaultLogger.Debug("webhook adapter set", LogHandler, bwhh.name, LogAdapter, fmt.Sprintf("%T", a))
 	bwhh.a = a
 }